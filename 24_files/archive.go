@@ -0,0 +1,579 @@
+// archive.go bundles directory trees into tar, tar.gz and zip archives and
+// unpacks them again
+// Files are streamed into and out of the archive with io.Copy, so archive
+// size is not limited by memory. Extraction treats every archive as
+// untrusted: entries are written through an os.Root, and names or link
+// targets that would land outside the target directory are refused.
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArchiveFormat is a supported archive type
+type ArchiveFormat string
+
+// Archive formats
+const (
+	FormatTar   ArchiveFormat = "tar"
+	FormatTarGz ArchiveFormat = "tar.gz"
+	FormatZip   ArchiveFormat = "zip"
+)
+
+// ErrUnsafePath is returned for an archive entry that would be written
+// outside the extraction directory
+var ErrUnsafePath = errors.New("unsafe path in archive")
+
+// formatFromName picks the format for a new archive from its file name
+func formatFromName(name string) (ArchiveFormat, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return FormatTarGz, nil
+	case strings.HasSuffix(lower, ".tar"):
+		return FormatTar, nil
+	case strings.HasSuffix(lower, ".zip"):
+		return FormatZip, nil
+	}
+	return "", fmt.Errorf("%s: unknown archive type (want .tar, .tar.gz, .tgz or .zip)", name)
+}
+
+// sniffFormat identifies an existing archive by its first bytes, so a
+// misnamed file is still read correctly
+func sniffFormat(f *os.File) (ArchiveFormat, error) {
+	magic := make([]byte, 4)
+	n, err := f.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	switch {
+	case bytes.HasPrefix(magic[:n], []byte{0x1f, 0x8b}):
+		return FormatTarGz, nil
+	case bytes.HasPrefix(magic[:n], []byte("PK\x03\x04")), bytes.HasPrefix(magic[:n], []byte("PK\x05\x06")):
+		return FormatZip, nil
+	}
+	return FormatTar, nil
+}
+
+// ArchiveEntry is one member of an archive, whatever the format
+type ArchiveEntry struct {
+	Name     string      // Slash-separated; directories do not end in '/'
+	Mode     fs.FileMode // Type bits and permissions
+	Size     int64
+	ModTime  time.Time
+	Linkname string // Target of a symbolic or hard link
+	HardLink bool
+}
+
+// ArchiveOptions control creating and extracting archives
+type ArchiveOptions struct {
+	// Progress, if set, is called as data moves with bytes done and the
+	// total; for extraction both count bytes of the archive file
+	Progress func(done, total int64)
+}
+
+// CreateArchive writes the trees at paths into a new archive at out
+// Each path is stored under its base name. The archive is written with
+// CreateAtomic, so a failed run leaves no partial archive behind.
+func CreateArchive(out string, paths []string, opts ArchiveOptions) error {
+	format, err := formatFromName(out)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, p := range paths {
+		total += treeSize(p)
+	}
+
+	tmp, err := CreateAtomic(out, 0o644)
+	if err != nil {
+		return err
+	}
+	defer tmp.Abort()
+
+	bw := bufio.NewWriterSize(tmp, 1<<20)
+	aw, err := newArchiveWriter(bw, format)
+	if err != nil {
+		return err
+	}
+	var done int64
+	progress := func(n int64) {
+		done += n
+		if opts.Progress != nil {
+			opts.Progress(done, total)
+		}
+	}
+	for _, p := range paths {
+		if err := addTree(aw, p, progress); err != nil {
+			return err
+		}
+	}
+	if err := aw.Close(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return tmp.Commit()
+}
+
+// archiveWriter hides the differences between tar and zip writers
+type archiveWriter interface {
+	// Add stores an entry; for regular files body is copied into it
+	Add(e ArchiveEntry, body io.Reader) error
+	Close() error
+}
+
+// newArchiveWriter returns the writer for format
+func newArchiveWriter(w io.Writer, format ArchiveFormat) (archiveWriter, error) {
+	switch format {
+	case FormatTar:
+		return &tarWriter{tw: tar.NewWriter(w)}, nil
+	case FormatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarWriter{tw: tar.NewWriter(gz), gz: gz}, nil
+	case FormatZip:
+		return &zipWriter{zw: zip.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// addTree adds root and everything below it, named from root's base name
+func addTree(aw archiveWriter, root string, progress func(int64)) error {
+	base := filepath.Dir(filepath.Clean(root))
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		e := ArchiveEntry{Name: filepath.ToSlash(rel), Mode: info.Mode(), ModTime: info.ModTime()}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			if e.Linkname, err = os.Readlink(p); err != nil {
+				return err
+			}
+			return aw.Add(e, nil)
+		case info.IsDir():
+			return aw.Add(e, nil)
+		case !info.Mode().IsRegular():
+			fmt.Fprintf(os.Stderr, "skipping %s: %s\n", p, typeName(info.Mode()))
+			return nil
+		}
+		e.Size = info.Size()
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return aw.Add(e, &countingReader{r: f, add: progress})
+	})
+}
+
+// tarWriter writes tar, optionally gzipped
+type tarWriter struct {
+	tw *tar.Writer
+	gz *gzip.Writer // nil for plain tar
+}
+
+func (w *tarWriter) Add(e ArchiveEntry, body io.Reader) error {
+	h := &tar.Header{
+		Name:     e.Name,
+		Mode:     int64(e.Mode.Perm()),
+		ModTime:  e.ModTime,
+		Format:   tar.FormatPAX,
+		Typeflag: tar.TypeReg,
+		Size:     e.Size,
+	}
+	switch {
+	case e.Mode.IsDir():
+		h.Typeflag, h.Name, h.Size = tar.TypeDir, e.Name+"/", 0
+	case e.Mode&fs.ModeSymlink != 0:
+		h.Typeflag, h.Linkname, h.Size = tar.TypeSymlink, e.Linkname, 0
+	}
+	if err := w.tw.WriteHeader(h); err != nil {
+		return err
+	}
+	if body == nil {
+		return nil
+	}
+	// A file that grew while being archived would overrun its header
+	_, err := io.Copy(w.tw, io.LimitReader(body, e.Size))
+	return err
+}
+
+func (w *tarWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// zipWriter writes zip
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipWriter) Add(e ArchiveEntry, body io.Reader) error {
+	h := &zip.FileHeader{Name: e.Name, Method: zip.Deflate, Modified: e.ModTime}
+	h.SetMode(e.Mode)
+	switch {
+	case e.Mode.IsDir():
+		h.Name, h.Method = e.Name+"/", zip.Store
+	case e.Mode&fs.ModeSymlink != 0:
+		// Zip stores a link's target as its contents
+		h.Method, body = zip.Store, strings.NewReader(e.Linkname)
+	}
+	fw, err := w.zw.CreateHeader(h)
+	if err != nil || body == nil {
+		return err
+	}
+	_, err = io.Copy(fw, body)
+	return err
+}
+
+func (w *zipWriter) Close() error { return w.zw.Close() }
+
+// WalkArchive calls fn for every entry of the archive at name, in order
+// For regular files body streams the contents; it is only valid until fn
+// returns. Link targets of zip symlinks are filled in from their contents.
+func WalkArchive(name string, opts ArchiveOptions, fn func(e ArchiveEntry, body io.Reader) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	format, err := sniffFormat(f)
+	if err != nil {
+		return err
+	}
+	var done int64
+	progress := func(n int64) {
+		done += n
+		if opts.Progress != nil {
+			opts.Progress(done, info.Size())
+		}
+	}
+	if format == FormatZip {
+		return walkZip(f, info.Size(), progress, fn)
+	}
+	return walkTar(&countingReader{r: bufio.NewReaderSize(f, 1<<20), add: progress}, format, fn)
+}
+
+// walkTar reads a tar stream, gunzipping it first for FormatTarGz
+func walkTar(r io.Reader, format ArchiveFormat, fn func(ArchiveEntry, io.Reader) error) error {
+	if format == FormatTarGz {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		e := ArchiveEntry{
+			Name:     strings.TrimSuffix(h.Name, "/"),
+			Mode:     h.FileInfo().Mode(),
+			Size:     h.Size,
+			ModTime:  h.ModTime,
+			Linkname: h.Linkname,
+			HardLink: h.Typeflag == tar.TypeLink,
+		}
+		var body io.Reader
+		if h.Typeflag == tar.TypeReg {
+			body = tr
+		}
+		if err := fn(e, body); err != nil {
+			return err
+		}
+	}
+}
+
+// walkZip reads a zip file's central directory and then each entry
+func walkZip(f *os.File, size int64, progress func(int64), fn func(ArchiveEntry, io.Reader) error) error {
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		e := ArchiveEntry{
+			Name:    strings.TrimSuffix(zf.Name, "/"),
+			Mode:    zf.Mode(),
+			Size:    int64(zf.UncompressedSize64),
+			ModTime: zf.Modified,
+		}
+		if err := walkZipEntry(zf, e, fn); err != nil {
+			return err
+		}
+		progress(int64(zf.CompressedSize64))
+	}
+	return nil
+}
+
+// walkZipEntry opens one zip member and hands it to fn
+func walkZipEntry(zf *zip.File, e ArchiveEntry, fn func(ArchiveEntry, io.Reader) error) error {
+	if e.Mode.IsDir() {
+		return fn(e, nil)
+	}
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if e.Mode&fs.ModeSymlink != 0 {
+		target, err := io.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return err
+		}
+		e.Linkname = string(target)
+		return fn(e, nil)
+	}
+	return fn(e, rc)
+}
+
+// ExtractArchive unpacks the archive at name into dir, creating dir if
+// needed
+// Permissions and modification times are restored; ownership is not.
+func ExtractArchive(name, dir string, opts ArchiveOptions) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// Every file operation below goes through root, which refuses to
+	// resolve any path, symlinks included, to somewhere outside dir
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	type dirTime struct {
+		name  string
+		mtime time.Time
+	}
+	var dirs []dirTime // Applied last: creating files inside changes them
+	var links []string // Checked again at the end, see below
+	err = WalkArchive(name, opts, func(e ArchiveEntry, body io.Reader) error {
+		target := filepath.FromSlash(e.Name)
+		if !filepath.IsLocal(target) {
+			return fmt.Errorf("%w: %q", ErrUnsafePath, e.Name)
+		}
+		if parent := filepath.Dir(target); parent != "." {
+			if err := root.MkdirAll(parent, 0o755); err != nil {
+				return err
+			}
+		}
+		switch {
+		case e.Mode.IsDir():
+			if err := root.MkdirAll(target, e.Mode.Perm()|0o700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirTime{target, e.ModTime})
+			return nil
+		case e.HardLink:
+			if !filepath.IsLocal(filepath.FromSlash(e.Linkname)) {
+				return fmt.Errorf("%w: hard link %q -> %q", ErrUnsafePath, e.Name, e.Linkname)
+			}
+			root.Remove(target)
+			return root.Link(filepath.FromSlash(e.Linkname), target)
+		case e.Mode&fs.ModeSymlink != 0:
+			// The link must resolve inside dir from where it sits
+			if !linkStaysInside(root, e.Name, e.Linkname) {
+				return fmt.Errorf("%w: symlink %q -> %q", ErrUnsafePath, e.Name, e.Linkname)
+			}
+			root.Remove(target)
+			links = append(links, e.Name)
+			return root.Symlink(filepath.FromSlash(e.Linkname), target)
+		case body == nil:
+			fmt.Fprintf(os.Stderr, "skipping %s: %s\n", e.Name, typeName(e.Mode))
+			return nil
+		}
+
+		// Replace rather than write through whatever is there, which may be
+		// a link placed by an earlier entry
+		root.Remove(target)
+		f, err := root.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, body); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if err := root.Chmod(target, e.Mode.Perm()); err != nil {
+			return err
+		}
+		return root.Chtimes(target, time.Time{}, e.ModTime)
+	})
+	if err != nil {
+		return err
+	}
+	// A link extracted later can change where an earlier one leads: with
+	// d/x -> y/.. in place, d/y -> .. makes x point above dir. Whatever
+	// still escapes in the finished tree is removed.
+	for _, name := range links {
+		target, err := root.Readlink(filepath.FromSlash(name))
+		if err != nil {
+			return err
+		}
+		if !linkStaysInside(root, name, filepath.ToSlash(target)) {
+			root.Remove(filepath.FromSlash(name))
+			return fmt.Errorf("%w: symlink %q -> %q", ErrUnsafePath, name, target)
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		root.Chtimes(dirs[i].name, time.Time{}, dirs[i].mtime)
+	}
+	return nil
+}
+
+// linkStaysInside reports whether a symlink at name with the given target
+// resolves inside root, following the links already in root on the way
+// Joining name and target as text is not enough: with d/up -> .. in place,
+// d/x -> up/../z reads as d/z but the system resolves it to ../z.
+func linkStaysInside(root *os.Root, name, target string) bool {
+	if path.IsAbs(target) || filepath.IsAbs(filepath.FromSlash(target)) || filepath.VolumeName(filepath.FromSlash(target)) != "" {
+		return false
+	}
+	todo := append(strings.Split(path.Dir(name), "/"), strings.Split(target, "/")...)
+	var done []string // Resolved elements so far, none of them a link
+	for hops := 0; len(todo) > 0; {
+		elem := todo[0]
+		todo = todo[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(done) == 0 {
+				return false
+			}
+			done = done[:len(done)-1]
+			continue
+		}
+		p := filepath.Join(append(done, elem)...)
+		info, err := root.Lstat(p)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			// Not a link, or not there yet: later entries are checked
+			// again once the tree is complete
+			done = append(done, elem)
+			continue
+		}
+		if hops++; hops > 40 {
+			return false // A loop, or near enough
+		}
+		link, err := root.Readlink(p)
+		if err != nil || filepath.IsAbs(link) || filepath.VolumeName(link) != "" || path.IsAbs(filepath.ToSlash(link)) {
+			return false
+		}
+		todo = append(strings.Split(filepath.ToSlash(link), "/"), todo...)
+	}
+	return true
+}
+
+// countingReader reports the bytes read through it
+type countingReader struct {
+	r   io.Reader
+	add func(n int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.add(int64(n))
+	}
+	return n, err
+}
+
+func init() {
+	commands["archive"] = command{
+		usage: "create [-progress] out.{tar,tar.gz,tgz,zip} path... | list archive | extract [-progress] archive dir",
+		help:  "create, list or extract tar, tar.gz and zip archives",
+		run:   runArchive,
+	}
+}
+
+// runArchive implements "files archive"
+func runArchive(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	flags := newFlagSet("archive")
+	showProgress := flags.Bool("progress", false, "show progress on stderr")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	var opts ArchiveOptions
+	if *showProgress {
+		opts.Progress = progressPrinter(args[0])
+	}
+
+	switch args[0] {
+	case "create":
+		if flags.NArg() < 2 {
+			return errUsage
+		}
+		if err := CreateArchive(flags.Arg(0), flags.Args()[1:], opts); err != nil {
+			return err
+		}
+		fmt.Println("created", flags.Arg(0))
+		return nil
+	case "list":
+		if flags.NArg() != 1 {
+			return errUsage
+		}
+		return WalkArchive(flags.Arg(0), opts, func(e ArchiveEntry, _ io.Reader) error {
+			name := e.Name
+			if e.Linkname != "" {
+				name += " -> " + e.Linkname
+			}
+			fmt.Printf("%s %10d %s %s\n", e.Mode, e.Size, e.ModTime.Local().Format(time.DateTime), name)
+			return nil
+		})
+	case "extract":
+		if flags.NArg() != 2 {
+			return errUsage
+		}
+		if err := ExtractArchive(flags.Arg(0), flags.Arg(1), opts); err != nil {
+			return err
+		}
+		fmt.Println("extracted into", flags.Arg(1))
+		return nil
+	}
+	return errUsage
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "tree")
+	writeFiles(t, src, map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo", "sub/deeper/empty": ""})
+	if err := os.Chmod(filepath.Join(src, "sub", "b.txt"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "a.txt"), time.Time{}, mtime); err != nil {
+		t.Fatal(err)
+	}
+	hasLink := os.Symlink("a.txt", filepath.Join(src, "link")) == nil
+
+	for _, ext := range []string{".tar", ".tar.gz", ".zip"} {
+		t.Run(ext, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "out"+ext)
+			if err := CreateArchive(archive, []string{src}, ArchiveOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			err := WalkArchive(archive, ArchiveOptions{}, func(e ArchiveEntry, _ io.Reader) error {
+				names = append(names, e.Name)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(names) == 0 || names[0] != "tree" {
+				t.Errorf("entries %v, want them under tree", names)
+			}
+
+			dir := t.TempDir()
+			if err := ExtractArchive(archive, dir, ArchiveOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			out := filepath.Join(dir, "tree")
+			for name, want := range map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo", "sub/deeper/empty": ""} {
+				if got, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name))); err != nil || string(got) != want {
+					t.Errorf("%s: got %q, %v; want %q", name, got, err, want)
+				}
+			}
+			if info, err := os.Stat(filepath.Join(out, "a.txt")); err != nil || !info.ModTime().Equal(mtime) {
+				t.Errorf("a.txt mtime: got %v, %v; want %v", info.ModTime(), err, mtime)
+			}
+			if info, err := os.Stat(filepath.Join(out, "sub", "b.txt")); runtime.GOOS != "windows" && (err != nil || info.Mode().Perm() != 0o755) {
+				t.Errorf("sub/b.txt mode: got %v, %v; want 0755", info.Mode(), err)
+			}
+			if hasLink {
+				if target, err := os.Readlink(filepath.Join(out, "link")); err != nil || target != "a.txt" {
+					t.Errorf("link: got %q, %v", target, err)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractRefusesTraversal(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{"parent", []tarEntry{{Name: "../evil", Body: "x"}}},
+		{"parent inside the name", []tarEntry{{Name: "a/../../evil", Body: "x"}}},
+		{"absolute", []tarEntry{{Name: "/tmp/evil", Body: "x"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "out")
+			if err := ExtractArchive(writeTar(t, tt.entries), dir, ArchiveOptions{}); !errors.Is(err, ErrUnsafePath) {
+				t.Fatalf("got %v, want ErrUnsafePath", err)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "evil")); err == nil {
+				t.Error("wrote outside the extraction directory")
+			}
+		})
+	}
+
+	t.Run("zip", func(t *testing.T) {
+		archive := filepath.Join(t.TempDir(), "evil.zip")
+		f, err := os.Create(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw := zip.NewWriter(f)
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "../evil", Method: zip.Store})
+		if err == nil {
+			_, err = w.Write([]byte("x"))
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := filepath.Join(t.TempDir(), "out")
+		if err := ExtractArchive(archive, dir, ArchiveOptions{}); !errors.Is(err, ErrUnsafePath) {
+			t.Fatalf("got %v, want ErrUnsafePath", err)
+		}
+	})
+
+	t.Run("hard link", func(t *testing.T) {
+		archive := filepath.Join(t.TempDir(), "evil.tar")
+		f, err := os.Create(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw := tar.NewWriter(f)
+		err = tw.WriteHeader(&tar.Header{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"})
+		if err == nil {
+			err = tw.Close()
+		}
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ExtractArchive(archive, t.TempDir(), ArchiveOptions{}); !errors.Is(err, ErrUnsafePath) {
+			t.Fatalf("got %v, want ErrUnsafePath", err)
+		}
+	})
+}
+
+// tarEntry is one member of an archive written by writeTar; a Linkname
+// makes it a symlink, otherwise it is a file unless Name ends in '/'
+type tarEntry struct {
+	Name, Body, Linkname string
+}
+
+// writeTar writes entries to a tar file in a new temporary directory
+func writeTar(t *testing.T, entries []tarEntry) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "test.tar")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.Name, Mode: 0o644, Size: int64(len(e.Body)), Typeflag: tar.TypeReg}
+		switch {
+		case e.Linkname != "":
+			h.Typeflag, h.Linkname, h.Mode = tar.TypeSymlink, e.Linkname, 0o777
+		case e.Name[len(e.Name)-1] == '/':
+			h.Typeflag, h.Mode = tar.TypeDir, 0o755
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.Body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestExtractRefusesEscapingLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{"parent", []tarEntry{{Name: "evil", Linkname: "../z"}}},
+		{"parent from subdirectory", []tarEntry{{Name: "d/"}, {Name: "d/evil", Linkname: "../../z"}}},
+		{"absolute", []tarEntry{{Name: "evil", Linkname: "/etc/passwd"}}},
+		{"chained through an extracted link", []tarEntry{
+			{Name: "d/up", Linkname: ".."},
+			{Name: "d/x", Linkname: "up/../z"},
+		}},
+		{"redirected by a later link", []tarEntry{
+			{Name: "d/x", Linkname: "y/.."},
+			{Name: "d/y", Linkname: ".."},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := writeTar(t, tt.entries)
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "out")
+			err := ExtractArchive(archive, dir, ArchiveOptions{})
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Fatalf("got %v, want ErrUnsafePath", err)
+			}
+			if _, err := os.Lstat(filepath.Join(parent, "z")); err == nil {
+				t.Error("wrote outside the extraction directory")
+			}
+			for _, link := range []string{"evil", "d/evil", "d/x"} {
+				if target, err := filepath.EvalSymlinks(filepath.Join(dir, link)); err == nil {
+					if rel, _ := filepath.Rel(dir, target); !filepath.IsLocal(rel) {
+						t.Errorf("%s left pointing at %s", link, target)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExtractKeepsLinksInside(t *testing.T) {
+	archive := writeTar(t, []tarEntry{
+		{Name: "f.txt", Body: "hello"},
+		{Name: "d/up", Linkname: ".."},
+		{Name: "d/f", Linkname: "up/f.txt"},
+		{Name: "d/g", Linkname: "../f.txt"},
+	})
+	dir := t.TempDir()
+	if err := ExtractArchive(archive, dir, ArchiveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{"d/f", "d/g"} {
+		if got, err := os.ReadFile(filepath.Join(dir, link)); err != nil || string(got) != "hello" {
+			t.Errorf("%s: got %q, %v", link, got, err)
+		}
+	}
+}
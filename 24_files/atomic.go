@@ -0,0 +1,101 @@
+// atomic.go replaces files without ever exposing a half-written one
+// os.Create truncates the file and then writes into it in place, so a crash
+// midway leaves neither the old contents nor the new. CreateAtomic writes to
+// a temporary file beside the target instead; Commit flushes it to disk,
+// renames it over the target, then flushes the directory so that the rename
+// itself survives a crash.
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+)
+
+// AtomicFile is a file being written that replaces its target on Commit
+type AtomicFile struct {
+	*os.File // The temporary file; write to it as usual
+	target   string
+	perm     fs.FileMode
+	done     bool // Committed or aborted
+}
+
+// CreateAtomic starts writing a file that Commit will put in place of name
+// with permissions perm
+func CreateAtomic(name string, perm fs.FileMode) (*AtomicFile, error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicFile{File: f, target: name, perm: perm}, nil
+}
+
+// Commit syncs and closes the temporary file and renames it over the target
+// On failure the temporary file is removed and the target left as it was.
+func (a *AtomicFile) Commit() error {
+	if a.done {
+		return os.ErrClosed
+	}
+	a.done = true
+	err := a.Chmod(a.perm)
+	if err == nil {
+		err = a.Sync()
+	}
+	if cerr := a.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(a.Name(), a.target)
+	}
+	if err != nil {
+		os.Remove(a.Name())
+		return err
+	}
+	return syncDir(filepath.Dir(a.target))
+}
+
+// Abort discards the temporary file
+// After Commit it does nothing, so it can be deferred right after
+// CreateAtomic to clean up on every error path.
+func (a *AtomicFile) Abort() error {
+	if a.done {
+		return nil
+	}
+	a.done = true
+	a.Close()
+	return os.Remove(a.Name())
+}
+
+// WriteFileAtomic is os.WriteFile done with CreateAtomic
+func WriteFileAtomic(name string, data []byte, perm fs.FileMode) error {
+	a, err := CreateAtomic(name, perm)
+	if err != nil {
+		return err
+	}
+	defer a.Abort()
+	if _, err := a.Write(data); err != nil {
+		return err
+	}
+	return a.Commit()
+}
+
+// syncDir flushes the directory entries of dir to disk
+// Windows cannot sync a directory and does not need to; some file systems
+// elsewhere reject it with EINVAL, which means the same.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// commands.go turns the file demo into a small multi-command tool
+// Run without arguments, the program still walks through the examples in
+// files.go; with a command name it runs that command instead. This
+// directory is its own module, so run it from here with "go run .", which
+// builds every file of the package together:
+//
+//	cd 24_files
+//	go run .
+//	go run . cp -verify ex.txt backup.txt
+//	go run . search -i hello .
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command is one subcommand of the tool
+type command struct {
+	usage string // Argument synopsis, e.g. "[flags] src dst"
+	help  string // One-line description
+	run   func(args []string) error
+}
+
+// commands holds every subcommand by name; each file registers its own in init
+var commands = map[string]command{}
+
+// errUsage makes runCommand print the command's usage and exit with status 2
+var errUsage = errors.New("usage")
+
+// runCommand runs the subcommand named by args[0] and returns the exit status
+func runCommand(args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		printCommands()
+		return 2
+	}
+	err := cmd.run(args[1:])
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+		fmt.Fprintf(os.Stderr, "usage: files %s %s\n", args[0], cmd.usage)
+		return 2
+	default:
+		fmt.Fprintf(os.Stderr, "files %s: %v\n", args[0], err)
+		return 1
+	}
+}
+
+// printCommands lists the available commands on stderr
+func printCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: files <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, commands[name].help)
+	}
+}
+
+// newFlagSet returns a FlagSet that reports errors instead of exiting
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: files %s %s\n", name, commands[name].usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
@@ -0,0 +1,183 @@
+// copy.go implements file copying that is fast, safe and checkable
+// Unlike the byte-at-a-time loop this replaced, it moves data in large
+// chunks (on Linux the kernel copies them with copy_file_range, without the
+// data passing through this program), keeps the source's permissions and
+// modification time, and never leaves a half-written destination behind.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// copyChunk is how much is copied between progress reports
+const copyChunk = 8 << 20 // 8 MiB
+
+// progressThreshold is the size from which the cp command shows progress
+const progressThreshold = 64 << 20 // 64 MiB
+
+// CopyOptions control CopyFile
+type CopyOptions struct {
+	// Verify re-reads source and copy and compares their SHA-256 before the
+	// copy replaces the destination
+	Verify bool
+	// Progress, if set, is called after every chunk with the bytes copied
+	// so far and the total
+	Progress func(done, total int64)
+}
+
+// ErrVerifyFailed is returned when the copy does not match the source
+var ErrVerifyFailed = errors.New("copy does not match source")
+
+// CopyFile copies the regular file src to dst
+// The data goes through CreateAtomic (atomic.go), so readers of dst see
+// either the old or the new contents, never a mix.
+func CopyFile(src, dst string, opts CopyOptions) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s: not a regular file", src)
+	}
+
+	tmp, err := CreateAtomic(dst, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer tmp.Abort()
+
+	if err := copyChunks(tmp.File, in, info.Size(), opts.Progress); err != nil {
+		return err
+	}
+	if opts.Verify {
+		if err := verifySame(in, tmp.File); err != nil {
+			return err
+		}
+	}
+	if err := os.Chtimes(tmp.Name(), time.Time{}, info.ModTime()); err != nil {
+		return err
+	}
+	return tmp.Commit()
+}
+
+// copyChunks copies size bytes from in to out
+// io.CopyN hands the *os.File pair to out.ReadFrom, which is what lets the
+// kernel do the copy.
+func copyChunks(out, in *os.File, size int64, progress func(done, total int64)) error {
+	var done int64
+	for {
+		n, err := io.CopyN(out, in, copyChunk)
+		done += n
+		if progress != nil && n > 0 {
+			progress(done, size)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// verifySame compares the SHA-256 of two files from their start
+func verifySame(a, b *os.File) error {
+	sumA, err := fileSHA256(a)
+	if err != nil {
+		return err
+	}
+	sumB, err := fileSHA256(b)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sumA, sumB) {
+		return fmt.Errorf("%w: %s", ErrVerifyFailed, a.Name())
+	}
+	return nil
+}
+
+// fileSHA256 hashes f from offset 0, whatever its current position
+func fileSHA256(f *os.File) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, 1<<62)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// humanBytes formats n as B, KiB, MiB, ...
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// progressPrinter returns a CopyOptions.Progress that redraws one status
+// line on stderr
+func progressPrinter(name string) func(done, total int64) {
+	return func(done, total int64) {
+		pct := 100.0
+		if total > 0 {
+			pct = float64(done) * 100 / float64(total)
+		}
+		fmt.Fprintf(os.Stderr, "\r%s: %s / %s (%.0f%%)", name, humanBytes(done), humanBytes(total), pct)
+		if done >= total {
+			fmt.Fprintln(os.Stderr)
+		}
+	}
+}
+
+func init() {
+	commands["cp"] = command{
+		usage: "[-verify] [-progress] src dst",
+		help:  "copy a file atomically, keeping its mode and mtime",
+		run:   runCopy,
+	}
+}
+
+// runCopy implements "files cp"
+func runCopy(args []string) error {
+	fs := newFlagSet("cp")
+	verify := fs.Bool("verify", false, "compare SHA-256 of source and copy")
+	progress := fs.Bool("progress", false, "always show progress (default: only for files over 64 MiB)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+	src, dst := fs.Arg(0), fs.Arg(1)
+	// Like cp(1), copying into a directory keeps the file name
+	if fi, err := os.Stat(dst); err == nil && fi.IsDir() {
+		dst = filepath.Join(dst, filepath.Base(src))
+	}
+
+	opts := CopyOptions{Verify: *verify}
+	if fi, err := os.Stat(src); err == nil && (*progress || fi.Size() >= progressThreshold) {
+		opts.Progress = progressPrinter(filepath.Base(src))
+	}
+	if err := CopyFile(src, dst, opts); err != nil {
+		return err
+	}
+	fmt.Printf("copied %s -> %s\n", src, dst)
+	return nil
+}
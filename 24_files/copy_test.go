@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCopyFile(t *testing.T) {
+	mtime := time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)
+	tests := []struct {
+		name    string
+		size    int
+		mode    os.FileMode
+		verify  bool
+		replace bool // dst already exists
+	}{
+		{"empty", 0, 0o644, false, false},
+		{"small", 3, 0o600, false, false},
+		{"verified", 100 << 10, 0o640, true, false},
+		{"replaces", 5000, 0o644, true, true},
+		{"executable", 10, 0o755, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+			data := bytes.Repeat([]byte("0123456789abcdef"), tt.size/16+1)[:tt.size]
+			if err := os.WriteFile(src, data, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(src, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(src, time.Time{}, mtime); err != nil {
+				t.Fatal(err)
+			}
+			if tt.replace {
+				if err := os.WriteFile(dst, []byte("old contents"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := CopyFile(src, dst, CopyOptions{Verify: tt.verify}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("copied %d bytes that differ from the %d of the source", len(got), len(data))
+			}
+			info, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.ModTime().Equal(mtime) {
+				t.Errorf("got mtime %v, want %v", info.ModTime(), mtime)
+			}
+			if runtime.GOOS != "windows" && info.Mode().Perm() != tt.mode {
+				t.Errorf("got mode %v, want %v", info.Mode().Perm(), tt.mode)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+				t.Errorf("temporary files left behind: %v", entries)
+			}
+		})
+	}
+}
+
+func TestCopyFileRefusesDirectory(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst")
+	if err := CopyFile(dir, dst, CopyOptions{}); err == nil {
+		t.Fatal("copied a directory")
+	}
+	if _, err := os.Stat(dst); err == nil {
+		t.Error("destination created")
+	}
+}
+
+func TestVerifySame(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "same", "b": "same", "c": "diff"})
+	open := func(name string) *os.File {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	if err := verifySame(open("a"), open("b")); err != nil {
+		t.Errorf("equal files: %v", err)
+	}
+	if err := verifySame(open("a"), open("c")); !errors.Is(err, ErrVerifyFailed) {
+		t.Errorf("different files: got %v, want ErrVerifyFailed", err)
+	}
+}
@@ -0,0 +1,414 @@
+// dedupe.go finds files with identical contents
+// Comparing every file with every other would read the whole tree many
+// times over. FindDuplicates narrows the candidates in three passes instead,
+// each more costly than the last and each run only on what the one before
+// left: files of equal size, then equal hashes of their first and last
+// 4 KiB, then equal SHA-256 of the whole file.
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// partialHashLen is how much of each end of a file the second pass reads
+const partialHashLen = 4 << 10
+
+// DedupeOptions control FindDuplicates
+type DedupeOptions struct {
+	MinSize int64                        // Smaller files are skipped; 0 means 1, as empty files are all alike
+	Workers int                          // Files hashed at once; 0 means one per CPU
+	OnError func(path string, err error) // Called for files that cannot be read; nil skips them silently
+}
+
+// DuplicateSet is a group of files with the same contents
+type DuplicateSet struct {
+	Size   int64
+	SHA256 string   // Hex
+	Paths  []string // Sorted; Paths[0] is the copy that is kept
+}
+
+// Wasted returns the bytes taken up by all copies but one
+func (s DuplicateSet) Wasted() int64 { return s.Size * int64(len(s.Paths)-1) }
+
+// FindDuplicates returns the sets of identical files below paths, the most
+// wasteful first
+// Paths that are already hard links to one file count once, and so does a
+// file reached from two overlapping paths, such as d and d/sub.
+func FindDuplicates(paths []string, opts DedupeOptions) ([]DuplicateSet, error) {
+	minSize := max(opts.MinSize, 1)
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	onError := opts.OnError
+	if onError == nil {
+		onError = func(string, error) {}
+	}
+
+	type fileKey struct{ dev, ino uint64 }
+	seen := make(map[fileKey]bool)
+	walked := make(map[string]bool) // Absolute paths, for where fileID is 0
+	sizes := make(map[string]int64)
+	bySize := make(map[int64][]string)
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				onError(p, err)
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				onError(p, err)
+				return nil
+			}
+			if info.Size() < minSize {
+				return nil
+			}
+			abs, err := filepath.Abs(p)
+			if err != nil {
+				onError(p, err)
+				return nil
+			}
+			if walked[abs] {
+				return nil
+			}
+			walked[abs] = true
+			if id := fileID(info); id != 0 {
+				k := fileKey{fileDev(info), id}
+				if seen[k] {
+					return nil
+				}
+				seen[k] = true
+			}
+			sizes[p] = info.Size()
+			bySize[info.Size()] = append(bySize[info.Size()], p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var groups [][]string
+	for _, ps := range bySize {
+		if len(ps) > 1 {
+			groups = append(groups, ps)
+		}
+	}
+	groups, _ = refineGroups(groups, workers, partialHash, onError)
+	groups, sums := refineGroups(groups, workers, fullHash, onError)
+
+	sets := make([]DuplicateSet, 0, len(groups))
+	for _, g := range groups {
+		sort.Strings(g)
+		sets = append(sets, DuplicateSet{Size: sizes[g[0]], SHA256: sums[g[0]], Paths: g})
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		if sets[i].Wasted() != sets[j].Wasted() {
+			return sets[i].Wasted() > sets[j].Wasted()
+		}
+		return sets[i].Paths[0] < sets[j].Paths[0]
+	})
+	return sets, nil
+}
+
+// refineGroups hashes every file in groups with workers goroutines and
+// splits each group by hash, dropping files left without a twin
+func refineGroups(groups [][]string, workers int, hash func(string) (string, error), onError func(string, error)) ([][]string, map[string]string) {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		sums = make(map[string]string)
+		jobs = make(chan string)
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				sum, err := hash(p)
+				mu.Lock()
+				if err != nil {
+					onError(p, err)
+				} else {
+					sums[p] = sum
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, g := range groups {
+		for _, p := range g {
+			jobs <- p
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	var refined [][]string
+	for _, g := range groups {
+		byHash := make(map[string][]string)
+		for _, p := range g {
+			if sum, ok := sums[p]; ok {
+				byHash[sum] = append(byHash[sum], p)
+			}
+		}
+		for _, ps := range byHash {
+			if len(ps) > 1 {
+				refined = append(refined, ps)
+			}
+		}
+	}
+	return refined, sums
+}
+
+// partialHash hashes the first and last partialHashLen bytes of a file
+func partialHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, partialHashLen)); err != nil {
+		return "", err
+	}
+	if tail := info.Size() - partialHashLen; tail > partialHashLen {
+		if _, err := io.Copy(h, io.NewSectionReader(f, tail, partialHashLen)); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fullHash returns the hex SHA-256 of a file
+func fullHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sum, err := fileSHA256(f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// LinkDuplicates replaces every copy in s but Paths[0] with a hard link to
+// Paths[0] and returns how many it replaced
+// Each copy is compared with the kept file again first, in case either
+// changed since the scan, and the link is renamed over it so the path never
+// goes missing. The links share the kept file's permissions and times.
+// Hard links cannot cross file systems; splitByDevice divides a set first.
+func LinkDuplicates(s DuplicateSet) (int, error) {
+	keep := s.Paths[0]
+	for i, p := range s.Paths[1:] {
+		if err := checkStillSame(keep, p); err != nil {
+			return i, err
+		}
+		tmp := filepath.Join(filepath.Dir(p), "."+filepath.Base(p)+".link-tmp")
+		os.Remove(tmp) // Left over from an earlier failed run
+		if err := os.Link(keep, tmp); err != nil {
+			return i, err
+		}
+		if err := os.Rename(tmp, p); err != nil {
+			os.Remove(tmp)
+			return i, err
+		}
+	}
+	return len(s.Paths) - 1, nil
+}
+
+// TrashDuplicates moves every copy in s but Paths[0] into t, after checking
+// it still matches Paths[0]
+func TrashDuplicates(s DuplicateSet, t *Trash) ([]*TrashEntry, error) {
+	var entries []*TrashEntry
+	for _, p := range s.Paths[1:] {
+		if err := checkStillSame(s.Paths[0], p); err != nil {
+			return entries, err
+		}
+		e, err := t.Put(p, true)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// splitByDevice divides s into one set per file system its copies are on,
+// dropping files that end up alone
+// The second result lists the dropped files.
+func splitByDevice(s DuplicateSet) ([]DuplicateSet, []string, error) {
+	var devs []uint64
+	byDev := make(map[uint64][]string)
+	for _, p := range s.Paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		dev := fileDev(info)
+		if _, ok := byDev[dev]; !ok {
+			devs = append(devs, dev)
+		}
+		byDev[dev] = append(byDev[dev], p)
+	}
+	var sets []DuplicateSet
+	var alone []string
+	for _, dev := range devs {
+		if ps := byDev[dev]; len(ps) > 1 {
+			sets = append(sets, DuplicateSet{Size: s.Size, SHA256: s.SHA256, Paths: ps})
+		} else {
+			alone = append(alone, ps[0])
+		}
+	}
+	return sets, alone, nil
+}
+
+// checkStillSame fails unless keep and dup are still two files with the
+// same contents
+// Two names for one file would both be lost by trashing one of them, so
+// they are refused rather than treated as a match.
+func checkStillSame(keep, dup string) error {
+	ki, err := os.Stat(keep)
+	if err != nil {
+		return err
+	}
+	di, err := os.Stat(dup)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(ki, di) {
+		return fmt.Errorf("%s and %s are the same file; leaving it alone", dup, keep)
+	}
+	same, err := sameContent(keep, dup)
+	if err != nil {
+		return err
+	}
+	if !same {
+		return fmt.Errorf("%s no longer matches %s; leaving it alone", dup, keep)
+	}
+	return nil
+}
+
+func init() {
+	commands["dupes"] = command{
+		usage: "[-min-size n] [-workers n] [-link | -delete [-trash dir]] [-yes] [path...]",
+		help:  "find duplicate files; optionally hard-link or trash the copies",
+		run:   runDupes,
+	}
+}
+
+// runDupes implements "files dupes"
+func runDupes(args []string) error {
+	flags := newFlagSet("dupes")
+	minSize := flags.String("min-size", "1", "skip files smaller than this, e.g. 4K")
+	workers := flags.Int("workers", runtime.NumCPU(), "files hashed at once")
+	link := flags.Bool("link", false, "replace copies with hard links to the first")
+	del := flags.Bool("delete", false, "move copies to the trash")
+	yes := flags.Bool("yes", false, "do not ask before -link or -delete")
+	dir := flags.String("trash", DefaultTrashDir(), "trash directory for -delete")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *link && *del {
+		return errUsage
+	}
+	limit, err := parseSize(*minSize)
+	if err != nil {
+		return fmt.Errorf("-min-size: %w", err)
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	sets, err := FindDuplicates(paths, DedupeOptions{
+		MinSize: limit,
+		Workers: *workers,
+		OnError: func(p string, err error) { fmt.Fprintf(os.Stderr, "files dupes: %s: %v\n", p, err) },
+	})
+	if err != nil {
+		return err
+	}
+	var wasted int64
+	copies := 0
+	for _, s := range sets {
+		wasted += s.Wasted()
+		copies += len(s.Paths) - 1
+		fmt.Printf("%s wasted: %d copies of %s, sha256 %.12s\n", humanBytes(s.Wasted()), len(s.Paths), humanBytes(s.Size), s.SHA256)
+		for _, p := range s.Paths {
+			fmt.Println("  " + p)
+		}
+	}
+	fmt.Printf("%d sets of duplicates, %s wasted\n", len(sets), humanBytes(wasted))
+	if len(sets) == 0 || !*link && !*del {
+		return nil
+	}
+
+	verb := "Hard-link"
+	if *del {
+		verb = "Trash"
+	}
+	if !*yes {
+		fmt.Printf("%s %d copies, keeping the first of each set? [y/N] ", verb, copies)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Println("nothing changed")
+			return nil
+		}
+	}
+
+	var t *Trash
+	if *del {
+		if t, err = OpenTrash(*dir, ""); err != nil {
+			return err
+		}
+	}
+	for _, s := range sets {
+		if *link {
+			parts, alone, err := splitByDevice(s)
+			if err != nil {
+				return err
+			}
+			for _, p := range alone {
+				fmt.Printf("skipping %s: no other copy on its file system to link to\n", p)
+			}
+			for _, part := range parts {
+				n, err := LinkDuplicates(part)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("hard-linked %d to %s\n", n, part.Paths[0])
+			}
+			continue
+		}
+		entries, err := TrashDuplicates(s, t)
+		for _, e := range entries {
+			fmt.Printf("trashed %s (restore with: files trash restore %s)\n", e.OriginalPath, e.ID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
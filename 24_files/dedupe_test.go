@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates each named file under dir with its contents
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindDuplicatesGroups(t *testing.T) {
+	dir := t.TempDir()
+	big := strings.Repeat("x", 3*partialHashLen)
+	writeFiles(t, dir, map[string]string{
+		"a1": "alpha", "a2": "alpha", "sub/a3": "alpha",
+		"b1": "bravo", "b2": "bravo",
+		"c1": "charlie",              // No twin
+		"d1": "delta", "d2": "DELTA", // Same size, different contents
+		// Same size and same ends, differing only in the middle, which only
+		// the full hash sees
+		"e1": big + "1" + big, "e2": big + "2" + big,
+		"empty1": "", "empty2": "",
+	})
+	sets, err := FindDuplicates([]string{dir}, DedupeOptions{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range sets {
+		var names []string
+		for _, p := range s.Paths {
+			rel, _ := filepath.Rel(dir, p)
+			names = append(names, filepath.ToSlash(rel))
+		}
+		got = append(got, strings.Join(names, ","))
+	}
+	// Most wasted first: three copies of five bytes, then two
+	want := []string{"a1,a2,sub/a3", "b1,b2"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got sets %q, want %q", got, want)
+	}
+	if len(sets) > 0 && (sets[0].Size != 5 || sets[0].Wasted() != 10 || len(sets[0].SHA256) != 64) {
+		t.Errorf("got first set %+v, want size 5 and 10 bytes wasted", sets[0])
+	}
+
+	sets, err = FindDuplicates([]string{dir}, DedupeOptions{MinSize: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 0 {
+		t.Errorf("MinSize 6: got %v, want nothing", sets)
+	}
+}
+
+func TestLinkAndTrashDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "same", "b": "same", "c": "same"})
+	sets, err := FindDuplicates([]string{dir}, DedupeOptions{})
+	if err != nil || len(sets) != 1 {
+		t.Fatalf("got %v, %v", sets, err)
+	}
+
+	trash := newTestTrash(t, dir)
+	entries, err := TrashDuplicates(DuplicateSet{Paths: sets[0].Paths[:2]}, trash)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("TrashDuplicates: got %v, %v", entries, err)
+	}
+	if _, err := os.Stat(sets[0].Paths[1]); err == nil {
+		t.Error("trashed copy still there")
+	}
+
+	keep, dup := sets[0].Paths[0], sets[0].Paths[2]
+	if n, err := LinkDuplicates(DuplicateSet{Paths: []string{keep, dup}}); err != nil || n != 1 {
+		t.Fatalf("LinkDuplicates: got %d, %v", n, err)
+	}
+	ki, _ := os.Stat(keep)
+	di, _ := os.Stat(dup)
+	if !os.SameFile(ki, di) {
+		t.Error("copy is not a hard link to the kept file")
+	}
+
+	// A copy that changed since the scan is left alone
+	writeFiles(t, dir, map[string]string{"x": "same", "y": "different"})
+	if _, err := LinkDuplicates(DuplicateSet{Paths: []string{filepath.Join(dir, "x"), filepath.Join(dir, "y")}}); err == nil {
+		t.Error("linked a file that no longer matches")
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "y")); string(b) != "different" {
+		t.Errorf("changed copy overwritten with %q", b)
+	}
+}
+
+func TestFindDuplicatesOverlappingRoots(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "same", "sub/b": "same", "sub/c": "other"})
+	tests := []struct {
+		name  string
+		roots []string
+	}{
+		{"same root twice", []string{dir, dir}},
+		{"root and subdirectory", []string{dir, filepath.Join(dir, "sub")}},
+		{"unclean spelling", []string{dir, dir + string(filepath.Separator) + "." + string(filepath.Separator) + "sub"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sets, err := FindDuplicates(tt.roots, DedupeOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sets) != 1 || len(sets[0].Paths) != 2 {
+				t.Fatalf("got %+v, want a and sub/b once each", sets)
+			}
+		})
+	}
+}
+
+func TestDuplicatesRefuseOneFileTwice(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "same"})
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	if err := os.Link(a, b); err != nil {
+		t.Skip("no hard links here:", err)
+	}
+	// As FindDuplicates would report them where it cannot tell hard links
+	// apart
+	s := DuplicateSet{Size: 4, Paths: []string{a, b}}
+
+	if _, err := LinkDuplicates(s); err == nil || !strings.Contains(err.Error(), "same file") {
+		t.Errorf("LinkDuplicates: got %v, want a same-file error", err)
+	}
+	trash, err := OpenTrash(filepath.Join(t.TempDir(), "trash"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TrashDuplicates(s, trash); err == nil || !strings.Contains(err.Error(), "same file") {
+		t.Errorf("TrashDuplicates: got %v, want a same-file error", err)
+	}
+	if _, err := os.Stat(b); err != nil {
+		t.Errorf("second name was removed: %v", err)
+	}
+}
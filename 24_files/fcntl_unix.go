@@ -0,0 +1,28 @@
+//go:build aix || (solaris && !illumos)
+
+package main
+
+import "syscall"
+
+// lockFD takes an fcntl lock on the whole of fd without waiting, these
+// systems having no flock
+// fcntl locks belong to the process rather than the open file: they keep
+// other processes out but not other goroutines of this one, and closing
+// any descriptor of the file drops them.
+func lockFD(fd int, exclusive bool) error {
+	lk := syscall.Flock_t{Type: syscall.F_RDLCK}
+	if exclusive {
+		lk.Type = syscall.F_WRLCK
+	}
+	err := syscall.FcntlFlock(uintptr(fd), syscall.F_SETLK, &lk)
+	if err == syscall.EAGAIN || err == syscall.EACCES {
+		return errLocked
+	}
+	return err
+}
+
+// unlockFD releases fd's fcntl lock
+func unlockFD(fd int) error {
+	lk := syscall.Flock_t{Type: syscall.F_UNLCK}
+	return syscall.FcntlFlock(uintptr(fd), syscall.F_SETLK, &lk)
+}
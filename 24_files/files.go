@@ -2,13 +2,13 @@
 package main
 
 // Import required packages:
-// - bufio: Provides buffered I/O operations
 // - fmt: Implements formatted I/O with functions analogous to C's printf and scanf
 // - os: Provides platform-independent interface to operating system functionality
+// - time: Durations, here the longest we wait for a file lock
 import (
-	"bufio"
 	"fmt"
 	"os"
+	"time"
 )
 
 // main function serves as the entry point for the program
@@ -19,27 +19,27 @@ import (
 // - Copying files
 // - Directory operations
 // - File deletion
+//
+// Given arguments, it runs one of the commands in commands.go instead.
 func main() {
-	// file, err := os.Open("ex.txt")
-	// if err != nil {
-	// 	// Handle the error
-	// 	panic(err)
-	// }
-
-	// fileInfo, err := file.Stat()
-	// if err != nil {
-	// 	// Handle the error
-	// 	panic(err)
-	// }
-	// fmt.Println("File Name:", fileInfo.Name())
-	// fmt.Println("Size in bytes:", fileInfo.Size())
-	// fmt.Println("Last Modified:", fileInfo.ModTime())
-	// fmt.Println("Is Directory:", fileInfo.IsDir())
-	// fmt.Println("Mode:", fileInfo.Mode())
-	// file.Close()
-
-
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 
+	// FILE INFORMATION
+	// StatFile (stat.go) wraps os.Stat and adds what FileInfo keeps in Sys()
+	// (owner, inode, link count) plus the detected content type
+	// Errors are returned, not panicked on (try: go run . stat -sha256 ex.txt)
+	fileStat, err := StatFile("ex.txt", StatOptions{})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("File Name:", fileStat.Name)
+	fmt.Println("Size in bytes:", fileStat.Size)
+	fmt.Println("Last Modified:", fileStat.ModTime)
+	fmt.Println("Is Directory:", fileStat.IsDir)
+	fmt.Println("Mode:", fileStat.Mode)
+	fmt.Println("Content Type:", fileStat.ContentType)
 
 	// APPROACH 1: Basic file reading using a buffer
 	// Open the file for reading
@@ -88,8 +88,26 @@ func main() {
 	fmt.Println("Content read using ReadFile:", string(data))
 
 
-	//Approach: 4
-	// for big files
+	// APPROACH 4: Streaming line by line for big files
+	// ScanLines (largefile.go) wraps bufio.Scanner, so only one line is held
+	// in memory at a time, however large the file is
+	// The second argument caps the line length; 0 means the 1 MiB default
+	// For multi-GB files, ProcessFile splits the file into newline-aligned
+	// chunks and scans them on several goroutines (try: go run . lines ex.txt)
+	bigFile, err := os.Open("ex.txt")
+	if err != nil {
+		panic(err)
+	}
+	defer bigFile.Close()
+	lineNo := 0
+	err = ScanLines(bigFile, 0, func(line []byte) error {
+		lineNo++
+		fmt.Printf("Line %d: %s\n", lineNo, line)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
 
 
 	// DIRECTORY OPERATIONS
@@ -105,6 +123,8 @@ func main() {
 	// Read directory contents
 	// ReadDir(5) limits to reading 5 entries. Use 0 to read all entries
 	// Returns a slice of DirEntry interfaces containing file/directory information
+	// This only looks at the top level; sync.go walks a whole tree with
+	// filepath.WalkDir (try: go run . sync -n . /tmp/backup)
 	info, err := dir.ReadDir(5)
 	// Iterate through directory entries and print names
 	for _, fi := range info {
@@ -114,19 +134,28 @@ func main() {
 
 
 	// FILE CREATION AND WRITING
-	// Create a new file using os.Create
-	// If file exists, it will be truncated. If it doesn't exist, it will be created
-	newFile, err := os.Create("newfile.txt")
+	// os.Create truncates an existing file and writes into it in place, so a
+	// crash halfway leaves a half-written file, and nothing stops two
+	// programs writing it at once
+	// LockFile (lock.go) waits up to the timeout for an advisory lock, held on
+	// newfile.txt.lock, that other writers taking it must wait for
+	lock, err := LockFile("newfile.txt", 5*time.Second)
+	if err != nil {
+		panic(err)
+	}
+	// CreateAtomic (atomic.go) writes to a temporary file instead; Commit
+	// puts it in place of newfile.txt in one step
+	newFile, err := CreateAtomic("newfile.txt", 0o644)
 	if err != nil {
 		panic(err)
 	}
-	// Ensure file is closed after we're done with it
-	defer newFile.Close()
+	// Abort removes the temporary file if we never get to Commit
+	defer newFile.Abort()
 
 	// Write strings directly to the file
 	// WriteString is a convenient way to write string content
 	newFile.WriteString("Hello, this is a new file created by Go!\n")
-	newFile.WriteString("This file is created using os.Create method.\n")
+	newFile.WriteString("This file is created using the CreateAtomic helper.\n")
 
 	// WRITING BYTES TO FILE
 	// Convert string to byte slice and write to file
@@ -134,64 +163,60 @@ func main() {
 	byte := []byte("This is some additional text added to the file.\n")
 	newFile.Write(byte) // Write accepts a byte slice as argument
 
+	// Commit syncs the data to disk, renames it over newfile.txt and syncs
+	// the directory; until then readers still see the old file, if any
+	if err := newFile.Commit(); err != nil {
+		panic(err)
+	}
+	// Done writing: let other writers in. newfile.txt.lock stays behind on
+	// purpose (see lock.go)
+	if err := lock.Unlock(); err != nil {
+		panic(err)
+	}
 
 
 
-	// FILE COPYING USING BUFFERED I/O
-	// This demonstrates how to copy files in a memory-efficient streaming fashion
-	
-	// Open source file for reading
-	sourceFile, err := os.Open("ex.txt")
-	if err != nil {
-		panic(err)
-	}
-	defer sourceFile.Close()
 
-	// Create destination file
-	destFile, err := os.Create("copy_ex.txt")
+	// FILE COPYING
+	// CopyFile (copy.go) streams the file in large chunks instead of one byte
+	// at a time, keeps its permissions and modification time, and writes to
+	// a temporary file that is renamed into place only once it is complete
+	// Verify re-reads both files and compares their SHA-256 checksums
+	err = CopyFile("ex.txt", "copy_ex.txt", CopyOptions{Verify: true})
 	if err != nil {
 		panic(err)
 	}
-	defer destFile.Close()
-
-	// Create buffered reader and writer
-	// bufio provides buffering for better performance when reading/writing
-	reader := bufio.NewReader(sourceFile)
-	writer := bufio.NewWriter(destFile)
-
-	// Copy content byte by byte
-	// This loop demonstrates streaming file copy:
-	// - Reads one byte at a time
-	// - Checks for EOF (End Of File)
-	// - Writes each byte to destination
-	for {
-		b, err := reader.ReadByte()
-		if err != nil {
-			if err.Error() != "EOF" {	// If error is not EOF, it's an actual error
-				panic(err)
-			}
-			break	// Break loop when EOF is reached
-		}
-		// Write the byte to destination file
-		e := writer.WriteByte(b)
-		if e != nil {
-			panic(e)
-		}
-	}
-	// Flush ensures any buffered data is written to the underlying writer
-	writer.Flush()
 	fmt.Println("File copied successfully from ex.txt to copy_ex.txt")
+	// copy_ex.txt now duplicates ex.txt byte for byte; "files dupes" (dedupe.go)
+	// finds such copies and can hard-link or trash them
 
 
 
 	// FILE DELETION
-	// os.Remove deletes a file or empty directory
-	// Be careful with this operation as it's irreversible
-	err = os.Remove("newfile.txt")
+	// os.Remove deletes a file or empty directory, and that cannot be undone
+	// Trash (trash.go) moves the file into a trash directory instead and
+	// remembers where it came from, so it can be restored or purged later
+	// Put refuses paths outside the trash's root unless forced
+	// The demo uses a throwaway trash so runs don't pile up in the real one
+	// (DefaultTrashDir, ~/.files-trash, used by: go run . rm newfile.txt)
+	trashDir, err := os.MkdirTemp("", "files-trash-")
 	if err != nil {
 		panic(err)
 	}
-	fmt.Println("File deleted successfully")
-	// Note: For removing directories that aren't empty,
-	// use os.RemoveAll() instead of os.Remove()
+	defer os.RemoveAll(trashDir)
+	trash, err := OpenTrash(trashDir, ".")
+	if err != nil {
+		panic(err)
+	}
+	entry, err := trash.Put("newfile.txt", false)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("File moved to trash as", entry.ID)
+	// Purge deletes it for good; Restore would have put it back instead
+	if _, err := trash.Purge(0, entry.ID); err != nil {
+		panic(err)
+	}
+	// Note: os.RemoveAll() still exists for removing whole trees for good;
+	// Trash.Put handles directories too
 }
\ No newline at end of file
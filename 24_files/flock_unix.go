@@ -0,0 +1,19 @@
+//go:build unix && !aix && !(solaris && !illumos)
+
+package main
+
+import "syscall"
+
+// lockFD takes a flock lock on fd without waiting
+func lockFD(fd int, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	return syscall.Flock(fd, how|syscall.LOCK_NB)
+}
+
+// unlockFD releases fd's flock lock
+func unlockFD(fd int) error {
+	return syscall.Flock(fd, syscall.LOCK_UN)
+}
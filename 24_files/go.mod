@@ -0,0 +1,5 @@
+module files
+
+// 1.25 for the os.Root methods archive.go extracts with (MkdirAll, Link,
+// Symlink, Chmod, Chtimes)
+go 1.25.0
@@ -0,0 +1,260 @@
+// largefile.go reads files too big for os.ReadFile
+// ScanLines streams a file one line at a time, so memory use is bounded by
+// the longest line rather than the file size. ProcessFile goes further and
+// splits the file into byte ranges that start and end on line boundaries,
+// then scans the ranges on several goroutines at once.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMaxLine is the longest line ScanLines accepts unless told otherwise
+// bufio.Scanner's own default is only 64 KiB.
+const defaultMaxLine = 1 << 20 // 1 MiB
+
+// ScanLines calls fn for every line read from r, without its line ending
+// The line slice is only valid until fn returns. maxLine bounds the longest
+// line (0 means 1 MiB); a longer one stops the scan with bufio.ErrTooLong.
+func ScanLines(r io.Reader, maxLine int, fn func(line []byte) error) error {
+	if maxLine <= 0 {
+		maxLine = defaultMaxLine
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, min(64*1024, maxLine)), maxLine)
+	for sc.Scan() {
+		if err := fn(sc.Bytes()); err != nil {
+			return err
+		}
+	}
+	if errors.Is(sc.Err(), bufio.ErrTooLong) {
+		return fmt.Errorf("line longer than %s: %w", humanBytes(int64(maxLine)), sc.Err())
+	}
+	return sc.Err()
+}
+
+// Chunk is a range of a file that starts at the beginning of a line and
+// ends just after a newline (or at the end of the file)
+type Chunk struct {
+	Index      int   // Position among the file's chunks
+	Start, End int64 // Byte offsets, End exclusive
+}
+
+// SplitFile divides f, of the given size, into at most n chunks of roughly
+// equal size, moving each boundary forward to the next line start
+func SplitFile(f io.ReaderAt, size int64, n int) ([]Chunk, error) {
+	if n < 1 {
+		n = 1
+	}
+	starts := []int64{0}
+	for i := 1; i < n; i++ {
+		start, err := nextLineStart(f, size, int64(i)*size/int64(n))
+		if err != nil {
+			return nil, err
+		}
+		// Long lines can swallow several nominal boundaries
+		if start > starts[len(starts)-1] && start < size {
+			starts = append(starts, start)
+		}
+	}
+	chunks := make([]Chunk, len(starts))
+	for i, s := range starts {
+		end := size
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		chunks[i] = Chunk{Index: i, Start: s, End: end}
+	}
+	return chunks, nil
+}
+
+// nextLineStart returns the offset of the first line that starts at or
+// after off
+func nextLineStart(f io.ReaderAt, size, off int64) (int64, error) {
+	if off <= 0 {
+		return 0, nil
+	}
+	// The line starts at off exactly if the byte before it is a newline
+	buf := make([]byte, 64*1024)
+	for pos := off - 1; pos < size; {
+		n, err := f.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		pos += int64(n)
+	}
+	return size, nil
+}
+
+// LargeFileOptions control ProcessFile
+type LargeFileOptions struct {
+	Workers int // Chunks, and so goroutines, scanning the file; 0 means one per CPU
+	MaxLine int // Longest accepted line, see ScanLines
+}
+
+// ProcessFile calls fn for every line of the file at path, scanning
+// Workers chunks concurrently
+// There is no separate pool of workers: the file is split into at most
+// Workers chunks and each chunk gets a goroutine of its own. fn is called from several goroutines at once, but the lines of one chunk
+// arrive in order on a single goroutine, so per-chunk state indexed by
+// Chunk.Index needs no locking. The first error stops all workers.
+func ProcessFile(path string, opts LargeFileOptions, fn func(c Chunk, line []byte) error) ([]Chunk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	chunks, err := SplitFile(f, info.Size(), workers)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		stop     atomic.Bool // Set on the first error so the others give up
+		errOnce  sync.Once
+		firstErr error
+	)
+	errStopped := errors.New("stopped")
+	for _, c := range chunks {
+		wg.Add(1)
+		go func(c Chunk) {
+			defer wg.Done()
+			// ReadAt does not move a shared offset, so one *os.File can
+			// serve every worker
+			r := io.NewSectionReader(f, c.Start, c.End-c.Start)
+			err := ScanLines(r, opts.MaxLine, func(line []byte) error {
+				if stop.Load() {
+					return errStopped
+				}
+				return fn(c, line)
+			})
+			if err != nil && !errors.Is(err, errStopped) {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("chunk %d (bytes %d-%d): %w", c.Index, c.Start, c.End, err)
+					stop.Store(true)
+				})
+			}
+		}(c)
+	}
+	wg.Wait()
+	return chunks, firstErr
+}
+
+// lineStats is what the lines command counts per chunk
+type lineStats struct {
+	lines, matches, bytes int64
+	longest               int
+}
+
+func init() {
+	commands["lines"] = command{
+		usage: "[-workers n] [-max-line bytes] [-match text] file",
+		help:  "count lines (and matches) in a large file in parallel",
+		run:   runLines,
+	}
+}
+
+// runLines implements "files lines"
+func runLines(args []string) error {
+	flags := newFlagSet("lines")
+	workers := flags.Int("workers", runtime.NumCPU(), "chunks scanned at once; 1 streams the file sequentially")
+	maxLine := flags.String("max-line", "1M", "longest accepted line, e.g. 64K, 16M")
+	match := flags.String("match", "", "also count lines containing this text")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	// stats is indexed by Chunk.Index, and SplitFile makes at most one
+	// chunk per worker; 0 would let ProcessFile pick NumCPU instead
+	if *workers < 1 {
+		return fmt.Errorf("-workers must be at least 1, got %d", *workers)
+	}
+	limit, err := parseSize(*maxLine)
+	if err != nil {
+		return fmt.Errorf("-max-line: %w", err)
+	}
+
+	needle := []byte(*match)
+	stats := make([]lineStats, *workers)
+	began := time.Now()
+	chunks, err := ProcessFile(flags.Arg(0), LargeFileOptions{Workers: *workers, MaxLine: int(limit)}, func(c Chunk, line []byte) error {
+		s := &stats[c.Index]
+		s.lines++
+		s.bytes += int64(len(line))
+		s.longest = max(s.longest, len(line))
+		if len(needle) > 0 && bytes.Contains(line, needle) {
+			s.matches++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var total lineStats
+	for _, s := range stats {
+		total.lines += s.lines
+		total.matches += s.matches
+		total.bytes += s.bytes
+		total.longest = max(total.longest, s.longest)
+	}
+	fmt.Printf("lines:    %d\n", total.lines)
+	if len(needle) > 0 {
+		fmt.Printf("matches:  %d\n", total.matches)
+	}
+	fmt.Printf("longest:  %d bytes\n", total.longest)
+	fmt.Printf("chunks:   %d\n", len(chunks))
+	fmt.Printf("elapsed:  %s\n", time.Since(began).Round(time.Millisecond))
+	return nil
+}
+
+// parseSize parses a byte count with an optional K, M or G suffix (powers
+// of 1024)
+func parseSize(s string) (int64, error) {
+	orig, mult := s, int64(1)
+	switch {
+	case strings.HasSuffix(strings.ToUpper(s), "K"):
+		mult, s = 1<<10, s[:len(s)-1]
+	case strings.HasSuffix(strings.ToUpper(s), "M"):
+		mult, s = 1<<20, s[:len(s)-1]
+	case strings.HasSuffix(strings.ToUpper(s), "G"):
+		mult, s = 1<<30, s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", orig)
+	}
+	if n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("size %q is too large", orig)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitFile(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		n    int
+		want string // Chunk start-end pairs
+	}{
+		{"even lines", "aaa\nbbb\nccc\nddd\n", 4, "0-4 4-8 8-12 12-16"},
+		{"boundary moved to the next line", "aa\nbbbbbb\ncc\n", 2, "0-10 10-13"},
+		{"long line swallows boundaries", strings.Repeat("x", 20) + "\ny\n", 4, "0-21 21-23"},
+		{"no newline at all", "abc", 3, "0-3"},
+		{"no final newline", "ab\ncd", 2, "0-3 3-5"},
+		{"empty", "", 4, "0-0"},
+		{"n below one", "a\nb\n", 0, "0-4"},
+		{"more chunks than lines", "a\nb\n", 10, "0-2 2-4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := SplitFile(strings.NewReader(tt.text), int64(len(tt.text)), tt.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for i, c := range chunks {
+				got = append(got, fmt.Sprintf("%d-%d", c.Start, c.End))
+				if c.Index != i {
+					t.Errorf("chunk %d has Index %d", i, c.Index)
+				}
+				if c.Start > 0 && tt.text[c.Start-1] != '\n' {
+					t.Errorf("chunk %d starts mid-line at %d", i, c.Start)
+				}
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("got %s, want %s", strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessFileSeesEveryLine(t *testing.T) {
+	var b strings.Builder
+	for i := range 1000 {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"big": b.String()})
+	counts := make([]int, 7)
+	chunks, err := ProcessFile(filepath.Join(dir, "big"), LargeFileOptions{Workers: len(counts)}, func(c Chunk, line []byte) error {
+		counts[c.Index]++ // One goroutine per chunk, so no locking
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	if total != 1000 || len(chunks) > len(counts) {
+		t.Errorf("got %d lines in %d chunks, want 1000 in at most %d", total, len(chunks), len(counts))
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"512", 512, false},
+		{"4K", 4 << 10, false},
+		{"4k", 4 << 10, false},
+		{"3M", 3 << 20, false},
+		{"2G", 2 << 30, false},
+		{"8589934591G", 8589934591 << 30, false},
+		{"8589934592G", 0, true}, // 2^63 bytes
+		{"9223372036854775807K", 0, true},
+		{"9223372036854775808", 0, true},
+		{"-1", 0, true},
+		{"", 0, true},
+		{"K", 0, true},
+		{"1T", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseSize(tt.in)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("got %d, %v; want %d, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
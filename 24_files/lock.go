@@ -0,0 +1,79 @@
+// lock.go keeps concurrent writers from working on the same file at once
+// The locks are advisory (flock on Unix, LockFileEx on Windows): they bind
+// only programs that take them too, whether in other processes or other
+// goroutines of this one. AIX and Solaris have no flock and get fcntl
+// locks, which do not bind goroutines of one process. They are held on a separate name+".lock" file
+// rather than the file itself, because an atomic replace swaps the file for
+// a new one and a lock on the old one would protect nothing. The .lock file
+// is left behind on purpose: deleting it could let a waiter and a newcomer
+// lock two different files and both go ahead.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrLockTimeout is returned when someone else still holds a lock when the
+// timeout runs out
+var ErrLockTimeout = errors.New("timed out waiting for lock")
+
+// FileLock is a held lock
+type FileLock struct {
+	f *os.File
+}
+
+// LockFile takes the exclusive lock for name, waiting up to timeout for
+// other holders to let go
+// A timeout of 0 tries once; a negative one waits as long as it takes.
+func LockFile(name string, timeout time.Duration) (*FileLock, error) {
+	return lockFile(name, true, timeout)
+}
+
+// RLockFile takes a shared lock for name: any number of readers may hold
+// one together, but not while LockFile's exclusive lock is held
+func RLockFile(name string, timeout time.Duration) (*FileLock, error) {
+	return lockFile(name, false, timeout)
+}
+
+// lockFile polls tryLock (lock_unix.go, lock_windows.go), backing off up to
+// 100ms between tries
+func lockFile(name string, exclusive bool, timeout time.Duration) (*FileLock, error) {
+	f, err := os.OpenFile(name+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	wait := 5 * time.Millisecond
+	for {
+		err := tryLock(f, exclusive)
+		if err == nil {
+			return &FileLock{f: f}, nil
+		}
+		if !errors.Is(err, errLocked) {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", name, err)
+		}
+		left := time.Until(deadline)
+		if timeout >= 0 && left <= 0 {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", name, ErrLockTimeout)
+		}
+		if timeout >= 0 {
+			wait = min(wait, left)
+		}
+		time.Sleep(wait)
+		wait = min(wait*2, 100*time.Millisecond)
+	}
+}
+
+// Unlock releases the lock
+func (l *FileLock) Unlock() error {
+	err := unlock(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,19 @@
+//go:build !unix && !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errLocked is never returned here
+var errLocked = errors.New("locked")
+
+// tryLock fails: this system has no locking call in the standard library
+func tryLock(f *os.File, exclusive bool) error {
+	return errors.ErrUnsupported
+}
+
+// unlock has nothing to release
+func unlock(f *os.File) error { return nil }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// skipWithoutFlock skips tests that need locks to exclude other holders in
+// the same process, which the fcntl locks of AIX and Solaris do not
+func skipWithoutFlock(t *testing.T) {
+	if runtime.GOOS == "aix" || runtime.GOOS == "solaris" {
+		t.Skip("fcntl locks do not exclude holders in one process")
+	}
+}
+
+func TestLockFileTimeout(t *testing.T) {
+	skipWithoutFlock(t)
+	name := filepath.Join(t.TempDir(), "data")
+	held, err := LockFile(name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		lock    func(string, time.Duration) (*FileLock, error)
+	}{
+		{"exclusive, no wait", 0, LockFile},
+		{"exclusive", 100 * time.Millisecond, LockFile},
+		{"shared", 100 * time.Millisecond, RLockFile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			l, err := tt.lock(name, tt.timeout)
+			if !errors.Is(err, ErrLockTimeout) {
+				if l != nil {
+					l.Unlock()
+				}
+				t.Fatalf("got %v, want ErrLockTimeout", err)
+			}
+			if waited := time.Since(start); waited < tt.timeout {
+				t.Errorf("gave up after %v, before the %v timeout", waited, tt.timeout)
+			}
+		})
+	}
+
+	// A waiter without a timeout gets the lock once the holder lets go
+	time.AfterFunc(50*time.Millisecond, func() { held.Unlock() })
+	l, err := LockFile(name, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Unlock()
+}
+
+func TestRLockFileShares(t *testing.T) {
+	skipWithoutFlock(t)
+	name := filepath.Join(t.TempDir(), "data")
+	r1, err := RLockFile(name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Unlock()
+	r2, err := RLockFile(name, 0)
+	if err != nil {
+		t.Fatalf("second reader: %v", err)
+	}
+	defer r2.Unlock()
+	if _, err := LockFile(name, 0); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("writer while readers hold the lock: got %v, want ErrLockTimeout", err)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// errLocked is what tryLock returns when another holder has the lock
+var errLocked error = syscall.EWOULDBLOCK
+
+// tryLock locks f without waiting, with flock or fcntl (flock_unix.go,
+// fcntl_unix.go)
+func tryLock(f *os.File, exclusive bool) error {
+	for {
+		err := lockFD(int(f.Fd()), exclusive)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// unlock releases f's lock
+func unlock(f *os.File) error {
+	return unlockFD(int(f.Fd()))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// LockFileEx is not wrapped by package syscall, so it is called directly
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// Flags for LockFileEx
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+)
+
+// errLocked is ERROR_LOCK_VIOLATION, what LockFileEx returns when another
+// holder has the lock
+var errLocked error = syscall.Errno(33)
+
+// tryLock locks the first byte of f without waiting; every holder locks the
+// same byte, which is all a lock file needs
+func tryLock(f *os.File, exclusive bool) error {
+	flags := uintptr(lockfileFailImmediately)
+	if exclusive {
+		flags |= lockfileExclusiveLock
+	}
+	ol := new(syscall.Overlapped)
+	if r, _, err := procLockFileEx.Call(f.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(ol))); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// unlock releases the byte tryLock locked
+func unlock(f *os.File) error {
+	ol := new(syscall.Overlapped)
+	if r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(ol))); r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,443 @@
+// search.go finds text in a directory tree, like grep -rn
+// The walker skips whatever .gitignore and .ignore files in the tree rule
+// out and hands the remaining files to a fixed pool of workers. Each worker
+// reads its file through a bufio.Reader and ScanLines (largefile.go), so a
+// file is never loaded whole, and results come back in walk order however
+// the workers finish.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// SearchOptions control Search
+type SearchOptions struct {
+	Regexp        bool // The pattern is a regular expression, not literal text
+	IgnoreCase    bool
+	Before, After int  // Context lines to include around each match
+	Workers       int  // Files scanned at once; 0 means one per CPU
+	NoIgnore      bool // Also search files that ignore files exclude
+	MaxLine       int  // Longest accepted line, see ScanLines
+}
+
+// SearchLine is a matching line or a context line around one
+type SearchLine struct {
+	Num    int // 1-based line number
+	Column int // 1-based byte column of the first match; 0 for context lines
+	Text   string
+}
+
+// FileMatches holds what Search found in one file
+type FileMatches struct {
+	Path  string
+	Lines []SearchLine // In file order; a jump in Num separates groups
+	Err   error        // The file could not be read; Lines may be partial
+}
+
+// binarySniffLen is how much of a file is checked for NUL bytes, the same
+// test git uses to decide a file is binary
+const binarySniffLen = 8000
+
+// Search looks for pattern in every file below paths and calls fn for each
+// file that matched or failed, in walk order
+// fn runs on the calling goroutine; an error from it stops the search and
+// is returned.
+func Search(paths []string, pattern string, opts SearchOptions, fn func(FileMatches) error) error {
+	match, err := newMatcher(pattern, opts)
+	if err != nil {
+		return err
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	type job struct {
+		seq  int
+		path string
+		err  error // A walk error to pass through in order
+	}
+	type result struct {
+		seq int
+		fm  FileMatches
+	}
+	jobs := make(chan job)
+	results := make(chan result, workers)
+	done := make(chan struct{}) // Closed when fn fails, to stop the walker
+	errStopped := errors.New("stopped")
+
+	var walkErr error
+	go func() {
+		defer close(jobs)
+		seq := 0
+		walkErr = walkSearchFiles(paths, opts.NoIgnore, func(p string, err error) error {
+			select {
+			case jobs <- job{seq, p, err}:
+				seq++
+				return nil
+			case <-done:
+				return errStopped
+			}
+		})
+	}()
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				fm := FileMatches{Path: j.path, Err: j.err}
+				if j.err == nil {
+					fm = searchFile(j.path, match, opts)
+				}
+				results <- result{j.seq, fm}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Hold early finishers back until every file before them is reported
+	pending := make(map[int]FileMatches)
+	next := 0
+	var fnErr error
+	for r := range results {
+		pending[r.seq] = r.fm
+		for {
+			fm, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if fnErr == nil && (len(fm.Lines) > 0 || fm.Err != nil) {
+				if fnErr = fn(fm); fnErr != nil {
+					close(done)
+				}
+			}
+		}
+	}
+	if fnErr != nil {
+		return fnErr
+	}
+	return walkErr
+}
+
+// newMatcher returns a function giving the byte offset of the first match
+// in a line, or -1
+func newMatcher(pattern string, opts SearchOptions) (func(line []byte) int, error) {
+	if !opts.Regexp && !opts.IgnoreCase {
+		needle := []byte(pattern)
+		return func(line []byte) int { return bytes.Index(line, needle) }, nil
+	}
+	if !opts.Regexp {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if opts.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return func(line []byte) int {
+		if loc := re.FindIndex(line); loc != nil {
+			return loc[0]
+		}
+		return -1
+	}, nil
+}
+
+// searchFile scans one file; binary files yield no lines
+func searchFile(name string, match func([]byte) int, opts SearchOptions) FileMatches {
+	fm := FileMatches{Path: name}
+	f, err := os.Open(name)
+	if err != nil {
+		fm.Err = err
+		return fm
+	}
+	defer f.Close()
+
+	br := bufio.NewReaderSize(f, 64*1024)
+	head, _ := br.Peek(binarySniffLen) // A short file is not an error
+	if bytes.IndexByte(head, 0) >= 0 {
+		return fm
+	}
+
+	var before []SearchLine // The last opts.Before non-matching lines
+	afterLeft, num := 0, 0
+	fm.Err = ScanLines(br, opts.MaxLine, func(line []byte) error {
+		num++
+		if col := match(line); col >= 0 {
+			fm.Lines = append(fm.Lines, before...)
+			fm.Lines = append(fm.Lines, SearchLine{Num: num, Column: col + 1, Text: string(line)})
+			before = before[:0]
+			afterLeft = opts.After
+			return nil
+		}
+		switch {
+		case afterLeft > 0:
+			afterLeft--
+			fm.Lines = append(fm.Lines, SearchLine{Num: num, Text: string(line)})
+		case opts.Before > 0:
+			if len(before) == opts.Before {
+				before = append(before[:0], before[1:]...)
+			}
+			before = append(before, SearchLine{Num: num, Text: string(line)})
+		}
+		return nil
+	})
+	return fm
+}
+
+// walkSearchFiles calls visit for every regular file below paths that the
+// ignore files do not exclude, or with an error for what cannot be read
+// Paths naming files are visited as given, ignored or not.
+func walkSearchFiles(paths []string, noIgnore bool, visit func(path string, err error) error) error {
+	for _, root := range paths {
+		rules := ignoreList{}
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return visit(p, err)
+			}
+			if p == root && !d.IsDir() {
+				return visit(p, nil)
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if d.IsDir() {
+				if p != root && (d.Name() == ".git" || !noIgnore && rules.ignored(rel, true)) {
+					return filepath.SkipDir
+				}
+				if !noIgnore {
+					rules.load(p, rel)
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || !noIgnore && rules.ignored(rel, false) {
+				return nil
+			}
+			return visit(p, nil)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ignoreFiles are read from every directory searched
+var ignoreFiles = []string{".gitignore", ".ignore"}
+
+// ignoreRule is one pattern line from an ignore file
+type ignoreRule struct {
+	re       *regexp.Regexp
+	negate   bool // "!pattern" re-includes what an earlier rule excluded
+	dirOnly  bool // "pattern/" matches only directories
+	anchored bool // The pattern has a slash, so it matches the path from the ignore file's directory, not just the name
+}
+
+// ignoreList holds the rules found so far, by the slash path (relative to
+// the walk root) of the directory they apply to
+type ignoreList map[string][]ignoreRule
+
+// load reads the ignore files in dir, whose relative path is rel
+func (l ignoreList) load(dir, rel string) {
+	for _, name := range ignoreFiles {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		for line := range strings.Lines(string(data)) {
+			if r, ok := parseIgnoreLine(line); ok {
+				l[rel] = append(l[rel], r)
+			}
+		}
+	}
+}
+
+// ignored reports whether the entry at the relative slash path rel is
+// excluded: the last rule matching it, deepest directory last, decides
+func (l ignoreList) ignored(rel string, isDir bool) bool {
+	ignored := false
+	dir := "."
+	for {
+		sub := rel
+		if dir != "." {
+			sub = strings.TrimPrefix(rel, dir+"/")
+		}
+		for _, r := range l[dir] {
+			if r.dirOnly && !isDir {
+				continue
+			}
+			target := sub
+			if !r.anchored {
+				target = path.Base(sub)
+			}
+			if r.re.MatchString(target) {
+				ignored = !r.negate
+			}
+		}
+		// Step down to the next directory on the way to rel
+		i := strings.IndexByte(sub, '/')
+		if i < 0 {
+			return ignored
+		}
+		if dir == "." {
+			dir = sub[:i]
+		} else {
+			dir += "/" + sub[:i]
+		}
+	}
+}
+
+// parseIgnoreLine parses one line of a .gitignore-style file; ok is false
+// for blank lines, comments and patterns that do not compile
+func parseIgnoreLine(line string) (r ignoreRule, ok bool) {
+	line = strings.TrimRight(line, " \t\r\n")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return r, false
+	}
+	if rest, found := strings.CutPrefix(line, "!"); found {
+		r.negate, line = true, rest
+	}
+	if rest, found := strings.CutSuffix(line, "/"); found {
+		r.dirOnly, line = true, rest
+	}
+	if strings.Contains(line, "/") {
+		r.anchored, line = true, strings.TrimPrefix(line, "/")
+	}
+	re, err := regexp.Compile(globToRegexp(line))
+	if err != nil || line == "" {
+		return r, false
+	}
+	r.re = re
+	return r, true
+}
+
+// globToRegexp translates a gitignore glob: * and ? stay within one path
+// element, ** spans any number of them
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if rest, found := strings.CutPrefix(class, "!"); found {
+				class = "^" + rest
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// errNoMatches makes the search command exit with status 1, as grep does
+var errNoMatches = errors.New("no matches")
+
+func init() {
+	commands["search"] = command{
+		usage: "[-E] [-i] [-C n] [-A n] [-B n] [-workers n] [-no-ignore] pattern [path...]",
+		help:  "search files for text or a regular expression",
+		run:   runSearch,
+	}
+}
+
+// runSearch implements "files search"
+func runSearch(args []string) error {
+	var opts SearchOptions
+	flags := newFlagSet("search")
+	flags.BoolVar(&opts.Regexp, "E", false, "the pattern is a regular expression")
+	flags.BoolVar(&opts.IgnoreCase, "i", false, "ignore case")
+	context := flags.Int("C", 0, "lines of context before and after each match")
+	flags.IntVar(&opts.After, "A", 0, "lines of context after each match")
+	flags.IntVar(&opts.Before, "B", 0, "lines of context before each match")
+	flags.IntVar(&opts.Workers, "workers", runtime.NumCPU(), "files scanned at once")
+	flags.BoolVar(&opts.NoIgnore, "no-ignore", false, "do not skip files listed in .gitignore or .ignore")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+	if *context > 0 {
+		opts.Before, opts.After = max(opts.Before, *context), max(opts.After, *context)
+	}
+	paths := flags.Args()[1:]
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	withContext := opts.Before > 0 || opts.After > 0
+	found, printed := false, false
+	err := Search(paths, flags.Arg(0), opts, func(fm FileMatches) error {
+		if fm.Err != nil {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "files search: %s: %v\n", fm.Path, fm.Err)
+		}
+		prev := 0
+		for _, l := range fm.Lines {
+			// Separate groups of context the way grep does
+			if withContext && printed && (prev == 0 || l.Num > prev+1) {
+				fmt.Fprintln(out, "--")
+			}
+			if l.Column > 0 {
+				found = true
+				fmt.Fprintf(out, "%s:%d:%d:%s\n", fm.Path, l.Num, l.Column, l.Text)
+			} else {
+				fmt.Fprintf(out, "%s-%d-%s\n", fm.Path, l.Num, l.Text)
+			}
+			prev, printed = l.Num, true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errNoMatches
+	}
+	return nil
+}
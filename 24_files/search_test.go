@@ -0,0 +1,101 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testIgnoreList builds an ignoreList from ignore file contents by the
+// directory they are in
+func testIgnoreList(t *testing.T, files map[string]string) ignoreList {
+	t.Helper()
+	l := ignoreList{}
+	for dir, text := range files {
+		for line := range strings.Lines(text) {
+			if r, ok := parseIgnoreLine(line); ok {
+				l[dir] = append(l[dir], r)
+			}
+		}
+	}
+	return l
+}
+
+func TestIgnoreRules(t *testing.T) {
+	l := testIgnoreList(t, map[string]string{
+		".":   "# comment\n\n*.log\n!keep.log\nbuild/\n/top.txt\ndocs/**/*.tmp\nfile?.bak\n[ab].o\n\\#hash\n",
+		"sub": "local\n!*.log\n",
+	})
+	tests := []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"app.log", false, true},
+		{"deep/er/app.log", false, true},
+		{"keep.log", false, false},
+		{"deep/keep.log", false, false},
+		{"build", true, true},
+		{"build", false, false}, // A trailing slash matches directories only
+		{"src/build", true, true},
+		{"top.txt", false, true},
+		{"src/top.txt", false, false}, // Anchored to the ignore file's directory
+		{"docs/a.tmp", false, true},
+		{"docs/x/y/a.tmp", false, true},
+		{"other/docs/a.tmp", false, false},
+		{"file1.bak", false, true},
+		{"file10.bak", false, false},
+		{"a.o", false, true},
+		{"c.o", false, false},
+		{"#hash", false, true},
+		{"sub/local", false, true},
+		{"sub/deep/local", false, true},
+		{"local", false, false},       // sub's rules stay in sub
+		{"sub/app.log", false, false}, // The deeper ignore file has the last word
+		{"readme.md", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rel, func(t *testing.T) {
+			if got := l.ignored(tt.rel, tt.isDir); got != tt.want {
+				t.Errorf("ignored(%q, dir %v) = %v, want %v", tt.rel, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWalkSearchFilesHonorsIgnoreFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":    "*.log\nvendor/\n",
+		"main.go":       "",
+		"app.log":       "",
+		"vendor/lib.go": "",
+		"sub/.ignore":   "gen.go\n",
+		"sub/gen.go":    "",
+		"sub/util.go":   "",
+		".git/config":   "",
+	})
+	walk := func(noIgnore bool) string {
+		var got []string
+		err := walkSearchFiles([]string{dir}, noIgnore, func(p string, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(dir, p)
+			got = append(got, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		return strings.Join(got, " ")
+	}
+	if got, want := walk(false), ".gitignore main.go sub/.ignore sub/util.go"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got, want := walk(true), ".gitignore app.log main.go sub/.ignore sub/gen.go sub/util.go vendor/lib.go"; got != want {
+		t.Errorf("with noIgnore: got %s, want %s", got, want)
+	}
+}
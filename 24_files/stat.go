@@ -0,0 +1,205 @@
+// stat.go reports everything worth knowing about a file
+// It grew out of the fileInfo example at the top of files.go, adding the
+// fields os.FileInfo only exposes through Sys() (owner, inode, link count),
+// a guess at the content type and optional digests.
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileStat is the report for one path
+type FileStat struct {
+	Path        string      `json:"path"`
+	Name        string      `json:"name"`
+	Size        int64       `json:"size"`
+	Mode        fs.FileMode `json:"-"`
+	ModeString  string      `json:"mode"` // e.g. "-rw-r--r--"
+	ModTime     time.Time   `json:"mtime"`
+	IsDir       bool        `json:"is_dir"`
+	LinkTarget  string      `json:"link_target,omitempty"` // For symbolic links
+	Owner       string      `json:"owner,omitempty"`       // Empty where the OS has no owners
+	Group       string      `json:"group,omitempty"`
+	Inode       uint64      `json:"inode,omitempty"`
+	Links       uint64      `json:"links,omitempty"` // Hard link count
+	ContentType string      `json:"content_type,omitempty"`
+	SHA256      string      `json:"sha256,omitempty"`
+	MD5         string      `json:"md5,omitempty"`
+}
+
+// StatOptions choose the optional parts of a FileStat
+type StatOptions struct {
+	SHA256, MD5 bool
+	// NoFollow reports on a symbolic link itself instead of its target
+	NoFollow bool
+}
+
+// StatFile inspects path
+func StatFile(path string, opts StatOptions) (*FileStat, error) {
+	stat := os.Stat
+	if opts.NoFollow {
+		stat = os.Lstat
+	}
+	info, err := stat(path)
+	if err != nil {
+		return nil, err
+	}
+	st := &FileStat{
+		Path:       path,
+		Name:       info.Name(),
+		Size:       info.Size(),
+		Mode:       info.Mode(),
+		ModeString: info.Mode().String(),
+		ModTime:    info.ModTime(),
+		IsDir:      info.IsDir(),
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		st.LinkTarget, _ = os.Readlink(path)
+	}
+	fillSysStat(st, info) // stat_unix.go / stat_other.go
+
+	if info.Mode().IsRegular() {
+		if err := inspectContent(path, st, opts); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// inspectContent sniffs the content type and computes the requested digests
+// in a single pass over the file
+func inspectContent(path string, st *FileStat, opts StatOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// http.DetectContentType looks at no more than the first 512 bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	st.ContentType = http.DetectContentType(head[:n])
+	// Sniffing cannot tell CSS from JSON from plain text; the extension can
+	if st.ContentType == "text/plain; charset=utf-8" || st.ContentType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(filepath.Ext(path)); byExt != "" {
+			st.ContentType = byExt
+		}
+	}
+
+	var sha, md hash.Hash
+	var writers []io.Writer
+	if opts.SHA256 {
+		sha = sha256.New()
+		writers = append(writers, sha)
+	}
+	if opts.MD5 {
+		md = md5.New()
+		writers = append(writers, md)
+	}
+	if len(writers) == 0 {
+		return nil
+	}
+	w := io.MultiWriter(writers...)
+	w.Write(head[:n])
+	if _, err := io.Copy(w, f); err != nil {
+		return err
+	}
+	if sha != nil {
+		st.SHA256 = hex.EncodeToString(sha.Sum(nil))
+	}
+	if md != nil {
+		st.MD5 = hex.EncodeToString(md.Sum(nil))
+	}
+	return nil
+}
+
+// printStat writes st in the human-readable layout
+func printStat(w io.Writer, st *FileStat) {
+	fmt.Fprintf(w, "  File: %s\n", st.Path)
+	if st.LinkTarget != "" {
+		fmt.Fprintf(w, "    -> %s\n", st.LinkTarget)
+	}
+	fmt.Fprintf(w, "  Size: %d (%s)\n", st.Size, humanBytes(st.Size))
+	fmt.Fprintf(w, "  Type: %s\n", typeName(st.Mode))
+	fmt.Fprintf(w, "  Mode: %s (%04o)\n", st.ModeString, st.Mode.Perm())
+	fmt.Fprintf(w, "Modify: %s\n", st.ModTime.Format(time.RFC3339))
+	if st.Owner != "" {
+		fmt.Fprintf(w, " Owner: %s:%s\n", st.Owner, st.Group)
+	}
+	if st.Inode != 0 {
+		fmt.Fprintf(w, " Inode: %d  Links: %d\n", st.Inode, st.Links)
+	}
+	if st.ContentType != "" {
+		fmt.Fprintf(w, "  MIME: %s\n", st.ContentType)
+	}
+	if st.SHA256 != "" {
+		fmt.Fprintf(w, "SHA256: %s\n", st.SHA256)
+	}
+	if st.MD5 != "" {
+		fmt.Fprintf(w, "   MD5: %s\n", st.MD5)
+	}
+}
+
+func init() {
+	commands["stat"] = command{
+		usage: "[-sha256] [-md5] [-no-deref] [-json] path...",
+		help:  "show size, mode, owner, inode, content type and digests",
+		run:   runStat,
+	}
+}
+
+// runStat implements "files stat"
+func runStat(args []string) error {
+	var opts StatOptions
+	flags := newFlagSet("stat")
+	flags.BoolVar(&opts.SHA256, "sha256", false, "compute the SHA-256 digest")
+	flags.BoolVar(&opts.MD5, "md5", false, "compute the MD5 digest")
+	flags.BoolVar(&opts.NoFollow, "no-deref", false, "describe symbolic links themselves rather than their targets")
+	asJSON := flags.Bool("json", false, "print one JSON object per path")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	failed := 0
+	for i, path := range flags.Args() {
+		st, err := StatFile(path, opts)
+		if err != nil {
+			// Keep going, like stat(1), and fail at the end
+			fmt.Fprintln(os.Stderr, "files stat:", err)
+			failed++
+			continue
+		}
+		if *asJSON {
+			enc.Encode(st)
+			continue
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		printStat(os.Stdout, st)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d paths could not be read", failed, flags.NArg())
+	}
+	return nil
+}
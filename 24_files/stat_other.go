@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// fileID returns 0: there is no inode to tell files apart by
+func fileID(info fs.FileInfo) uint64 { return 0 }
+
+// fileDev returns 0: there is no device number either
+func fileDev(info fs.FileInfo) uint64 { return 0 }
+
+// fillSysStat does nothing where files have no Unix owner or inode
+func fillSysStat(st *FileStat, info fs.FileInfo) {}
@@ -0,0 +1,48 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// fileID returns the inode number of info, or 0
+func fileID(info fs.FileInfo) uint64 {
+	if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(sys.Ino)
+	}
+	return 0
+}
+
+// fileDev returns the device info lives on, or 0
+func fileDev(info fs.FileInfo) uint64 {
+	if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(sys.Dev)
+	}
+	return 0
+}
+
+// fillSysStat adds the owner, group, inode and link count from the
+// platform's stat structure
+func fillSysStat(st *FileStat, info fs.FileInfo) {
+	sys, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	st.Inode = uint64(sys.Ino)
+	st.Links = uint64(sys.Nlink)
+
+	// Fall back to the numeric IDs when there is no name for them
+	uid := strconv.FormatUint(uint64(sys.Uid), 10)
+	gid := strconv.FormatUint(uint64(sys.Gid), 10)
+	st.Owner, st.Group = uid, gid
+	if u, err := user.LookupId(uid); err == nil {
+		st.Owner = u.Username
+	}
+	if g, err := user.LookupGroupId(gid); err == nil {
+		st.Group = g.Name
+	}
+}
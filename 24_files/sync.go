@@ -0,0 +1,462 @@
+// sync.go mirrors one directory tree into another
+// Where files.go lists a handful of entries with dir.ReadDir(5), this walks
+// the whole tree with filepath.WalkDir, works out what has to change in the
+// destination, and then either prints that plan (dry run) or carries it out.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LinkMode says how Sync treats symbolic links in the source
+type LinkMode string
+
+// Symbolic link handling
+const (
+	LinksKeep   LinkMode = "keep"   // Recreate the link itself in the destination
+	LinksFollow LinkMode = "follow" // Copy the file the link points to
+	LinksSkip   LinkMode = "skip"   // Leave links out
+)
+
+// SyncOptions control Sync
+type SyncOptions struct {
+	// Checksum compares file contents by SHA-256 instead of size and mtime
+	Checksum bool
+	// Delete removes destination entries that are not in the source
+	// Excluded entries are never deleted.
+	Delete bool
+	// Include, if not empty, limits the files synced to those matching one
+	// of these patterns; Exclude patterns win over Include. A pattern
+	// without a '/' is matched against the base name at any depth, one with
+	// a '/' against the whole slash-separated path relative to the root.
+	Include []string
+	Exclude []string
+	Links   LinkMode // Defaults to LinksKeep
+	DryRun  bool     // Only plan, change nothing
+}
+
+// SyncOp is the kind of a SyncAction
+type SyncOp string
+
+// Sync actions
+const (
+	OpMkdir   SyncOp = "mkdir"
+	OpCopy    SyncOp = "copy"    // New file
+	OpUpdate  SyncOp = "update"  // Changed file
+	OpSymlink SyncOp = "symlink" // New or changed link
+	OpDelete  SyncOp = "delete"
+	OpSkip    SyncOp = "skip" // Left alone in both trees; only reported
+)
+
+// SyncAction is one planned change to the destination
+type SyncAction struct {
+	Op     SyncOp
+	Path   string // Relative to the roots, slash-separated
+	Reason string // Why, for the dry-run listing
+}
+
+func (a SyncAction) String() string {
+	if a.Reason == "" {
+		return fmt.Sprintf("%-7s %s", a.Op, a.Path)
+	}
+	return fmt.Sprintf("%-7s %s (%s)", a.Op, a.Path, a.Reason)
+}
+
+// Sync makes dst match src and returns what it did (or, with DryRun, what
+// it would do)
+// dst may not lie inside src, where each run would copy the last one's
+// copy again, and with Delete src may not lie inside dst.
+func Sync(src, dst string, opts SyncOptions) ([]SyncAction, error) {
+	if opts.Links == "" {
+		opts.Links = LinksKeep
+	}
+	if err := checkOverlap(src, dst, opts.Delete); err != nil {
+		return nil, err
+	}
+	for _, p := range append(append([]string(nil), opts.Include...), opts.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %w", p, err)
+		}
+	}
+	plan, err := planSync(src, dst, opts)
+	if err != nil || opts.DryRun {
+		return plan, err
+	}
+	for i, a := range plan {
+		if err := applyAction(src, dst, a); err != nil {
+			return plan[:i], fmt.Errorf("%s %s: %w", a.Op, a.Path, err)
+		}
+	}
+	return plan, nil
+}
+
+// checkOverlap refuses a dst inside (or equal to) src, and with del a src
+// inside dst, which the deletions would remove
+func checkOverlap(src, dst string, del bool) error {
+	s, err := realPath(src)
+	if err != nil {
+		return err
+	}
+	d, err := realPath(dst)
+	if err != nil {
+		return err
+	}
+	switch {
+	case s == d:
+		return fmt.Errorf("%s and %s are the same directory", src, dst)
+	case within(s, d):
+		return fmt.Errorf("%s is inside %s: each sync would copy the last copy again", dst, src)
+	case del && within(d, s):
+		return fmt.Errorf("%s is inside %s: -delete would remove it", src, dst)
+	}
+	return nil
+}
+
+// realPath makes p absolute and resolves symbolic links in as much of it as
+// exists, so two spellings of one directory compare equal
+func realPath(p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	rest := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		parent := filepath.Dir(abs)
+		if !errors.Is(err, fs.ErrNotExist) || parent == abs {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(abs), rest)
+		abs = parent
+	}
+}
+
+// planSync compares the trees and lists the actions, parents before
+// children and deletions last, deepest first
+// Links that opts.Links leaves out are reported as OpSkip, and -delete
+// leaves whatever sits at their paths in the destination alone.
+func planSync(src, dst string, opts SyncOptions) ([]SyncAction, error) {
+	var plan []SyncAction
+	seen := map[string]bool{}    // Source paths that belong in the destination
+	skipped := map[string]bool{} // Source links left out; not ours to delete
+
+	err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if matchAny(opts.Exclude, rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			reason := ""
+			switch opts.Links {
+			case LinksSkip:
+				reason = "symlink"
+			case LinksFollow:
+				// A link to a directory is not descended into: following
+				// those can loop forever
+				target, err := os.Stat(p)
+				switch {
+				case err != nil:
+					reason = "broken symlink"
+				case target.IsDir():
+					reason = "symlink to a directory"
+				default:
+					info = target
+				}
+			}
+			if reason != "" {
+				skipped[rel] = true
+				plan = append(plan, SyncAction{Op: OpSkip, Path: rel, Reason: reason})
+				return nil
+			}
+		}
+		if !info.IsDir() && len(opts.Include) > 0 && !matchAny(opts.Include, rel) {
+			return nil
+		}
+		seen[rel] = true
+
+		action, err := compareEntry(p, filepath.Join(dst, filepath.FromSlash(rel)), info, opts.Checksum)
+		if err != nil {
+			return err
+		}
+		if action.Op != "" {
+			action.Path = rel
+			plan = append(plan, action)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Delete {
+		var extra []SyncAction
+		err := filepath.WalkDir(dst, func(p string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) && p == dst {
+				return filepath.SkipAll // Nothing to delete yet
+			}
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dst, p)
+			if err != nil || rel == "." {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if matchAny(opts.Exclude, rel) || skipped[rel] {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() && len(opts.Include) > 0 && !matchAny(opts.Include, rel) {
+				return nil // Filtered out, so not ours to delete
+			}
+			if !seen[rel] {
+				if d.IsDir() && len(opts.Include) > 0 {
+					return nil // May hold files the filter leaves out; look inside
+				}
+				extra = append(extra, SyncAction{Op: OpDelete, Path: rel, Reason: "not in source"})
+				if d.IsDir() {
+					return filepath.SkipDir // Removed with its contents
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(extra, func(i, j int) bool { return extra[i].Path > extra[j].Path })
+		plan = append(plan, extra...)
+	}
+	return plan, nil
+}
+
+// compareEntry decides what the source entry at p, described by info, needs
+// at target; a zero SyncAction means nothing
+func compareEntry(p, target string, info fs.FileInfo, checksum bool) (SyncAction, error) {
+	have, err := os.Lstat(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		switch {
+		case info.IsDir():
+			return SyncAction{Op: OpMkdir}, nil
+		case info.Mode()&fs.ModeSymlink != 0:
+			return SyncAction{Op: OpSymlink, Reason: "new"}, nil
+		default:
+			return SyncAction{Op: OpCopy, Reason: humanBytes(info.Size())}, nil
+		}
+	}
+	if err != nil {
+		return SyncAction{}, err
+	}
+
+	switch {
+	case info.IsDir():
+		if !have.IsDir() {
+			return SyncAction{Op: OpMkdir, Reason: "replaces a " + typeName(have.Mode())}, nil
+		}
+		return SyncAction{}, nil
+	case info.Mode()&fs.ModeSymlink != 0:
+		want, err := os.Readlink(p)
+		if err != nil {
+			return SyncAction{}, err
+		}
+		if got, err := os.Readlink(target); err != nil || got != want {
+			return SyncAction{Op: OpSymlink, Reason: "points elsewhere"}, nil
+		}
+		return SyncAction{}, nil
+	case !have.Mode().IsRegular():
+		return SyncAction{Op: OpUpdate, Reason: "replaces a " + typeName(have.Mode())}, nil
+	case have.Size() != info.Size():
+		return SyncAction{Op: OpUpdate, Reason: "size differs"}, nil
+	case checksum:
+		same, err := sameContent(p, target)
+		if err != nil || same {
+			return SyncAction{}, err
+		}
+		return SyncAction{Op: OpUpdate, Reason: "content differs"}, nil
+	case !have.ModTime().Equal(info.ModTime()):
+		return SyncAction{Op: OpUpdate, Reason: "mtime differs"}, nil
+	}
+	return SyncAction{}, nil
+}
+
+// applyAction carries out one planned action
+func applyAction(src, dst string, a SyncAction) error {
+	from := filepath.Join(src, filepath.FromSlash(a.Path))
+	to := filepath.Join(dst, filepath.FromSlash(a.Path))
+	switch a.Op {
+	case OpSkip:
+		return nil
+	case OpDelete:
+		return os.RemoveAll(to)
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	// Whatever is in the way and of the wrong kind goes first
+	if have, err := os.Lstat(to); err == nil {
+		switch {
+		case a.Op == OpMkdir && !have.IsDir(),
+			a.Op == OpSymlink,
+			a.Op == OpUpdate && !have.Mode().IsRegular():
+			if err := os.RemoveAll(to); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch a.Op {
+	case OpMkdir:
+		info, err := os.Stat(from)
+		if err != nil {
+			return err
+		}
+		return os.MkdirAll(to, info.Mode().Perm())
+	case OpSymlink:
+		target, err := os.Readlink(from)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, to)
+	default:
+		// os.Open follows a link, which is what LinksFollow asked for
+		return CopyFile(from, to, CopyOptions{})
+	}
+}
+
+// matchAny reports whether rel matches one of the patterns
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		name := rel
+		if !strings.Contains(p, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sameContent reports whether two files have the same SHA-256
+func sameContent(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+	sumA, err := fileSHA256(fa)
+	if err != nil {
+		return false, err
+	}
+	sumB, err := fileSHA256(fb)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(sumA, sumB), nil
+}
+
+// typeName describes a file mode for messages
+func typeName(m fs.FileMode) string {
+	switch {
+	case m.IsDir():
+		return "directory"
+	case m&fs.ModeSymlink != 0:
+		return "symlink"
+	case m.IsRegular():
+		return "file"
+	default:
+		return "special file"
+	}
+}
+
+// patternList is a flag that can be given several times
+type patternList []string
+
+func (p *patternList) String() string     { return strings.Join(*p, ",") }
+func (p *patternList) Set(v string) error { *p = append(*p, v); return nil }
+
+func init() {
+	commands["sync"] = command{
+		usage: "[-n] [-delete] [-checksum] [-include pattern]... [-exclude pattern]... [-links keep|follow|skip] src dst",
+		help:  "copy new and changed files from one tree to another",
+		run:   runSync,
+	}
+}
+
+// runSync implements "files sync"
+func runSync(args []string) error {
+	var opts SyncOptions
+	flags := newFlagSet("sync")
+	flags.BoolVar(&opts.DryRun, "n", false, "dry run: print the plan without changing anything")
+	flags.BoolVar(&opts.Delete, "delete", false, "delete destination files missing from the source")
+	flags.BoolVar(&opts.Checksum, "checksum", false, "compare contents by SHA-256 instead of size and mtime")
+	flags.Var((*patternList)(&opts.Include), "include", "only sync files matching this glob (repeatable)")
+	flags.Var((*patternList)(&opts.Exclude), "exclude", "skip entries matching this glob (repeatable)")
+	links := flags.String("links", string(LinksKeep), "symlinks: keep, follow or skip")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+	opts.Links = LinkMode(*links)
+	if opts.Links != LinksKeep && opts.Links != LinksFollow && opts.Links != LinksSkip {
+		return fmt.Errorf("-links must be keep, follow or skip, not %q", *links)
+	}
+	if info, err := os.Stat(flags.Arg(0)); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s: not a directory", flags.Arg(0))
+	}
+
+	plan, err := Sync(flags.Arg(0), flags.Arg(1), opts)
+	changes := 0
+	for _, a := range plan {
+		fmt.Println(a)
+		if a.Op != OpSkip {
+			changes++
+		}
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case changes == 0:
+		fmt.Println("already in sync")
+	case opts.DryRun:
+		fmt.Printf("%d actions planned (dry run)\n", changes)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// planString lists a plan's ops and paths, leaving out the reasons
+func planString(plan []SyncAction) string {
+	var parts []string
+	for _, a := range plan {
+		parts = append(parts, fmt.Sprintf("%s %s", a.Op, a.Path))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// newSyncTrees fills a source and a partly matching destination
+func newSyncTrees(t *testing.T) (src, dst string) {
+	t.Helper()
+	src, dst = t.TempDir(), t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"a/same": "same", "a/size": "longer", "a/mtime": "v1", "new.txt": "new", "skip.tmp": "tmp",
+	})
+	writeFiles(t, dst, map[string]string{
+		"a/same": "same", "a/size": "short", "a/mtime": "v2", "old.txt": "old", "olddir/x": "x", "keep.tmp": "tmp",
+	})
+	// Equal times make the unchanged file look unchanged
+	mtime := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, p := range []string{filepath.Join(src, "a", "same"), filepath.Join(dst, "a", "same"), filepath.Join(src, "a", "mtime")} {
+		if err := os.Chtimes(p, time.Time{}, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return src, dst
+}
+
+func TestSyncPlan(t *testing.T) {
+	tests := []struct {
+		name string
+		opts SyncOptions
+		want string
+	}{
+		{"copy and update", SyncOptions{Exclude: []string{"*.tmp"}},
+			"update a/mtime, update a/size, copy new.txt"},
+		{"checksum", SyncOptions{Checksum: true, Exclude: []string{"*.tmp"}},
+			"update a/mtime, update a/size, copy new.txt"},
+		{"delete", SyncOptions{Delete: true, Exclude: []string{"*.tmp"}},
+			"update a/mtime, update a/size, copy new.txt, delete olddir, delete old.txt"},
+		{"delete without excludes", SyncOptions{Delete: true},
+			"update a/mtime, update a/size, copy new.txt, copy skip.tmp, delete olddir, delete old.txt, delete keep.tmp"},
+		{"include", SyncOptions{Delete: true, Include: []string{"*.txt"}},
+			"copy new.txt, delete old.txt"},
+		{"anchored exclude", SyncOptions{Exclude: []string{"a/size", "*.tmp"}},
+			"update a/mtime, copy new.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := newSyncTrees(t)
+			opts := tt.opts
+			opts.DryRun = true
+			plan, err := Sync(src, dst, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := planString(plan); got != tt.want {
+				t.Fatalf("planned %s\nwant    %s", got, tt.want)
+			}
+			if _, err := os.Stat(filepath.Join(dst, "new.txt")); err == nil {
+				t.Fatal("dry run copied a file")
+			}
+
+			opts.DryRun = false
+			if _, err := Sync(src, dst, opts); err != nil {
+				t.Fatal(err)
+			}
+			if plan, err := Sync(src, dst, opts); err != nil || len(plan) != 0 {
+				t.Errorf("second run: got %s, %v; want nothing to do", planString(plan), err)
+			}
+		})
+	}
+}
+
+func TestSyncCopiesContents(t *testing.T) {
+	src, dst := newSyncTrees(t)
+	if _, err := Sync(src, dst, SyncOptions{Delete: true}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"a/size": "longer", "a/mtime": "v1", "new.txt": "new"} {
+		if got, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name))); err != nil || string(got) != want {
+			t.Errorf("%s: got %q, %v; want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"old.txt", "olddir"} {
+		if _, err := os.Stat(filepath.Join(dst, name)); err == nil {
+			t.Errorf("%s not deleted", name)
+		}
+	}
+}
+
+func TestSyncDeleteKeepsSkippedLinks(t *testing.T) {
+	for _, mode := range []LinkMode{LinksSkip, LinksFollow} {
+		t.Run(string(mode), func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeFiles(t, src, map[string]string{"real/f": "data"})
+			if err := os.Symlink("real", filepath.Join(src, "link")); err != nil {
+				t.Skip("no symlinks here:", err)
+			}
+			// An earlier sync with -links keep or a copy by hand left this
+			writeFiles(t, dst, map[string]string{"link/f": "data"})
+
+			plan, err := Sync(src, dst, SyncOptions{Delete: true, Links: mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			reported := false
+			for _, a := range plan {
+				if a.Path == "link" && a.Op == OpSkip {
+					reported = true
+				}
+				if a.Op == OpDelete {
+					t.Errorf("planned %v", a)
+				}
+			}
+			if !reported {
+				t.Errorf("skipped link not reported in %v", plan)
+			}
+			if _, err := os.Stat(filepath.Join(dst, "link", "f")); err != nil {
+				t.Errorf("destination copy removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestSyncRefusesOverlap(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"src/f": "data"})
+	src := filepath.Join(dir, "src")
+	tests := []struct {
+		name string
+		dst  string
+		del  bool
+	}{
+		{"same directory", src, false},
+		{"destination inside source", filepath.Join(src, "backup"), false},
+		{"destination inside source, unclean", src + string(filepath.Separator) + "." + string(filepath.Separator) + "backup", false},
+		{"source inside destination with delete", dir, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Sync(src, tt.dst, SyncOptions{Delete: tt.del}); err == nil {
+				t.Fatal("Sync accepted overlapping trees")
+			}
+			if _, err := os.Stat(filepath.Join(src, "f")); err != nil {
+				t.Errorf("source damaged: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(src, "backup")); err == nil {
+				t.Error("destination created inside the source")
+			}
+		})
+	}
+}
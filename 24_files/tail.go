@@ -0,0 +1,324 @@
+// tail.go prints the end of files and follows what is appended to them,
+// like tail -F
+// The last lines are found by reading backwards from the end in blocks, so
+// a huge log costs no more than a small one. Following polls each file with
+// Stat: a file that shrinks was truncated and is read again from the start,
+// and a path that now names a different file was rotated, so the old file
+// is drained and the new one opened. A path that does not exist yet is
+// waited for.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// TailOptions control Tail
+type TailOptions struct {
+	Lines    int           // Lines to print from the end of each file first
+	Follow   bool          // Keep printing appended lines until the context ends
+	Interval time.Duration // How often to check followed files; 0 means 250ms
+	Prefix   bool          // Start every line with the file's name
+	// Notify, if set, is told about truncation and rotation
+	Notify func(path, msg string)
+}
+
+// tailBlock is how much LastLinesOffset reads at a time
+const tailBlock = 64 << 10
+
+// LastLinesOffset returns the offset of the start of the last n lines of
+// r, which holds size bytes
+// A newline at the very end finishes the last line rather than starting an
+// empty one.
+func LastLinesOffset(r io.ReaderAt, size int64, n int) (int64, error) {
+	if n <= 0 || size == 0 {
+		return size, nil
+	}
+	end := size
+	last := make([]byte, 1)
+	if _, err := r.ReadAt(last, size-1); err != nil {
+		return 0, err
+	}
+	if last[0] == '\n' {
+		end--
+	}
+	buf := make([]byte, tailBlock)
+	count := 0
+	for pos := end; pos > 0; {
+		start := max(pos-tailBlock, 0)
+		block := buf[:pos-start]
+		if _, err := r.ReadAt(block, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := len(block) - 1; i >= 0; i-- {
+			if block[i] == '\n' {
+				if count++; count == n {
+					return start + int64(i) + 1, nil
+				}
+			}
+		}
+		pos = start
+	}
+	return 0, nil
+}
+
+// tailFile is one file being tailed
+type tailFile struct {
+	path    string
+	f       *os.File // nil until a path that was missing appears
+	br      *bufio.Reader
+	offset  int64  // Bytes of f consumed so far
+	partial []byte // The start of a line whose newline has not arrived yet
+}
+
+// openTail opens path positioned at its last n lines
+func openTail(path string, n int) (*tailFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	off, err := LastLinesOffset(f, info.Size(), n)
+	if err == nil {
+		_, err = f.Seek(off, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &tailFile{path: path, f: f, br: bufio.NewReaderSize(f, tailBlock), offset: off}, nil
+}
+
+// openLate opens a path that was missing when following started, reading
+// it from the start as everything in it is new; ok is false while it is
+// still missing
+func (t *tailFile) openLate() (ok bool, err error) {
+	f, err := os.Open(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	t.f, t.br, t.offset = f, bufio.NewReaderSize(f, tailBlock), 0
+	return true, nil
+}
+
+// readLines passes every complete line available to emit and keeps any
+// unfinished one for next time
+func (t *tailFile) readLines(emit func(line []byte)) error {
+	for {
+		chunk, err := t.br.ReadSlice('\n')
+		t.offset += int64(len(chunk))
+		t.partial = append(t.partial, chunk...)
+		switch {
+		case err == nil:
+			emit(t.partial)
+			t.partial = t.partial[:0]
+		case errors.Is(err, bufio.ErrBufferFull):
+			// Keep going; only give up on a line that passes the usual limit
+			if len(t.partial) >= defaultMaxLine {
+				emit(t.partial)
+				t.partial = t.partial[:0]
+			}
+		case err == io.EOF:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// flush emits a final line that never got its newline
+func (t *tailFile) flush(emit func(line []byte)) {
+	if len(t.partial) > 0 {
+		emit(t.partial)
+		t.partial = t.partial[:0]
+	}
+}
+
+// checkReplaced handles truncation and rotation, returning a notice when
+// either happened
+func (t *tailFile) checkReplaced(emit func(line []byte)) (string, error) {
+	info, err := t.f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Size() < t.offset {
+		if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		t.br.Reset(t.f)
+		t.offset, t.partial = 0, t.partial[:0]
+		return "file truncated", nil
+	}
+	cur, err := os.Stat(t.path)
+	if err != nil || os.SameFile(cur, info) {
+		// Renamed away and not recreated yet: keep reading the old file
+		return "", nil
+	}
+	f, err := os.Open(t.path)
+	if err != nil {
+		return "", nil // Try again next time
+	}
+	// Lines written to the old file between the last read and the rename
+	// would otherwise be lost
+	if err := t.readLines(emit); err != nil {
+		f.Close()
+		return "", err
+	}
+	t.flush(emit)
+	t.f.Close()
+	t.f, t.offset = f, 0
+	t.br.Reset(f)
+	return "file replaced; following the new file", nil
+}
+
+// Tail writes the last opts.Lines lines of each file to w and, with
+// opts.Follow, then writes lines appended to any of them until ctx ends
+// When following, a file that does not exist yet is not an error: it is
+// read from its start once it appears, as with tail -F. If w has a Flush
+// method it is called after every round of reading.
+func Tail(ctx context.Context, w io.Writer, paths []string, opts TailOptions) error {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = 250 * time.Millisecond
+	}
+	notify := opts.Notify
+	if notify == nil {
+		notify = func(string, string) {}
+	}
+	flusher, _ := w.(interface{ Flush() error })
+
+	files := make([]*tailFile, 0, len(paths))
+	defer func() {
+		for _, t := range files {
+			if t.f != nil {
+				t.f.Close()
+			}
+		}
+	}()
+	for _, p := range paths {
+		t, err := openTail(p, opts.Lines)
+		if opts.Follow && errors.Is(err, fs.ErrNotExist) {
+			notify(p, "no such file; waiting for it to appear")
+			t, err = &tailFile{path: p}, nil
+		}
+		if err != nil {
+			return err
+		}
+		files = append(files, t)
+	}
+
+	var werr error
+	emitter := func(t *tailFile) func([]byte) {
+		return func(line []byte) {
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+			if werr != nil {
+				return
+			}
+			if opts.Prefix {
+				_, werr = fmt.Fprintf(w, "%s: %s\n", t.path, line)
+			} else {
+				_, werr = fmt.Fprintf(w, "%s\n", line)
+			}
+		}
+	}
+	round := func() error {
+		for _, t := range files {
+			if t.f == nil {
+				ok, err := t.openLate()
+				if err != nil {
+					return fmt.Errorf("%s: %w", t.path, err)
+				}
+				if !ok {
+					continue
+				}
+				notify(t.path, "file appeared; following it")
+			}
+			emit := emitter(t)
+			if err := t.readLines(emit); err != nil {
+				return fmt.Errorf("%s: %w", t.path, err)
+			}
+			if !opts.Follow {
+				t.flush(emit)
+				continue
+			}
+			msg, err := t.checkReplaced(emit)
+			if err != nil {
+				return fmt.Errorf("%s: %w", t.path, err)
+			}
+			if msg != "" {
+				notify(t.path, msg)
+				if err := t.readLines(emit); err != nil {
+					return fmt.Errorf("%s: %w", t.path, err)
+				}
+			}
+		}
+		if werr == nil && flusher != nil {
+			werr = flusher.Flush()
+		}
+		return werr
+	}
+
+	if err := round(); err != nil || !opts.Follow {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if err := round(); err != nil {
+			return err
+		}
+	}
+}
+
+func init() {
+	commands["tail"] = command{
+		usage: "[-n lines] [-f] [-interval d] file...",
+		help:  "print the last lines of files, optionally following them",
+		run:   runTail,
+	}
+}
+
+// runTail implements "files tail"
+func runTail(args []string) error {
+	var opts TailOptions
+	flags := newFlagSet("tail")
+	flags.IntVar(&opts.Lines, "n", 10, "lines to print from the end of each file")
+	flags.BoolVar(&opts.Follow, "f", false, "keep printing lines as they are appended")
+	flags.DurationVar(&opts.Interval, "interval", 250*time.Millisecond, "how often to check followed files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+	opts.Prefix = flags.NArg() > 1
+	out := bufio.NewWriter(os.Stdout)
+	opts.Notify = func(path, msg string) {
+		out.Flush()
+		fmt.Fprintf(os.Stderr, "files tail: %s: %s\n", path, msg)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return Tail(ctx, out, flags.Args(), opts)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a bytes.Buffer that Tail can write to while the test
+// reads it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitFor polls cond until it holds or a few seconds pass
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+	}
+}
+
+// collect returns an emit function appending lines to *lines
+func collect(lines *[]string) func([]byte) {
+	return func(line []byte) { *lines = append(*lines, strings.TrimSuffix(string(line), "\n")) }
+}
+
+func TestLastLinesOffset(t *testing.T) {
+	tests := []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"a\nb\nc\n", 2, "b\nc\n"},
+		{"a\nb\nc", 2, "b\nc"},
+		{"a\nb\nc\n", 5, "a\nb\nc\n"},
+		{"a\nb\nc\n", 0, ""},
+		{"", 3, ""},
+		{"\n\n\n", 2, "\n\n"},
+		{strings.Repeat("x", tailBlock+10) + "\nlast\n", 1, "last\n"},
+		{"first\n" + strings.Repeat("y", 2*tailBlock) + "\n", 1, strings.Repeat("y", 2*tailBlock) + "\n"},
+	}
+	for _, tt := range tests {
+		r := strings.NewReader(tt.text)
+		off, err := LastLinesOffset(r, int64(len(tt.text)), tt.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tt.text[off:]; got != tt.want {
+			t.Errorf("last %d of %.20q: got %.20q, want %.20q", tt.n, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTailFollowsTruncation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	writeFiles(t, filepath.Dir(path), map[string]string{"log": "old 1\nold 2\nold 3\n"})
+	tf, err := openTail(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tf.f.Close()
+	var lines []string
+	if err := tf.readLines(collect(&lines)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte("new\n"), 0o644); err != nil { // Truncates in place
+		t.Fatal(err)
+	}
+	msg, err := tf.checkReplaced(collect(&lines))
+	if err != nil || msg != "file truncated" {
+		t.Fatalf("got %q, %v; want a truncation notice", msg, err)
+	}
+	if err := tf.readLines(collect(&lines)); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(lines, ","); got != "old 3,new" {
+		t.Errorf("got lines %s, want old 3,new", got)
+	}
+}
+
+func TestTailFollow(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "a1\na2\n", "b": "b1\n"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var out lockedBuffer
+	var notes lockedBuffer
+	done := make(chan error)
+	opts := TailOptions{
+		Lines: 1, Follow: true, Interval: 5 * time.Millisecond, Prefix: true,
+		Notify: func(path, msg string) { notes.Write([]byte(filepath.Base(path) + ": " + msg + "\n")) },
+	}
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	go func() { done <- Tail(ctx, &out, []string{a, b}, opts) }()
+	waitFor(t, "the last lines", func() bool { return out.String() == a+": a2\n"+b+": b1\n" })
+
+	f, err := os.OpenFile(b, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("b2 without newline yet")
+	time.Sleep(30 * time.Millisecond) // Several rounds of reading
+	if strings.Contains(out.String(), "b2") {
+		t.Error("printed a line before its newline arrived")
+	}
+	f.WriteString("\n")
+	f.Close()
+	waitFor(t, "the appended line", func() bool { return strings.HasSuffix(out.String(), b+": b2 without newline yet\n") })
+
+	// Rotation: the old file is drained and the new one followed
+	if err := os.Rename(a, a+".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{"a": "fresh\n"})
+	waitFor(t, "the new file", func() bool { return strings.HasSuffix(out.String(), a+": fresh\n") })
+	if !strings.Contains(notes.String(), "a: file replaced") {
+		t.Errorf("no rotation notice in %q", notes.String())
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTailDrainsRotatedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	writeFiles(t, filepath.Dir(path), map[string]string{"log": "a\n"})
+	tf, err := openTail(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { tf.f.Close() }()
+	var lines []string
+	if err := tf.readLines(collect(&lines)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Written after the last read, then rotated away before the next
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("b\n")
+	f.Close()
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, filepath.Dir(path), map[string]string{"log": "c\n"})
+
+	msg, err := tf.checkReplaced(collect(&lines))
+	if err != nil || msg == "" {
+		t.Fatalf("got %q, %v; want a rotation notice", msg, err)
+	}
+	if err := tf.readLines(collect(&lines)); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(lines, ","); got != "a,b,c" {
+		t.Errorf("got lines %s, want a,b,c", got)
+	}
+}
+
+func TestTailWaitsForMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	if err := Tail(context.Background(), &bytes.Buffer{}, []string{path}, TailOptions{}); err == nil {
+		t.Fatal("Tail without -f accepted a missing file")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var out lockedBuffer
+	done := make(chan error)
+	go func() {
+		done <- Tail(ctx, &out, []string{path}, TailOptions{Lines: 10, Follow: true, Interval: 5 * time.Millisecond})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	writeFiles(t, filepath.Dir(path), map[string]string{"log": "first\nsecond\n"})
+	waitFor(t, "the new file's lines", func() bool { return out.String() == "first\nsecond\n" })
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
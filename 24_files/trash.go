@@ -0,0 +1,503 @@
+// trash.go deletes files in a way that can be undone
+// Instead of os.Remove, Trash.Put moves a file or directory into a trash
+// directory and writes down where it came from, so it can be listed,
+// restored to its old place, or purged for good later:
+//
+//	<trash>/files/<id>       the deleted file or directory
+//	<trash>/info/<id>.json   its TrashEntry
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Errors returned by Trash
+var (
+	ErrOutsideRoot   = errors.New("path is outside the allowed root")
+	ErrNotInTrash    = errors.New("no such entry in the trash")
+	ErrRestoreExists = errors.New("something already exists at the original path")
+)
+
+// TrashEntry describes one deleted file or directory
+type TrashEntry struct {
+	ID           string    `json:"id"`
+	OriginalPath string    `json:"original_path"` // Absolute
+	DeletedAt    time.Time `json:"deleted_at"`
+	IsDir        bool      `json:"is_dir"`
+	Size         int64     `json:"size"` // Total bytes, for directories too
+}
+
+// Trash is a trash directory plus the root deletions are confined to
+type Trash struct {
+	Dir  string // Where deleted entries are kept
+	Root string // Put refuses paths outside Root unless forced; "" allows all
+	now  func() time.Time
+}
+
+// DefaultTrashDir returns ~/.files-trash, or a directory in the temp dir
+// when there is no home directory
+func DefaultTrashDir() string {
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".files-trash")
+	}
+	return filepath.Join(os.TempDir(), "files-trash")
+}
+
+// OpenTrash returns the trash in dir, creating it if needed
+func OpenTrash(dir, root string) (*Trash, error) {
+	for _, sub := range []string{"files", "info"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	t := &Trash{Dir: abs, now: time.Now}
+	if root != "" {
+		if t.Root, err = canonicalPath(root); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Put moves path into the trash
+// Unless force is set, path must lie inside t.Root. The root itself and the
+// trash are never accepted.
+func (t *Trash) Put(path string, force bool) (*TrashEntry, error) {
+	abs, err := canonicalPath(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(abs)
+	if err != nil {
+		return nil, err
+	}
+	if abs == t.Root || within(t.Dir, abs) || within(abs, t.Dir) {
+		return nil, fmt.Errorf("%s: refusing to trash the root or the trash itself", path)
+	}
+	if t.Root != "" && !force && !within(t.Root, abs) {
+		return nil, fmt.Errorf("%s: %w %s (use force to override)", path, ErrOutsideRoot, t.Root)
+	}
+
+	l, err := t.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer l.Unlock()
+
+	id, err := t.newID(filepath.Base(abs))
+	if err != nil {
+		return nil, err
+	}
+	e := &TrashEntry{
+		ID:           id,
+		OriginalPath: abs,
+		DeletedAt:    t.now().UTC(),
+		IsDir:        info.IsDir(),
+		Size:         treeSize(abs),
+	}
+	// The record goes first: an entry without one could never be restored,
+	// while a record without an entry is simply skipped by List
+	if err := t.writeInfo(e); err != nil {
+		return nil, err
+	}
+	if err := moveTree(abs, t.filePath(id)); err != nil {
+		os.Remove(t.infoPath(id))
+		return nil, err
+	}
+	return e, nil
+}
+
+// List returns the trash's entries, most recently deleted first
+func (t *Trash) List() ([]TrashEntry, error) {
+	infos, err := os.ReadDir(filepath.Join(t.Dir, "info"))
+	if err != nil {
+		return nil, err
+	}
+	var entries []TrashEntry
+	for _, fi := range infos {
+		id, ok := strings.CutSuffix(fi.Name(), ".json")
+		if !ok {
+			continue
+		}
+		e, err := t.readInfo(id)
+		if err != nil {
+			continue // Half-written or foreign file
+		}
+		if _, err := os.Lstat(t.filePath(id)); err != nil {
+			continue // Record of a Put that never completed
+		}
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].DeletedAt.After(entries[j].DeletedAt) })
+	return entries, nil
+}
+
+// Restore moves an entry back to its original path, or to "to" if given
+// It never overwrites: ErrRestoreExists is returned if the target is taken.
+func (t *Trash) Restore(id, to string) (string, error) {
+	l, err := t.lock()
+	if err != nil {
+		return "", err
+	}
+	defer l.Unlock()
+
+	e, err := t.readInfo(id)
+	if err != nil {
+		return "", err
+	}
+	target := e.OriginalPath
+	if to != "" {
+		if target, err = filepath.Abs(to); err != nil {
+			return "", err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", err
+	}
+	err = moveNoReplace(t.filePath(id), target)
+	if errors.Is(err, fs.ErrExist) {
+		return "", fmt.Errorf("%s: %w", target, ErrRestoreExists)
+	}
+	if err != nil {
+		return "", err
+	}
+	return target, os.Remove(t.infoPath(id))
+}
+
+// Purge deletes entries for good and reports how many went
+// With ids it purges exactly those; otherwise every entry deleted more than
+// olderThan ago (0 purges everything).
+func (t *Trash) Purge(olderThan time.Duration, ids ...string) (int, error) {
+	l, err := t.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer l.Unlock()
+
+	if len(ids) == 0 {
+		entries, err := t.List()
+		if err != nil {
+			return 0, err
+		}
+		cutoff := t.now().Add(-olderThan)
+		for _, e := range entries {
+			if olderThan == 0 || e.DeletedAt.Before(cutoff) {
+				ids = append(ids, e.ID)
+			}
+		}
+	}
+	n := 0
+	for _, id := range ids {
+		if _, err := t.readInfo(id); err != nil {
+			return n, err
+		}
+		if err := os.RemoveAll(t.filePath(id)); err != nil {
+			return n, err
+		}
+		if err := os.Remove(t.infoPath(id)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// newID makes a unique, sortable, human-recognizable entry ID
+func (t *Trash) newID(name string) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return t.now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b) + "-" + name, nil
+}
+
+// lock keeps other processes from changing the trash at the same time, so
+// two restores or a restore and a purge cannot race for one entry
+func (t *Trash) lock() (*FileLock, error) {
+	return LockFile(filepath.Join(t.Dir, "trash"), 10*time.Second)
+}
+
+func (t *Trash) filePath(id string) string { return filepath.Join(t.Dir, "files", id) }
+func (t *Trash) infoPath(id string) string { return filepath.Join(t.Dir, "info", id+".json") }
+
+// writeInfo records e; a crash leaves either the whole record or none
+func (t *Trash) writeInfo(e *TrashEntry) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(t.infoPath(e.ID), data, 0o600)
+}
+
+// readInfo loads the record for id
+func (t *Trash) readInfo(id string) (*TrashEntry, error) {
+	// IDs come from users; keep them from naming files outside info/
+	if id == "" || !filepath.IsLocal(id) || strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("%q: %w", id, ErrNotInTrash)
+	}
+	data, err := os.ReadFile(t.infoPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%q: %w", id, ErrNotInTrash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e TrashEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// moveTree renames src to dst, copying and removing when they are on
+// different file systems
+func moveTree(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		_, err = Sync(src, dst, SyncOptions{})
+	} else if info.Mode()&fs.ModeSymlink != 0 {
+		var target string
+		if target, err = os.Readlink(src); err == nil {
+			err = os.Symlink(target, dst)
+		}
+	} else {
+		err = CopyFile(src, dst, CopyOptions{Verify: true})
+	}
+	if err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// moveNoReplace moves src to dst like moveTree, but fails with fs.ErrExist
+// instead of replacing anything at dst, even something created while it runs
+// A file is hard-linked to dst and then unlinked, since link never
+// replaces. A directory is renamed onto an empty directory made first to
+// hold its place, which rename replaces only while it is still empty.
+func moveNoReplace(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return moveDirNoReplace(src, dst)
+	}
+	err = os.Link(src, dst)
+	if err == nil {
+		return os.Remove(src)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return err
+	}
+
+	// No hard link across file systems: copy, then claim dst the same way
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(target, dst); err != nil {
+			return err
+		}
+		return os.Remove(src)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := CopyFile(src, tmp.Name(), CopyOptions{Verify: true}); err != nil {
+		return err
+	}
+	if err := os.Link(tmp.Name(), dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// moveDirNoReplace is moveNoReplace for directories
+func moveDirNoReplace(src, dst string) error {
+	if err := os.Mkdir(dst, 0o700); err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		// Rename never replaces a directory there, so the placeholder would
+		// only be in the way; the name is free again for a moment
+		os.Remove(dst)
+	}
+	// os.Rename refuses any existing directory up front; the system call
+	// replaces an empty one
+	err := syscall.Rename(src, dst)
+	if errors.Is(err, syscall.EXDEV) {
+		if _, err = Sync(src, dst, SyncOptions{}); err == nil {
+			return os.RemoveAll(src)
+		}
+		os.RemoveAll(dst)
+		return err
+	}
+	if errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST) {
+		return fs.ErrExist // Something was put in the placeholder
+	}
+	if err != nil {
+		os.Remove(dst) // Only while it is still our empty placeholder
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: err}
+	}
+	return nil
+}
+
+// canonicalPath makes path absolute and resolves symbolic links in its
+// parent directories, but not in the final element: trashing a link
+// trashes the link
+func canonicalPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(abs))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(abs)), nil
+}
+
+// within reports whether path is inside dir (and not dir itself)
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && filepath.IsLocal(rel)
+}
+
+// treeSize adds up the sizes of the files below path
+func treeSize(path string) int64 {
+	var total int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+func init() {
+	commands["rm"] = command{
+		usage: "[-force] [-root dir] [-trash dir] path...",
+		help:  "move files to the trash instead of deleting them",
+		run:   runRemove,
+	}
+	commands["trash"] = command{
+		usage: "[-trash dir] list | restore [-to path] id | purge [-older d] [id...]",
+		help:  "list, restore or purge trashed files",
+		run:   runTrash,
+	}
+}
+
+// runRemove implements "files rm"
+func runRemove(args []string) error {
+	flags := newFlagSet("rm")
+	force := flags.Bool("force", false, "allow paths outside the root")
+	root := flags.String("root", ".", "only delete below this directory")
+	dir := flags.String("trash", DefaultTrashDir(), "trash directory")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+	t, err := OpenTrash(*dir, *root)
+	if err != nil {
+		return err
+	}
+	for _, p := range flags.Args() {
+		e, err := t.Put(p, *force)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("trashed %s (restore with: files trash restore %s)\n", p, e.ID)
+	}
+	return nil
+}
+
+// runTrash implements "files trash"
+func runTrash(args []string) error {
+	flags := newFlagSet("trash")
+	dir := flags.String("trash", DefaultTrashDir(), "trash directory")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+	t, err := OpenTrash(*dir, "")
+	if err != nil {
+		return err
+	}
+
+	sub, rest := flags.Arg(0), flags.Args()[1:]
+	switch sub {
+	case "list":
+		entries, err := t.List()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			kind := "file"
+			if e.IsDir {
+				kind = "dir"
+			}
+			fmt.Printf("%s  %s  %-4s %9s  %s\n", e.ID, e.DeletedAt.Local().Format(time.DateTime), kind, humanBytes(e.Size), e.OriginalPath)
+		}
+		return nil
+	case "restore":
+		rf := newFlagSet("trash")
+		to := rf.String("to", "", "restore here instead of the original path")
+		if err := rf.Parse(rest); err != nil {
+			return err
+		}
+		if rf.NArg() != 1 {
+			return errUsage
+		}
+		target, err := t.Restore(rf.Arg(0), *to)
+		if err != nil {
+			return err
+		}
+		fmt.Println("restored", target)
+		return nil
+	case "purge":
+		pf := newFlagSet("trash")
+		older := pf.Duration("older", 0, "only purge entries deleted longer ago than this, e.g. 720h")
+		if err := pf.Parse(rest); err != nil {
+			return err
+		}
+		n, err := t.Purge(*older, pf.Args()...)
+		fmt.Printf("purged %d entries\n", n)
+		return err
+	}
+	return errUsage
+}
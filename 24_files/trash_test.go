@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestTrash returns a trash confined to root, kept outside it
+func newTestTrash(t *testing.T, root string) *Trash {
+	t.Helper()
+	trash, err := OpenTrash(filepath.Join(t.TempDir(), "trash"), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trash
+}
+
+func TestTrashPutRestore(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"f.txt": "file", "d/a": "a", "d/sub/b": "b"})
+	trash := newTestTrash(t, root)
+
+	for _, name := range []string{"f.txt", "d"} {
+		t.Run(name, func(t *testing.T) {
+			p := filepath.Join(root, name)
+			e, err := trash.Put(p, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Lstat(p); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("still there after Put: %v", err)
+			}
+			entries, err := trash.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, l := range entries {
+				found = found || l.ID == e.ID
+			}
+			if !found {
+				t.Fatalf("%s missing from List %v", e.ID, entries)
+			}
+
+			got, err := trash.Restore(e.ID, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != e.OriginalPath {
+				t.Errorf("restored to %s, want %s", got, e.OriginalPath)
+			}
+			if _, err := trash.Restore(e.ID, ""); !errors.Is(err, ErrNotInTrash) {
+				t.Errorf("second Restore: got %v, want ErrNotInTrash", err)
+			}
+		})
+	}
+	if b, err := os.ReadFile(filepath.Join(root, "d", "sub", "b")); err != nil || string(b) != "b" {
+		t.Errorf("restored tree: got %q, %v", b, err)
+	}
+}
+
+func TestTrashRestoreNeverReplaces(t *testing.T) {
+	for _, name := range []string{"f.txt", "d"} {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, map[string]string{"f.txt": "old", "d/a": "old"})
+			trash := newTestTrash(t, root)
+			p := filepath.Join(root, name)
+			e, err := trash.Put(p, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Something new took the name meanwhile
+			if name == "d" {
+				writeFiles(t, root, map[string]string{"d/new": "new"})
+			} else {
+				writeFiles(t, root, map[string]string{name: "new"})
+			}
+
+			if _, err := trash.Restore(e.ID, ""); !errors.Is(err, ErrRestoreExists) {
+				t.Fatalf("got %v, want ErrRestoreExists", err)
+			}
+			if entries, _ := trash.List(); len(entries) != 1 {
+				t.Errorf("entry gone from the trash after a refused Restore: %v", entries)
+			}
+			if _, err := os.Stat(filepath.Join(root, "d", "a")); name == "d" && err == nil {
+				t.Error("trashed directory merged into the new one")
+			}
+
+			other := filepath.Join(root, "restored")
+			if _, err := trash.Restore(e.ID, other); err != nil {
+				t.Fatalf("Restore elsewhere: %v", err)
+			}
+		})
+	}
+}
+
+func TestTrashPutRefuses(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	writeFiles(t, outside, map[string]string{"f": "x"})
+	trash := newTestTrash(t, root)
+	tests := []struct {
+		name    string
+		path    string
+		force   bool
+		wantErr error
+	}{
+		{"outside the root", filepath.Join(outside, "f"), false, ErrOutsideRoot},
+		{"the root", root, true, nil},
+		{"the trash", trash.Dir, true, nil},
+		{"inside the trash", filepath.Join(trash.Dir, "files"), true, nil},
+		{"missing", filepath.Join(root, "missing"), false, os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := trash.Put(tt.path, tt.force)
+			if err == nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if _, err := os.Stat(tt.path); tt.wantErr != os.ErrNotExist && err != nil {
+				t.Errorf("refused path was moved: %v", err)
+			}
+		})
+	}
+
+	if _, err := trash.Put(filepath.Join(outside, "f"), true); err != nil {
+		t.Errorf("forced Put outside the root: %v", err)
+	}
+}
@@ -0,0 +1,417 @@
+// watch.go reports changes in a directory tree as they happen
+// Two implementations sit behind the Watcher interface: a polling one that
+// rescans the tree with the same ReadDir/Stat calls files.go uses, so it
+// works on every system, and on Linux an inotify one (watch_linux.go) that
+// the kernel wakes up. Debounce merges the bursts of events a single save
+// tends to produce.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// WatchOp is the kind of a WatchEvent
+type WatchOp int
+
+// Kinds of change
+const (
+	WatchCreate WatchOp = iota + 1
+	WatchModify
+	WatchDelete
+	WatchRename
+)
+
+func (op WatchOp) String() string {
+	switch op {
+	case WatchCreate:
+		return "create"
+	case WatchModify:
+		return "modify"
+	case WatchDelete:
+		return "delete"
+	case WatchRename:
+		return "rename"
+	}
+	return fmt.Sprintf("WatchOp(%d)", int(op))
+}
+
+// WatchEvent is one change below the watched root
+type WatchEvent struct {
+	Op      WatchOp
+	Path    string // The entry's path; for renames, its new path
+	OldPath string // For renames, the path it had before; kept by Debounce on a delete that follows one
+	IsDir   bool
+	Time    time.Time
+}
+
+func (e WatchEvent) String() string {
+	if e.Op == WatchRename {
+		return fmt.Sprintf("%s %s -> %s", e.Op, e.OldPath, e.Path)
+	}
+	if e.OldPath != "" {
+		return fmt.Sprintf("%s %s (was %s)", e.Op, e.Path, e.OldPath)
+	}
+	return fmt.Sprintf("%s %s", e.Op, e.Path)
+}
+
+// Watcher delivers events for a directory tree until it is closed
+// Both channels are closed when the watcher stops.
+type Watcher interface {
+	Events() <-chan WatchEvent
+	Errors() <-chan error
+	Close() error
+}
+
+// WatchOptions control NewWatcher
+type WatchOptions struct {
+	Poll     bool          // Use polling even where a native watcher exists
+	Interval time.Duration // Polling interval; 0 means one second
+	Debounce time.Duration // Quiet period before an event is reported; 0 reports at once
+}
+
+// errNoNativeWatcher is returned by newNativeWatcher where there is none
+var errNoNativeWatcher = errors.New("no native watcher on this system")
+
+// NewWatcher watches the tree below root, natively if it can and by
+// polling otherwise
+func NewWatcher(root string, opts WatchOptions) (Watcher, error) {
+	var w Watcher
+	var err error = errNoNativeWatcher
+	if !opts.Poll {
+		w, err = newNativeWatcher(root) // watch_linux.go / watch_other.go
+	}
+	if err != nil {
+		w, err = NewPollWatcher(root, opts.Interval)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.Debounce > 0 {
+		w = Debounce(w, opts.Debounce)
+	}
+	return w, nil
+}
+
+// entryState is what the poller remembers about one path
+type entryState struct {
+	size  int64
+	mtime time.Time
+	mode  fs.FileMode
+	id    uint64 // Inode, for spotting renames; 0 where unknown
+}
+
+// PollWatcher finds changes by comparing snapshots of the tree
+type PollWatcher struct {
+	root     string
+	interval time.Duration
+	events   chan WatchEvent
+	errors   chan error
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewPollWatcher starts polling the tree below root every interval
+func NewPollWatcher(root string, interval time.Duration) (*PollWatcher, error) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	prev, err := snapshot(root)
+	if err != nil {
+		return nil, err
+	}
+	w := &PollWatcher{
+		root:     root,
+		interval: interval,
+		events:   make(chan WatchEvent, 64),
+		errors:   make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	go w.loop(prev)
+	return w, nil
+}
+
+// Events returns the event channel
+func (w *PollWatcher) Events() <-chan WatchEvent { return w.events }
+
+// Errors returns the channel of scan errors
+func (w *PollWatcher) Errors() <-chan error { return w.errors }
+
+// Close stops polling
+func (w *PollWatcher) Close() error {
+	w.once.Do(func() { close(w.done) })
+	return nil
+}
+
+// loop rescans the tree until Close
+func (w *PollWatcher) loop(prev map[string]entryState) {
+	defer close(w.events)
+	defer close(w.errors)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+		cur, err := snapshot(w.root)
+		if err != nil {
+			select {
+			case w.errors <- err:
+			default: // The last error is still unread; drop this one
+			}
+			continue
+		}
+		for _, e := range diffSnapshots(prev, cur, time.Now()) {
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return
+			}
+		}
+		prev = cur
+	}
+}
+
+// snapshot records the state of every entry below root
+func snapshot(root string) (map[string]entryState, error) {
+	states := make(map[string]entryState)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Entries can vanish between ReadDir and Stat; that is a
+			// delete the next scan will see, not an error
+			if errors.Is(err, fs.ErrNotExist) && p != root {
+				return nil
+			}
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		states[p] = entryState{size: info.Size(), mtime: info.ModTime(), mode: info.Mode(), id: fileID(info)}
+		return nil
+	})
+	return states, err
+}
+
+// diffSnapshots turns two snapshots into events
+// A path that disappeared while a new one with the same inode appeared is
+// reported as a rename.
+func diffSnapshots(prev, cur map[string]entryState, now time.Time) []WatchEvent {
+	var events []WatchEvent
+	created := make(map[uint64]string) // Inode -> new path
+	for p, s := range cur {
+		old, ok := prev[p]
+		switch {
+		case !ok:
+			if s.id != 0 {
+				created[s.id] = p
+			}
+		case old.mode.Type() != s.mode.Type() || (old.id != 0 && old.id != s.id):
+			// Replaced by a different file or kind of entry
+			events = append(events, WatchEvent{Op: WatchDelete, Path: p, IsDir: old.mode.IsDir(), Time: now},
+				WatchEvent{Op: WatchCreate, Path: p, IsDir: s.mode.IsDir(), Time: now})
+		case old.size != s.size || !old.mtime.Equal(s.mtime) || old.mode != s.mode:
+			events = append(events, WatchEvent{Op: WatchModify, Path: p, IsDir: s.mode.IsDir(), Time: now})
+		}
+	}
+	renamed := make(map[string]bool) // New paths already reported by a rename
+	for p, s := range prev {
+		if _, ok := cur[p]; ok {
+			continue
+		}
+		if np, ok := created[s.id]; ok && s.id != 0 {
+			events = append(events, WatchEvent{Op: WatchRename, Path: np, OldPath: p, IsDir: s.mode.IsDir(), Time: now})
+			renamed[np] = true
+			continue
+		}
+		events = append(events, WatchEvent{Op: WatchDelete, Path: p, IsDir: s.mode.IsDir(), Time: now})
+	}
+	for p, s := range cur {
+		if _, ok := prev[p]; !ok && !renamed[p] {
+			events = append(events, WatchEvent{Op: WatchCreate, Path: p, IsDir: s.mode.IsDir(), Time: now})
+		}
+	}
+	sortEvents(events)
+	return events
+}
+
+// sortEvents orders a batch by path so output is stable
+func sortEvents(events []WatchEvent) {
+	// Insertion sort: batches are small
+	for i := 1; i < len(events); i++ {
+		for j := i; j > 0 && events[j].Path < events[j-1].Path; j-- {
+			events[j], events[j-1] = events[j-1], events[j]
+		}
+	}
+}
+
+// debouncer wraps a Watcher and holds each path's events until the path has
+// been quiet for a while
+type debouncer struct {
+	inner  Watcher
+	quiet  time.Duration
+	events chan WatchEvent
+	done   chan struct{} // Closed by Close; pending events are dropped
+	once   sync.Once
+}
+
+// Debounce returns a Watcher that reports a path only after it has seen no
+// events for quiet, merging what happened meanwhile: a create followed by
+// modifies is one create, a create followed by a delete is nothing.
+func Debounce(w Watcher, quiet time.Duration) Watcher {
+	d := &debouncer{inner: w, quiet: quiet, events: make(chan WatchEvent, 64), done: make(chan struct{})}
+	go d.loop()
+	return d
+}
+
+func (d *debouncer) Events() <-chan WatchEvent { return d.events }
+func (d *debouncer) Errors() <-chan error      { return d.inner.Errors() }
+
+func (d *debouncer) Close() error {
+	d.once.Do(func() { close(d.done) })
+	return d.inner.Close()
+}
+
+// loop merges events per path and flushes those that have gone quiet, and
+// everything still pending once the inner watcher stops
+func (d *debouncer) loop() {
+	defer close(d.events)
+	pending := make(map[string]WatchEvent)
+	var order []string // Paths in first-seen order, for stable output
+	ticker := time.NewTicker(max(d.quiet/4, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-d.inner.Events():
+			if !ok {
+				for _, p := range order {
+					e, ok := pending[p]
+					if !ok {
+						continue
+					}
+					select {
+					case d.events <- e:
+					case <-d.done:
+						return
+					}
+				}
+				return
+			}
+			prev, seen := pending[e.Path]
+			if !seen {
+				order = append(order, e.Path)
+			}
+			if merged, keep := mergeEvents(prev, seen, e); keep {
+				pending[e.Path] = merged
+			} else {
+				delete(pending, e.Path)
+			}
+		case now := <-ticker.C:
+			kept := order[:0]
+			for _, p := range order {
+				e, ok := pending[p]
+				if !ok {
+					continue
+				}
+				if now.Sub(e.Time) >= d.quiet {
+					select {
+					case d.events <- e:
+					case <-d.done:
+						return
+					}
+					delete(pending, p)
+					continue
+				}
+				kept = append(kept, p)
+			}
+			order = kept
+		}
+	}
+}
+
+// mergeEvents combines a pending event (if seen) with a newer one for the
+// same path; keep is false when they cancel out
+func mergeEvents(prev WatchEvent, seen bool, next WatchEvent) (merged WatchEvent, keep bool) {
+	if !seen {
+		return next, true
+	}
+	merged = next // The newest time restarts the quiet period
+	switch {
+	case prev.Op == WatchCreate && next.Op == WatchModify:
+		merged.Op = WatchCreate
+	case prev.Op == WatchCreate && next.Op == WatchDelete:
+		return WatchEvent{}, false
+	case prev.Op == WatchDelete && next.Op == WatchCreate:
+		merged.Op = WatchModify
+	case prev.Op == WatchRename && next.Op == WatchModify:
+		merged.Op, merged.OldPath = WatchRename, prev.OldPath
+	case prev.Op == WatchRename && next.Op == WatchDelete:
+		// Without OldPath nothing would say the file once was there
+		merged.OldPath = prev.OldPath
+	}
+	return merged, true
+}
+
+func init() {
+	commands["watch"] = command{
+		usage: "[-poll] [-interval d] [-debounce d] dir",
+		help:  "print create/modify/delete/rename events for a tree",
+		run:   runWatch,
+	}
+}
+
+// runWatch implements "files watch"
+func runWatch(args []string) error {
+	var opts WatchOptions
+	flags := newFlagSet("watch")
+	flags.BoolVar(&opts.Poll, "poll", false, "poll instead of using inotify")
+	flags.DurationVar(&opts.Interval, "interval", time.Second, "polling interval")
+	flags.DurationVar(&opts.Debounce, "debounce", 100*time.Millisecond, "merge events until a path is quiet this long")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	w, err := NewWatcher(flags.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "watching %s, Ctrl-C to stop\n", flags.Arg(0))
+	errs := w.Errors()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-w.Events():
+			if !ok {
+				return nil
+			}
+			fmt.Printf("%s %s\n", e.Time.Format("15:04:05.000"), e)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil // Closed before Events by some watchers; stop selecting it
+				continue
+			}
+			fmt.Fprintln(os.Stderr, "files watch:", err)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// inotifyMask is what every watched directory reports
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DONT_FOLLOW | syscall.IN_ONLYDIR
+
+// InotifyWatcher is a Watcher fed by the Linux kernel
+// inotify watches single directories, so every directory in the tree gets
+// its own watch, and new directories are added as they appear.
+type InotifyWatcher struct {
+	fd     int              // For inotify_add_watch; File.Fd would make the descriptor blocking
+	file   *os.File         // The same descriptor; closing it ends the read loop
+	dirs   map[int32]string // Watch descriptor -> directory; only the loop touches it after start
+	events chan WatchEvent
+	errors chan error
+	done   chan struct{} // Closed by Close, so a blocked send gives up
+	once   sync.Once
+}
+
+// newNativeWatcher returns an InotifyWatcher for root
+func newNativeWatcher(root string) (Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// A non-blocking descriptor lets os.File use the runtime poller, so
+	// Close wakes up a pending Read
+	w := &InotifyWatcher{
+		fd:     fd,
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		dirs:   make(map[int32]string),
+		events: make(chan WatchEvent, 64),
+		errors: make(chan error, 1),
+		done:   make(chan struct{}),
+	}
+	if err := w.addTree(root, nil); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	go w.loop()
+	return w, nil
+}
+
+// Events returns the event channel
+func (w *InotifyWatcher) Events() <-chan WatchEvent { return w.events }
+
+// Errors returns the error channel
+func (w *InotifyWatcher) Errors() <-chan error { return w.errors }
+
+// Close removes every watch
+func (w *InotifyWatcher) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		err = w.file.Close()
+	})
+	return err
+}
+
+// addTree watches dir and every directory below it
+// found, if not nil, is called for each entry below dir; the loop uses it
+// to report files that were created in a new directory before its watch
+// was in place.
+func (w *InotifyWatcher) addTree(dir string, found func(p string, d fs.DirEntry)) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && p != dir {
+				return nil
+			}
+			return err
+		}
+		if p != dir && found != nil {
+			found(p, d)
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, p, inotifyMask)
+		if err != nil {
+			return &os.PathError{Op: "inotify_add_watch", Path: p, Err: err}
+		}
+		w.dirs[int32(wd)] = p
+		return nil
+	})
+}
+
+// loop reads and translates kernel events until the descriptor is closed
+func (w *InotifyWatcher) loop() {
+	defer close(w.events)
+	defer close(w.errors)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				select {
+				case w.errors <- err:
+				default:
+				}
+			}
+			return
+		}
+		for _, e := range w.parse(buf[:n], time.Now()) {
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// parse translates one read's worth of raw inotify events
+func (w *InotifyWatcher) parse(buf []byte, now time.Time) []WatchEvent {
+	var events []WatchEvent
+	movedFrom := make(map[uint32]WatchEvent) // By cookie, waiting for their IN_MOVED_TO
+	var order []uint32
+
+	for off := 0; off+syscall.SizeofInotifyEvent <= len(buf); {
+		// struct inotify_event { int wd; uint32 mask, cookie, len; char name[]; }
+		wd := int32(binary.NativeEndian.Uint32(buf[off:]))
+		mask := binary.NativeEndian.Uint32(buf[off+4:])
+		cookie := binary.NativeEndian.Uint32(buf[off+8:])
+		nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+		name := string(bytes.TrimRight(buf[off+syscall.SizeofInotifyEvent:off+syscall.SizeofInotifyEvent+nameLen], "\x00"))
+		off += syscall.SizeofInotifyEvent + nameLen
+
+		if mask&syscall.IN_Q_OVERFLOW != 0 {
+			select {
+			case w.errors <- errors.New("inotify queue overflowed; some events were lost"):
+			default:
+			}
+			continue
+		}
+		dir, ok := w.dirs[wd]
+		if mask&syscall.IN_IGNORED != 0 {
+			delete(w.dirs, wd) // The directory is gone or unwatched
+			continue
+		}
+		if !ok || name == "" {
+			continue
+		}
+		p := filepath.Join(dir, name)
+		isDir := mask&syscall.IN_ISDIR != 0
+		e := WatchEvent{Path: p, IsDir: isDir, Time: now}
+
+		switch {
+		case mask&syscall.IN_MOVED_FROM != 0:
+			e.Op = WatchDelete // Unless a matching IN_MOVED_TO turns up
+			movedFrom[cookie] = e
+			order = append(order, cookie)
+			continue
+		case mask&syscall.IN_MOVED_TO != 0:
+			if from, ok := movedFrom[cookie]; ok {
+				delete(movedFrom, cookie)
+				e.Op, e.OldPath = WatchRename, from.Path
+				if isDir {
+					w.renameDirs(from.Path, p)
+				}
+			} else {
+				e.Op = WatchCreate // Moved in from outside the tree
+				if isDir {
+					events = append(events, e)
+					events = append(events, w.addNewDir(p, now)...)
+					continue
+				}
+			}
+		case mask&syscall.IN_CREATE != 0:
+			e.Op = WatchCreate
+			if isDir {
+				events = append(events, e)
+				events = append(events, w.addNewDir(p, now)...)
+				continue
+			}
+		case mask&syscall.IN_DELETE != 0:
+			e.Op = WatchDelete
+		case mask&(syscall.IN_MODIFY|syscall.IN_ATTRIB) != 0:
+			e.Op = WatchModify
+		default:
+			continue
+		}
+		events = append(events, e)
+	}
+
+	// Moved out of the tree: from here that is a delete
+	for _, c := range order {
+		if e, ok := movedFrom[c]; ok {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// addNewDir watches a directory that just appeared and reports what was
+// already created inside it
+func (w *InotifyWatcher) addNewDir(dir string, now time.Time) []WatchEvent {
+	var events []WatchEvent
+	err := w.addTree(dir, func(p string, d fs.DirEntry) {
+		events = append(events, WatchEvent{Op: WatchCreate, Path: p, IsDir: d.IsDir(), Time: now})
+	})
+	if err != nil {
+		select {
+		case w.errors <- fmt.Errorf("watching new directory: %w", err):
+		default:
+		}
+	}
+	return events
+}
+
+// renameDirs updates the remembered paths of a renamed directory and of
+// every watched directory below it; the kernel keeps the watches themselves
+func (w *InotifyWatcher) renameDirs(from, to string) {
+	for wd, dir := range w.dirs {
+		if dir == from {
+			w.dirs[wd] = to
+		} else if rel, err := filepath.Rel(from, dir); err == nil && filepath.IsLocal(rel) {
+			w.dirs[wd] = filepath.Join(to, rel)
+		}
+	}
+}
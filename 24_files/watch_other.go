@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// newNativeWatcher reports that only polling is available here
+func newNativeWatcher(root string) (Watcher, error) {
+	return nil, errNoNativeWatcher
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeWatcher is a Watcher fed by the test
+type fakeWatcher struct {
+	events chan WatchEvent
+	errors chan error
+}
+
+func newFakeWatcher() *fakeWatcher {
+	return &fakeWatcher{events: make(chan WatchEvent, 16), errors: make(chan error)}
+}
+
+func (w *fakeWatcher) Events() <-chan WatchEvent { return w.events }
+func (w *fakeWatcher) Errors() <-chan error      { return w.errors }
+func (w *fakeWatcher) Close() error              { return nil }
+
+func TestMergeEvents(t *testing.T) {
+	tests := []struct {
+		name       string
+		prev, next WatchEvent
+		want       WatchEvent
+		keep       bool
+	}{
+		{"create then modify", WatchEvent{Op: WatchCreate, Path: "a"}, WatchEvent{Op: WatchModify, Path: "a"}, WatchEvent{Op: WatchCreate, Path: "a"}, true},
+		{"create then delete", WatchEvent{Op: WatchCreate, Path: "a"}, WatchEvent{Op: WatchDelete, Path: "a"}, WatchEvent{}, false},
+		{"delete then create", WatchEvent{Op: WatchDelete, Path: "a"}, WatchEvent{Op: WatchCreate, Path: "a"}, WatchEvent{Op: WatchModify, Path: "a"}, true},
+		{"rename then modify", WatchEvent{Op: WatchRename, Path: "b", OldPath: "a"}, WatchEvent{Op: WatchModify, Path: "b"}, WatchEvent{Op: WatchRename, Path: "b", OldPath: "a"}, true},
+		{"rename then delete", WatchEvent{Op: WatchRename, Path: "b", OldPath: "a"}, WatchEvent{Op: WatchDelete, Path: "b"}, WatchEvent{Op: WatchDelete, Path: "b", OldPath: "a"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, keep := mergeEvents(tt.prev, true, tt.next)
+			if keep != tt.keep || got != tt.want {
+				t.Errorf("got %v, %v; want %v, %v", got, keep, tt.want, tt.keep)
+			}
+		})
+	}
+}
+
+func TestDebounceFlushesOnClose(t *testing.T) {
+	inner := newFakeWatcher()
+	d := Debounce(inner, time.Hour) // Nothing goes quiet during the test
+	defer d.Close()
+	inner.events <- WatchEvent{Op: WatchCreate, Path: "a"}
+	inner.events <- WatchEvent{Op: WatchModify, Path: "a"}
+	inner.events <- WatchEvent{Op: WatchRename, Path: "c", OldPath: "b"}
+	inner.events <- WatchEvent{Op: WatchDelete, Path: "c"}
+	close(inner.events)
+
+	var got []string
+	for e := range d.Events() {
+		got = append(got, e.String())
+	}
+	want := []string{"create a", "delete c (was b)"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,466 @@
+// Package auth (apikey.go)
+// This file issues API keys to machine clients that cannot log in with a
+// password. A key looks like
+//
+//	gk_3f9a1c0b7e2d4a56_<secret>
+//
+// "gk_" and the hex ID form its prefix, which identifies the key,
+// is stored in the clear and is safe to show in listings and logs. Only a
+// hash of the secret is stored, so like session tokens the full key exists
+// only in the caller's hands.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rbrishi/Golang/internal/atomicfile"
+	"github.com/rbrishi/Golang/user"
+)
+
+// Errors returned by APIKeys
+var (
+	// ErrInvalidAPIKey covers malformed, unknown and wrong keys alike
+	ErrInvalidAPIKey = errors.New("auth: invalid api key")
+	ErrAPIKeyRevoked = errors.New("auth: api key revoked")
+	ErrAPIKeyExpired = errors.New("auth: api key expired")
+	ErrAPIKeyNoScope = errors.New("auth: api key needs at least one scope")
+	ErrAPIKeyUnknown = errors.New("auth: api key not found")
+)
+
+// apiKeyTag starts every key, so leaked keys are easy to spot in code and logs
+const apiKeyTag = "gk_"
+
+// lastUsedResolution bounds how often a busy key's LastUsedAt is written back
+const lastUsedResolution = time.Minute
+
+// APIKey is the stored form of an issued key
+type APIKey struct {
+	ID         string            `json:"id"`     // Random part of the prefix
+	Prefix     string            `json:"prefix"` // "gk_" + ID, shown to users
+	Hash       string            `json:"hash"`   // SHA-256 of the secret part
+	Name       string            `json:"name"`   // What the key is for, e.g. "ci deploy"
+	UserID     string            `json:"user_id"`
+	Scopes     []user.Permission `json:"scopes"`
+	CreatedAt  time.Time         `json:"created_at"`
+	ExpiresAt  time.Time         `json:"expires_at,omitzero"` // Zero means the key does not expire
+	LastUsedAt time.Time         `json:"last_used_at,omitzero"`
+	RevokedAt  time.Time         `json:"revoked_at,omitzero"` // Revoked keys are kept for the record
+}
+
+// Revoked reports whether the key has been revoked
+func (k APIKey) Revoked() bool {
+	return !k.RevokedAt.IsZero()
+}
+
+// APIKeyStore is implemented by anything that can persist API keys
+// Implementations must be safe for concurrent use.
+type APIKeyStore interface {
+	GetAPIKey(id string) (APIKey, error)
+	PutAPIKey(k APIKey) error
+	// TouchAPIKey sets the LastUsedAt of an existing key and changes
+	// nothing else, so it cannot undo a Revoke that ran meanwhile.
+	TouchAPIKey(id string, lastUsed time.Time) error
+	// ListAPIKeys returns the keys of one user, oldest first
+	ListAPIKeys(userID string) ([]APIKey, error)
+}
+
+// MemoryAPIKeyStore is an APIKeyStore that keeps keys in a map
+type MemoryAPIKeyStore struct {
+	mu   sync.RWMutex      // Protects keys
+	keys map[string]APIKey // Keyed by APIKey.ID
+}
+
+// NewMemoryAPIKeyStore returns an empty MemoryAPIKeyStore
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{keys: make(map[string]APIKey)}
+}
+
+// GetAPIKey returns the key with the given ID
+func (m *MemoryAPIKeyStore) GetAPIKey(id string) (APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	k, ok := m.keys[id]
+	if !ok {
+		return APIKey{}, ErrAPIKeyUnknown
+	}
+	return k, nil
+}
+
+// PutAPIKey creates or replaces k
+func (m *MemoryAPIKeyStore) PutAPIKey(k APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[k.ID] = k
+	return nil
+}
+
+// TouchAPIKey updates LastUsedAt of the key with the given ID
+func (m *MemoryAPIKeyStore) TouchAPIKey(id string, lastUsed time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.keys[id]
+	if !ok {
+		return ErrAPIKeyUnknown
+	}
+	k.LastUsedAt = lastUsed
+	m.keys[id] = k
+	return nil
+}
+
+// ListAPIKeys returns the keys belonging to userID, oldest first
+func (m *MemoryAPIKeyStore) ListAPIKeys(userID string) ([]APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var keys []APIKey
+	for _, k := range m.keys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	sortAPIKeys(keys)
+	return keys, nil
+}
+
+// FileAPIKeyStore is an APIKeyStore backed by a JSON file
+// Like FileStore it keeps everything in memory and rewrites the file
+// atomically after every change.
+type FileAPIKeyStore struct {
+	path string
+	mem  *MemoryAPIKeyStore   // In-memory copy of the file contents
+	mu   sync.Mutex           // Serializes writes so the file matches mem
+	lock *atomicfile.FileLock // Held until Close, as in FileStore
+}
+
+// OpenFileAPIKeyStore loads the keys in path
+// A missing file is treated as an empty store and created on the first write.
+// Like OpenFileStore, it refuses a file another open store holds.
+func OpenFileAPIKeyStore(path string) (*FileAPIKeyStore, error) {
+	lock, err := atomicfile.Lock(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileAPIKeyStore{path: path, mem: NewMemoryAPIKeyStore(), lock: lock}
+	if err := s.load(); err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the file into s.mem
+func (s *FileAPIKeyStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		s.mem.PutAPIKey(k)
+	}
+	return nil
+}
+
+// Close releases the file for other stores
+func (s *FileAPIKeyStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lock.Unlock()
+}
+
+// GetAPIKey returns the key with the given ID
+func (s *FileAPIKeyStore) GetAPIKey(id string) (APIKey, error) {
+	return s.mem.GetAPIKey(id)
+}
+
+// ListAPIKeys returns the keys belonging to userID, oldest first
+func (s *FileAPIKeyStore) ListAPIKeys(userID string) ([]APIKey, error) {
+	return s.mem.ListAPIKeys(userID)
+}
+
+// PutAPIKey creates or replaces k and persists the store
+func (s *FileAPIKeyStore) PutAPIKey(k APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := s.mem.GetAPIKey(k.ID)
+	s.mem.PutAPIKey(k)
+	if werr := s.save(); werr != nil {
+		// Roll back so memory keeps matching what is on disk
+		s.mem.mu.Lock()
+		if err == nil {
+			s.mem.keys[k.ID] = old
+		} else {
+			delete(s.mem.keys, k.ID)
+		}
+		s.mem.mu.Unlock()
+		return werr
+	}
+	return nil
+}
+
+// TouchAPIKey updates LastUsedAt of the key with the given ID and
+// persists the store
+func (s *FileAPIKeyStore) TouchAPIKey(id string, lastUsed time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := s.mem.GetAPIKey(id)
+	if err != nil {
+		return err
+	}
+	s.mem.TouchAPIKey(id, lastUsed)
+	if err := s.save(); err != nil {
+		s.mem.TouchAPIKey(id, old.LastUsedAt)
+		return err
+	}
+	return nil
+}
+
+// save writes every key to the file. The caller must hold s.mu.
+func (s *FileAPIKeyStore) save() error {
+	s.mem.mu.RLock()
+	keys := make([]APIKey, 0, len(s.mem.keys))
+	for _, k := range s.mem.keys {
+		keys = append(keys, k)
+	}
+	s.mem.mu.RUnlock()
+	sortAPIKeys(keys)
+
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	// 0600: the hashes are not secrets, but nobody else needs the list
+	return atomicfile.WriteFile(s.path, data, 0o600)
+}
+
+// sortAPIKeys orders keys by creation time, then ID
+func sortAPIKeys(keys []APIKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+}
+
+// APIKeys issues and verifies API keys for users in a user.Repository
+type APIKeys struct {
+	store   APIKeyStore
+	auth    *Authenticator // Optional; a disabled account's keys stop working
+	users   user.Repository
+	auditor Auditor
+	now     func() time.Time
+}
+
+// NewAPIKeys returns an APIKeys keeping keys in store for users in users
+// The keys of a user whose credential in a is disabled are refused with
+// ErrAccountDisabled, as a password login would be. a may be nil when
+// there are no credentials.
+func NewAPIKeys(store APIKeyStore, a *Authenticator, users user.Repository) *APIKeys {
+	return &APIKeys{store: store, auth: a, users: users, now: time.Now}
+}
+
+// SetAuditor makes key verification report to auditor
+func (a *APIKeys) SetAuditor(auditor Auditor) {
+	a.auditor = auditor
+}
+
+// Issue creates a key acting as u, limited to scopes, that expires after
+// ttl (zero for never)
+// The returned string is the only copy of the full key: hand it to the
+// client and forget it. Use user.AllPermissions as the scope for a key that
+// may do everything its user may.
+func (a *APIKeys) Issue(u *user.User, name string, scopes []user.Permission, ttl time.Duration) (string, *APIKey, error) {
+	if len(scopes) == 0 {
+		return "", nil, ErrAPIKeyNoScope
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	secret, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+	id := hex.EncodeToString(b)
+	now := a.now().UTC()
+	k := APIKey{
+		ID:        id,
+		Prefix:    apiKeyTag + id,
+		Hash:      hashToken(secret),
+		Name:      strings.TrimSpace(name),
+		UserID:    u.ID,
+		Scopes:    append([]user.Permission(nil), scopes...),
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		k.ExpiresAt = now.Add(ttl)
+	}
+	if err := a.store.PutAPIKey(k); err != nil {
+		return "", nil, err
+	}
+	return k.Prefix + "_" + secret, &k, nil
+}
+
+// Verify checks a presented key and returns the principal it acts as
+// The principal carries the user's current roles, narrowed by the key's
+// scopes, and Method "apikey".
+func (a *APIKeys) Verify(key string) (*Principal, error) {
+	return a.VerifyFrom("", key)
+}
+
+// VerifyFrom is Verify for a key presented by source, typically the
+// client's IP address, which is recorded in the audit event and Principal
+func (a *APIKeys) VerifyFrom(source, key string) (*Principal, error) {
+	p, k, err := a.verify(key)
+	if p != nil {
+		p.Source = source
+	}
+	id := ""
+	if k != nil {
+		id = k.Prefix
+	}
+	typ := EventLoginSuccess
+	if err != nil {
+		typ = EventLoginFailure
+	}
+	username := ""
+	if p != nil {
+		username = p.Username
+	}
+	result, detail := outcome(err)
+	if id != "" {
+		detail = strings.TrimSpace("key " + id + " " + detail)
+	}
+	recordEvent(a.auditor, Event{Type: typ, User: username, Source: source, Method: "apikey", Outcome: result, Detail: detail})
+	return p, err
+}
+
+// verify does the work of Verify; it returns the stored key once the
+// prefix is known, for auditing
+func (a *APIKeys) verify(key string) (*Principal, *APIKey, error) {
+	id, secret, ok := parseAPIKey(key)
+	if !ok {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	k, err := a.store.GetAPIKey(id)
+	if errors.Is(err, ErrAPIKeyUnknown) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(k.Hash)) != 1 {
+		return nil, &k, ErrInvalidAPIKey
+	}
+	now := a.now().UTC()
+	if k.Revoked() {
+		return nil, &k, ErrAPIKeyRevoked
+	}
+	if !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt) {
+		return nil, &k, ErrAPIKeyExpired
+	}
+	u, err := a.users.GetByID(k.UserID)
+	if err != nil {
+		// The user is gone; their keys go with them
+		return nil, &k, fmt.Errorf("%w: owner: %v", ErrInvalidAPIKey, err)
+	}
+	if a.auth != nil {
+		if _, err := a.auth.accountCredential(u.Email); err != nil {
+			return nil, &k, err
+		}
+	}
+
+	if now.Sub(k.LastUsedAt) >= lastUsedResolution {
+		// Only the timestamp is written: putting back the whole key read
+		// above could clear a RevokedAt set since
+		if err := a.store.TouchAPIKey(k.ID, now); err != nil {
+			return nil, &k, err
+		}
+		k.LastUsedAt = now
+	}
+	return &Principal{
+		Username: u.Email,
+		UserID:   u.ID,
+		Method:   "apikey",
+		AuthTime: now,
+		Roles:    u.Roles,
+		Scopes:   k.Scopes,
+	}, &k, nil
+}
+
+// GetSession resolves a key into a per-request Session
+// It makes APIKeys a SessionResolver, so handlers can accept keys wherever
+// they accept session tokens. The session is not stored anywhere.
+func (a *APIKeys) GetSession(key string) (*Session, error) {
+	return a.GetSessionFrom("", key)
+}
+
+// GetSessionFrom is GetSession for a key presented by source
+func (a *APIKeys) GetSessionFrom(source, key string) (*Session, error) {
+	p, err := a.VerifyFrom(source, key)
+	if err != nil {
+		return nil, err
+	}
+	id, _, _ := parseAPIKey(key)
+	return &Session{
+		ID:        apiKeyTag + id,
+		Username:  p.Username,
+		UserID:    p.UserID,
+		Method:    p.Method,
+		Source:    p.Source,
+		Roles:     p.Roles,
+		Scopes:    p.Scopes,
+		CreatedAt: p.AuthTime,
+		LastSeen:  p.AuthTime,
+	}, nil
+}
+
+// Revoke stops the key with the given ID or prefix from working
+// Revoking a key twice is not an error.
+func (a *APIKeys) Revoke(id string) error {
+	k, err := a.store.GetAPIKey(strings.TrimPrefix(id, apiKeyTag))
+	if err != nil {
+		return err
+	}
+	if k.Revoked() {
+		return nil
+	}
+	k.RevokedAt = a.now().UTC()
+	return a.store.PutAPIKey(k)
+}
+
+// List returns the keys of a user, oldest first, including revoked ones
+func (a *APIKeys) List(userID string) ([]APIKey, error) {
+	return a.store.ListAPIKeys(userID)
+}
+
+// parseAPIKey splits a key into its ID and secret
+func parseAPIKey(key string) (id, secret string, ok bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyTag)
+	if !ok {
+		return "", "", false
+	}
+	// The secret is base64url and may itself contain '_', so split at the
+	// first underscore after the fixed-length hex ID
+	id, secret, ok = strings.Cut(rest, "_")
+	if !ok || len(id) != 16 || secret == "" {
+		return "", "", false
+	}
+	return id, secret, true
+}
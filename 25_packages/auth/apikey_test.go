@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rbrishi/Golang/user"
+)
+
+func newTestAPIKeys(t *testing.T, store APIKeyStore) (*APIKeys, *user.User, *fakeClock) {
+	t.Helper()
+	users := user.NewMemoryRepository()
+	u, err := user.New("Ann", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Create(u); err != nil {
+		t.Fatal(err)
+	}
+	a := NewAuthenticator(NewMemoryStore())
+	a.SetHasher(fastArgon2)
+	if err := a.SetPassword(u.Email, u.ID, "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	keys := NewAPIKeys(store, a, users)
+	keys.now = clock.now
+	return keys, u, clock
+}
+
+func TestAPIKeyVerify(t *testing.T) {
+	keys, u, clock := newTestAPIKeys(t, NewMemoryAPIKeyStore())
+	key, k, err := keys.Issue(u, "ci", []user.Permission{"users:read"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := keys.Verify(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.UserID != u.ID || p.Method != "apikey" || len(p.Scopes) != 1 {
+		t.Errorf("got principal %+v", p)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr error
+	}{
+		{"malformed", "not a key", ErrInvalidAPIKey},
+		{"unknown prefix", "gk_0000000000000000_secret", ErrInvalidAPIKey},
+		{"wrong secret", k.Prefix + "_wrong", ErrInvalidAPIKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := keys.Verify(tt.key); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	clock.advance(time.Hour)
+	if _, err := keys.Verify(key); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Errorf("after expiry: got %v, want ErrAPIKeyExpired", err)
+	}
+}
+
+// revokingStore revokes a key right after the first lookup of it, as a
+// concurrent Revoke would
+type revokingStore struct {
+	*MemoryAPIKeyStore
+	revoked bool
+	revoke  func(id string)
+}
+
+func (s *revokingStore) GetAPIKey(id string) (APIKey, error) {
+	k, err := s.MemoryAPIKeyStore.GetAPIKey(id)
+	if !s.revoked && s.revoke != nil {
+		s.revoked = true
+		s.revoke(id) // Looks the key up again, now without revoking
+	}
+	return k, err
+}
+
+func TestVerifyCannotUndoRevoke(t *testing.T) {
+	store := &revokingStore{MemoryAPIKeyStore: NewMemoryAPIKeyStore()}
+	keys, u, _ := newTestAPIKeys(t, store)
+	key, k, err := keys.Issue(u, "ci", []user.Permission{"users:read"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.revoke = func(id string) {
+		if err := keys.Revoke(id); err != nil {
+			t.Error(err)
+		}
+	}
+	keys.Verify(key) // Races the revocation and may still succeed
+	got, err := store.MemoryAPIKeyStore.GetAPIKey(k.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Revoked() || got.LastUsedAt.IsZero() {
+		t.Fatalf("got %+v, want the key revoked and its use recorded", got)
+	}
+	if _, err := keys.Verify(key); !errors.Is(err, ErrAPIKeyRevoked) {
+		t.Fatalf("got %v, want ErrAPIKeyRevoked", err)
+	}
+}
+
+func TestAPIKeyOfDisabledAccount(t *testing.T) {
+	keys, u, _ := newTestAPIKeys(t, NewMemoryAPIKeyStore())
+	key, _, err := keys.Issue(u, "ci", []user.Permission{"users:read"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := keys.auth.store.(*MemoryStore)
+	cred, err := store.GetCredential(u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred.Disabled = true
+	store.PutCredential(cred)
+	if _, err := keys.Verify(key); !errors.Is(err, ErrAccountDisabled) {
+		t.Fatalf("got %v, want ErrAccountDisabled", err)
+	}
+
+	cred.Disabled = false
+	store.PutCredential(cred)
+	if _, err := keys.Verify(key); err != nil {
+		t.Fatalf("after enabling again: %v", err)
+	}
+}
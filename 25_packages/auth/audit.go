@@ -0,0 +1,383 @@
+// Package auth (audit.go)
+// This file keeps an append-only audit trail of authentication events as
+// JSON lines. Every event carries an HMAC-SHA256 of its contents and of the
+// event before it, so editing, deleting or reordering lines breaks the chain
+// and is detected by VerifyAuditLog. The HMAC key must be kept away from
+// the log: whoever holds it can rewrite the chain. Cutting events off the
+// end of the log leaves a valid chain, so the last Seq should also be
+// watched from elsewhere, for example by shipping the log off the host.
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rbrishi/Golang/internal/atomicfile"
+)
+
+// EventType names what happened
+type EventType string
+
+// Audited events
+const (
+	EventLoginSuccess   EventType = "login.success"
+	EventLoginFailure   EventType = "login.failure"
+	EventLockout        EventType = "login.lockout"
+	EventMFASuccess     EventType = "mfa.success"
+	EventMFAFailure     EventType = "mfa.failure"
+	EventSessionCreated EventType = "session.created"
+	EventSessionRevoked EventType = "session.revoked"
+	EventPasswordReset  EventType = "password.reset"
+)
+
+// Outcomes recorded with an event
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event is one line of the audit log
+// Passwords, codes and tokens are never recorded; Detail holds at most the
+// text of the error that caused a failure.
+type Event struct {
+	Seq      int64     `json:"seq"`  // Position in the log, starting at 1
+	Time     time.Time `json:"time"` // UTC
+	Type     EventType `json:"type"`
+	User     string    `json:"user,omitempty"`
+	Source   string    `json:"source,omitempty"` // Client address, if known
+	Method   string    `json:"method,omitempty"` // How the user authenticated, as in Principal.Method
+	Outcome  string    `json:"outcome"`
+	Detail   string    `json:"detail,omitempty"`
+	PrevHash string    `json:"prev_hash"` // Hash of the previous event, "" for the first
+	Hash     string    `json:"hash"`      // Hash of this event, see hash
+}
+
+// hash returns the HMAC-SHA256 under key of the event's JSON encoding with
+// Hash empty
+// PrevHash is part of that encoding, which is what chains events together.
+func (e Event) hash(key []byte) (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// checkAuditKey rejects keys too short to keep the chain from being forged
+func checkAuditKey(key []byte) error {
+	if len(key) < MinHMACSecretLen {
+		return fmt.Errorf("%w: audit key is %d bytes, want at least %d", ErrInvalidKey, len(key), MinHMACSecretLen)
+	}
+	return nil
+}
+
+// Auditor is implemented by anything that records audit events
+// Authenticator, SessionManager and Recovery report to an Auditor when one
+// is set with their SetAuditor methods.
+type Auditor interface {
+	Record(e Event) error
+}
+
+// AuditLog is an Auditor that appends events to a file
+type AuditLog struct {
+	mu       sync.Mutex // Protects everything below
+	f        *os.File
+	lock     *atomicfile.FileLock // Only one writer may extend the chain
+	key      []byte               // HMAC key of the chain
+	seq      int64                // Seq of the last event written
+	lastHash string               // Hash of the last event written
+	size     int64                // Length of the file up to the last event written
+	torn     bool                 // A failed Record left bytes after size that are still there
+	now      func() time.Time
+	write    func(b []byte) (int, error) // f.Write; replaced in tests
+}
+
+// OpenAuditLog opens or creates the audit log at path, chained with key
+// key must be at least MinHMACSecretLen bytes. An existing log is verified
+// first, and new events continue its chain; a tampered log is refused with
+// a *ChainError. A final line without its newline was cut short by a crash
+// during Record, which had not reported success, so it is removed.
+// The log stays locked until Close: a second writer would fork the chain.
+func OpenAuditLog(path string, key []byte) (*AuditLog, error) {
+	if err := checkAuditKey(key); err != nil {
+		return nil, err
+	}
+	lock, err := atomicfile.Lock(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	l, err := openAuditLog(path, key)
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	l.lock = lock
+	return l, nil
+}
+
+// openAuditLog does the work of OpenAuditLog once the lock is held
+func openAuditLog(path string, key []byte) (*AuditLog, error) {
+	l := &AuditLog{key: bytes.Clone(key), now: time.Now}
+	if f, err := os.OpenFile(path, os.O_RDWR, 0); err == nil {
+		var last *Event
+		size, err := dropTornLine(f)
+		if err == nil {
+			last, err = verifyChain(io.NewSectionReader(f, 0, size), l.key, nil)
+		}
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			l.seq, l.lastHash = last.Seq, last.Hash
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// O_APPEND makes every write land at the end, even if something else
+	// has the file open
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.f, l.size, l.write = f, info.Size(), f.Write
+	return l, nil
+}
+
+// dropTornLine truncates f after its last newline and returns the new size
+func dropTornLine(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	buf := make([]byte, 4096)
+	keep := int64(0) // End of the last complete line
+	for end := size; end > 0; {
+		start := max(end-int64(len(buf)), 0)
+		block := buf[:end-start]
+		if _, err := f.ReadAt(block, start); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(block, '\n'); i >= 0 {
+			keep = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+	if keep == size {
+		return size, nil
+	}
+	if err := f.Truncate(keep); err != nil {
+		return 0, err
+	}
+	return keep, f.Sync()
+}
+
+// Record fills in the sequence number, time and hashes of e and appends it
+// The line is synced to disk before Record returns. If that fails, the file
+// is cut back to the previous event, so a partly written line cannot end
+// up in the middle of the log when later events follow it.
+func (l *AuditLog) Record(e Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.torn {
+		if err := l.truncate(); err != nil {
+			return err
+		}
+	}
+	e.Seq = l.seq + 1
+	e.Time = l.now().UTC()
+	e.PrevHash = l.lastHash
+	h, err := e.hash(l.key)
+	if err != nil {
+		return err
+	}
+	e.Hash = h
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	_, err = l.write(line)
+	if err == nil {
+		err = l.f.Sync()
+	}
+	if err != nil {
+		l.torn = true
+		return errors.Join(err, l.truncate())
+	}
+	l.seq, l.lastHash = e.Seq, e.Hash
+	l.size += int64(len(line))
+	return nil
+}
+
+// truncate removes whatever a failed Record wrote after the last event
+// The caller must hold l.mu.
+func (l *AuditLog) truncate() error {
+	if err := l.f.Truncate(l.size); err != nil {
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	l.torn = false
+	return nil
+}
+
+// Close closes the log file and releases its lock
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.f.Close()
+	if uerr := l.lock.Unlock(); err == nil {
+		err = uerr
+	}
+	return err
+}
+
+// ChainError reports where an audit log stops verifying
+type ChainError struct {
+	Line   int    // 1-based line number
+	Reason string // What is wrong with the line
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("auth: audit log broken at line %d: %s", e.Line, e.Reason)
+}
+
+// AuditFilter selects events when reading a log
+// Zero fields match everything.
+type AuditFilter struct {
+	Type    EventType
+	User    string
+	Outcome string
+	Since   time.Time // Inclusive
+	Until   time.Time // Exclusive
+}
+
+// Match reports whether e passes the filter
+func (f AuditFilter) Match(e Event) bool {
+	return (f.Type == "" || e.Type == f.Type) &&
+		(f.User == "" || e.User == f.User) &&
+		(f.Outcome == "" || e.Outcome == f.Outcome) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// VerifyAuditLog checks the whole hash chain of the log read from r against
+// the key it was written with
+func VerifyAuditLog(r io.Reader, key []byte) error {
+	if err := checkAuditKey(key); err != nil {
+		return err
+	}
+	_, err := verifyChain(r, key, nil)
+	return err
+}
+
+// ReadAuditLog verifies the log at path with key and returns the events
+// matching filter
+// Nothing is returned unless the entire chain verifies.
+func ReadAuditLog(path string, key []byte, filter AuditFilter) ([]Event, error) {
+	if err := checkAuditKey(key); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var events []Event
+	_, err = verifyChain(f, key, func(e Event) {
+		if filter.Match(e) {
+			events = append(events, e)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// verifyChain reads events from r, checking sequence numbers and hashes
+// under key, calls visit for each one and returns the last
+func verifyChain(r io.Reader, key []byte, visit func(Event)) (*Event, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	var last *Event
+	line := 0
+	for sc.Scan() {
+		line++
+		var e Event
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, &ChainError{Line: line, Reason: "not a JSON event"}
+		}
+		wantPrev, wantSeq := "", int64(1)
+		if last != nil {
+			wantPrev, wantSeq = last.Hash, last.Seq+1
+		}
+		if e.Seq != wantSeq {
+			return nil, &ChainError{Line: line, Reason: fmt.Sprintf("sequence %d, want %d", e.Seq, wantSeq)}
+		}
+		if e.PrevHash != wantPrev {
+			return nil, &ChainError{Line: line, Reason: "previous hash does not match"}
+		}
+		h, err := e.hash(key)
+		if err != nil {
+			return nil, err
+		}
+		if !hmac.Equal([]byte(h), []byte(e.Hash)) {
+			return nil, &ChainError{Line: line, Reason: "event hash does not match contents"}
+		}
+		if visit != nil {
+			visit(e)
+		}
+		last = &e
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return last, nil
+}
+
+// recordEvent sends e to auditor if there is one
+// Auditing must not turn a good login into a failed one, so errors from the
+// auditor do not fail the caller; they are logged, so a log that has stopped
+// growing does not go unnoticed.
+func recordEvent(auditor Auditor, e Event) {
+	if auditor == nil {
+		return
+	}
+	if err := auditor.Record(e); err != nil {
+		slog.Error("auth: cannot record audit event", "type", e.Type, "user", e.User, "err", err)
+	}
+}
+
+// outcome maps an error to OutcomeSuccess or OutcomeFailure and a detail
+func outcome(err error) (string, string) {
+	if err == nil {
+		return OutcomeSuccess, ""
+	}
+	return OutcomeFailure, err.Error()
+}
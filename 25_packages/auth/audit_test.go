@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rbrishi/Golang/internal/atomicfile"
+	"github.com/rbrishi/Golang/user"
+)
+
+var testAuditKey = []byte("audit-key-for-tests-only-32bytes")
+
+// writeTestAudit records n login events in a new log and returns its path
+func writeTestAudit(t *testing.T, n int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := OpenAuditLog(path, testAuditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range n {
+		if err := l.Record(Event{Type: EventLoginSuccess, User: "alice", Source: "10.0.0.1", Outcome: OutcomeSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAuditChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(data []byte) []byte
+		key     []byte
+		wantErr bool
+	}{
+		{"untouched", func(b []byte) []byte { return b }, testAuditKey, false},
+		{"wrong key", func(b []byte) []byte { return b }, []byte("some-other-key-that-is-32-bytes!"), true},
+		{"edited user", func(b []byte) []byte { return bytes.Replace(b, []byte(`"alice"`), []byte(`"mallory"`), 1) }, testAuditKey, true},
+		{"line deleted", func(b []byte) []byte {
+			lines := bytes.SplitAfter(b, []byte("\n"))
+			return bytes.Join(append(lines[:1:1], lines[2:]...), nil)
+		}, testAuditKey, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(writeTestAudit(t, 3))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = VerifyAuditLog(bytes.NewReader(tt.edit(data)), tt.key)
+			var chainErr *ChainError
+			if tt.wantErr != errors.As(err, &chainErr) {
+				t.Fatalf("got %v, want a *ChainError: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuditLogRecoversTornLine(t *testing.T) {
+	path := writeTestAudit(t, 2)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":3,"time":"2024-01`) // A crash in the middle of Record
+	f.Close()
+
+	l, err := OpenAuditLog(path, testAuditKey)
+	if err != nil {
+		t.Fatalf("reopening after a torn write: %v", err)
+	}
+	if err := l.Record(Event{Type: EventLoginFailure, User: "bob", Outcome: OutcomeFailure}); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	events, err := ReadAuditLog(path, testAuditKey, AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[2].Seq != 3 || events[2].User != "bob" {
+		t.Fatalf("got %+v", events)
+	}
+}
+
+func TestAuditLogUndoesFailedRecord(t *testing.T) {
+	path := writeTestAudit(t, 1)
+	l, err := OpenAuditLog(path, testAuditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	write := l.write
+	l.write = func(b []byte) (int, error) { // The disk fills up halfway through the line
+		n, _ := write(b[:len(b)/2])
+		return n, errors.New("no space left on device")
+	}
+	if err := l.Record(Event{Type: EventLoginFailure, User: "bob", Outcome: OutcomeFailure}); err == nil {
+		t.Fatal("Record reported success")
+	}
+	l.write = write
+	if err := l.Record(Event{Type: EventLoginSuccess, User: "carol", Outcome: OutcomeSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	events, err := ReadAuditLog(path, testAuditKey, AuditFilter{})
+	if err != nil {
+		t.Fatalf("log no longer verifies: %v", err)
+	}
+	if len(events) != 2 || events[1].Seq != 2 || events[1].User != "carol" {
+		t.Fatalf("got %+v", events)
+	}
+}
+
+func TestAuditLogRejectsShortKey(t *testing.T) {
+	if _, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"), []byte("short")); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("got %v, want ErrInvalidKey", err)
+	}
+}
+
+func TestAuditLogHasOneWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := OpenAuditLog(path, testAuditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenAuditLog(path, testAuditKey); !errors.Is(err, atomicfile.ErrLocked) {
+		t.Fatalf("second writer: got %v, want ErrLocked", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l, err = OpenAuditLog(path, testAuditKey)
+	if err != nil {
+		t.Fatalf("after Close: %v", err)
+	}
+	l.Close()
+}
+
+func TestSessionEventsCarrySource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := OpenAuditLog(path, testAuditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	a := newTestAuthenticator(t)
+	m, _ := newTestSessions()
+	m.SetAuditor(l)
+	p, err := a.LogInWithCredFrom("10.0.0.1", "alice", "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := m.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RevokeFrom("10.0.0.2", s.Token); err != nil {
+		t.Fatal(err)
+	}
+	events, err := ReadAuditLog(path, testAuditKey, AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Source != "10.0.0.1" || events[1].Source != "10.0.0.2" || events[0].Method != "password" {
+		t.Fatalf("got %+v", events)
+	}
+}
+
+func TestAPIKeyEventsKeepSourceAndMethodApart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := OpenAuditLog(path, testAuditKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	keys, u, _ := newTestAPIKeys(t, NewMemoryAPIKeyStore())
+	keys.SetAuditor(l)
+	key, _, err := keys.Issue(u, "ci", []user.Permission{"users:read"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err := keys.GetSessionFrom("10.0.0.3", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := ReadAuditLog(path, testAuditKey, AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Source != "10.0.0.3" || events[0].Method != "apikey" || sess.Source != "10.0.0.3" {
+		t.Fatalf("got %+v and session %+v", events, sess)
+	}
+}
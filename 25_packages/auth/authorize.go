@@ -0,0 +1,43 @@
+// Package auth (authorize.go)
+// Authentication answers "who are you?"; this file answers "may you do
+// this?" by checking the roles recorded in a Session against role
+// definitions from the user package.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/rbrishi/Golang/user"
+)
+
+// Errors returned by Authorize
+var (
+	ErrNotAuthenticated = errors.New("auth: not authenticated")
+	ErrForbidden        = errors.New("auth: permission denied")
+)
+
+// Authorize returns nil if the session's roles grant permission under
+// user.DefaultRoles
+// The roles were copied into the session at login, so role changes take
+// effect on the user's next login.
+func Authorize(s *Session, permission user.Permission) error {
+	return AuthorizeWith(user.DefaultRoles, s, permission)
+}
+
+// AuthorizeWith is Authorize with an explicit set of role definitions
+// A session with Scopes, such as one from an API key, additionally needs
+// permission among its scopes: a key never grants more than its user's roles.
+func AuthorizeWith(roles *user.Roles, s *Session, permission user.Permission) error {
+	if s == nil {
+		return ErrNotAuthenticated
+	}
+	if !roles.Allows(s.Roles, permission) {
+		return fmt.Errorf("%w: %s lacks %q", ErrForbidden, s.Username, permission)
+	}
+	if s.Scopes != nil && !slices.Contains(s.Scopes, permission) && !slices.Contains(s.Scopes, user.AllPermissions) {
+		return fmt.Errorf("%w: %q is outside the key's scopes", ErrForbidden, permission)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rbrishi/Golang/user"
+)
+
+func TestAuthorizeWith(t *testing.T) {
+	roles := user.NewRoles()
+	for _, role := range []user.Role{
+		{Name: "viewer", Permissions: []user.Permission{"users:read"}},
+		{Name: "admin", Inherits: []string{"viewer"}, Permissions: []user.Permission{"users:write"}},
+		{Name: "root", Permissions: []user.Permission{user.AllPermissions}},
+	} {
+		if err := roles.Define(role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		session *Session
+		perm    user.Permission
+		wantErr error
+	}{
+		{"no session", nil, "users:read", ErrNotAuthenticated},
+		{"granted", &Session{Roles: []string{"viewer"}}, "users:read", nil},
+		{"inherited", &Session{Roles: []string{"admin"}}, "users:read", nil},
+		{"missing", &Session{Roles: []string{"viewer"}}, "users:write", ErrForbidden},
+		{"no roles", &Session{}, "users:read", ErrForbidden},
+		{"wildcard role", &Session{Roles: []string{"root"}}, "billing:refund", nil},
+		{"within scopes", &Session{Roles: []string{"admin"}, Scopes: []user.Permission{"users:read"}}, "users:read", nil},
+		{"scope narrows role", &Session{Roles: []string{"admin"}, Scopes: []user.Permission{"users:read"}}, "users:write", ErrForbidden},
+		{"empty scopes grant nothing", &Session{Roles: []string{"admin"}, Scopes: []user.Permission{}}, "users:read", ErrForbidden},
+		{"wildcard scope", &Session{Roles: []string{"admin"}, Scopes: []user.Permission{user.AllPermissions}}, "users:write", nil},
+		{"scope cannot widen role", &Session{Roles: []string{"viewer"}, Scopes: []user.Permission{"users:write"}}, "users:write", ErrForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := AuthorizeWith(roles, tt.session, tt.perm); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,21 +2,384 @@
 // It demonstrates Go's package system and visibility rules
 package auth
 
-import "fmt"
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rbrishi/Golang/user"
+)
+
+// Errors returned by LogInWithCred
+// Callers should compare with errors.Is, since the returned error is
+// wrapped in a *LoginError that records which username was tried.
+// Throttled attempts additionally carry a *LockoutError (see lockout.go).
+var (
+	// ErrInvalidCredentials means the username is unknown or the password
+	// is wrong. The two cases are deliberately not distinguished so that a
+	// caller cannot probe which usernames exist.
+	ErrInvalidCredentials = errors.New("auth: invalid username or password")
+	// ErrAccountDisabled means the password was correct but the
+	// credential has been disabled.
+	ErrAccountDisabled = errors.New("auth: account disabled")
+)
+
+// LoginError describes a failed login attempt
+type LoginError struct {
+	Username string // Username that was tried
+	Err      error  // One of the Err* values above, or a store error
+}
+
+func (e *LoginError) Error() string {
+	return e.Err.Error() + " (user " + e.Username + ")"
+}
+
+// Unwrap lets errors.Is and errors.As see the underlying error
+func (e *LoginError) Unwrap() error { return e.Err }
+
+// Principal is the identity established by a successful login
+type Principal struct {
+	Username string    // Normalized login name
+	UserID   string    // Linked user.User ID, if the credential has one
+	Method   string    // How the principal authenticated, e.g. "password"
+	Source   string    // Client address the login came from, if known
+	AuthTime time.Time // When authentication succeeded
+	Roles    []string  // The linked user's roles at login time
+	// Scopes, when non-nil, narrows what Roles grant; see AuthorizeWith.
+	// Only API keys set it.
+	Scopes []user.Permission
+
+	// MFARequired is set when the password was right but the user has a
+	// second factor that VerifySecondFactor must check before the
+	// principal is trusted with a session.
+	MFARequired bool
+	MFA         bool // A second factor was verified
+}
+
+// Authenticator verifies credentials against a CredentialStore
+type Authenticator struct {
+	store  CredentialStore
+	hasher Hasher
+	totp   TOTP
+	now    func() time.Time
+
+	limitMu sync.RWMutex // Protects users and sources
+	users   *Limiter     // Failed attempts per username
+	sources *Limiter     // Failed attempts per source address
+
+	accounts user.Repository // Optional; supplies Principal.Roles
+	auditor  Auditor         // Optional; receives login events
+
+	// credMu serializes read-modify-write updates of a credential, so two
+	// requests cannot both spend the same recovery code or TOTP step
+	credMu sync.Mutex
+
+	dummyOnce sync.Once
+	dummyHash string // Hash compared against when the user does not exist
+}
+
+// NewAuthenticator returns an Authenticator that reads credentials from
+// store, hashes new passwords with Argon2id and limits failed attempts with
+// DefaultUserPolicy and DefaultSourcePolicy
+func NewAuthenticator(store CredentialStore) *Authenticator {
+	return &Authenticator{
+		store:   store,
+		hasher:  Argon2Hasher{},
+		totp:    DefaultTOTP,
+		now:     time.Now,
+		users:   NewLimiter("user", DefaultUserPolicy),
+		sources: NewLimiter("source", DefaultSourcePolicy),
+	}
+}
+
+// SetLockoutPolicies replaces the per-username and per-source limits
+// Existing failure counts are discarded.
+func (a *Authenticator) SetLockoutPolicies(user, source LockoutPolicy) {
+	a.limitMu.Lock()
+	defer a.limitMu.Unlock()
+	a.users = NewLimiter("user", user)
+	a.sources = NewLimiter("source", source)
+}
+
+// limiters returns the current per-username and per-source limiters
+func (a *Authenticator) limiters() (users, sources *Limiter) {
+	a.limitMu.RLock()
+	defer a.limitMu.RUnlock()
+	return a.users, a.sources
+}
+
+// Unlock clears the failure history of username, lifting any lockout
+func (a *Authenticator) Unlock(username string) {
+	users, _ := a.limiters()
+	users.Reset(normalizeUsername(username))
+}
+
+// UnlockSource clears the failure history of a source address
+func (a *Authenticator) UnlockSource(source string) {
+	_, sources := a.limiters()
+	sources.Reset(source)
+}
+
+// Locked reports whether username is currently locked out
+func (a *Authenticator) Locked(username string) bool {
+	users, _ := a.limiters()
+	return users.Locked(normalizeUsername(username))
+}
+
+// SetUserRepository makes logins look up the user linked to the
+// credential and copy its roles into the Principal
+func (a *Authenticator) SetUserRepository(r user.Repository) {
+	a.accounts = r
+}
+
+// SetAuditor makes the Authenticator report logins, lockouts and second
+// factor checks to auditor
+func (a *Authenticator) SetAuditor(auditor Auditor) {
+	a.auditor = auditor
+}
+
+// SetHasher changes the algorithm used for new passwords
+// Existing hashes keep verifying because every hash records its algorithm.
+func (a *Authenticator) SetHasher(h Hasher) {
+	a.hasher = h
+}
+
+// SetTOTP changes the one-time password parameters, for example to allow
+// more clock skew
+// A zero Period or Digits keeps codes working with the RFC 6238 defaults.
+func (a *Authenticator) SetTOTP(t TOTP) {
+	a.totp = t
+}
+
+// Store returns the CredentialStore the Authenticator reads from
+func (a *Authenticator) Store() CredentialStore {
+	return a.store
+}
+
+// SetPassword hashes password and stores it as the credential for username
+// Any second factor already enrolled for username is kept.
+// Parameters:
+//   - username: user's login name
+//   - userID: ID of the matching user.User, or "" if there is none
+//   - password: new plaintext password; it is never stored
+func (a *Authenticator) SetPassword(username, userID, password string) error {
+	hash, err := a.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	a.credMu.Lock()
+	defer a.credMu.Unlock()
+	cred, err := a.store.GetCredential(username)
+	if errors.Is(err, ErrCredentialNotFound) {
+		cred = Credential{Username: username}
+	} else if err != nil {
+		return err
+	}
+	cred.Hash = hash
+	if userID != "" {
+		cred.UserID = userID
+	}
+	return a.store.PutCredential(cred)
+}
+
+// LogInWithCred handles user authentication with username and password
+// It is LogInWithCredFrom with no source address.
+// Parameters:
+//   - username: user's login name
+//   - password: user's password
+func (a *Authenticator) LogInWithCred(username, password string) (*Principal, error) {
+	return a.LogInWithCredFrom("", username, password)
+}
+
+// LogInWithCredFrom authenticates username and password for a request
+// coming from source
+// On success it returns the authenticated Principal. On failure it returns
+// a *LoginError wrapping ErrInvalidCredentials, ErrAccountDisabled, a
+// *LockoutError or the store's own error.
+// Parameters:
+//   - source: client address used for per-source limits; "" skips them
+//   - username: user's login name
+//   - password: user's password
+func (a *Authenticator) LogInWithCredFrom(source, username, password string) (*Principal, error) {
+	username = normalizeUsername(username)
+	p, err := a.logIn(source, username, password)
+	typ := EventLoginSuccess
+	if err != nil {
+		typ = EventLoginFailure
+	}
+	result, detail := outcome(err)
+	recordEvent(a.auditor, Event{Type: typ, User: username, Source: source, Method: "password", Outcome: result, Detail: detail})
+	return p, err
+}
+
+// logIn does the work of LogInWithCredFrom for a normalized username
+func (a *Authenticator) logIn(source, username, password string) (*Principal, error) {
+	// Refuse throttled attempts before spending any time on hashing, and
+	// reserve this one so concurrent guesses cannot all get past the limit
+	users, sources := a.limiters()
+	if err := a.begin(users, sources, source, username); err != nil {
+		return nil, &LoginError{Username: username, Err: err}
+	}
+
+	cred, err := a.store.GetCredential(username)
+	if errors.Is(err, ErrCredentialNotFound) {
+		// Spend the same time hashing as a real check would, so response
+		// timing does not reveal whether the username exists
+		verifyPassword(a.dummy(), password, a.argon2Limits())
+		a.fail(users, sources, source, username)
+		return nil, &LoginError{Username: username, Err: ErrInvalidCredentials}
+	}
+	if err != nil {
+		a.release(users, sources, source, username)
+		return nil, &LoginError{Username: username, Err: err}
+	}
+
+	ok, err := verifyPassword(cred.Hash, password, a.argon2Limits())
+	if err != nil {
+		a.release(users, sources, source, username)
+		return nil, &LoginError{Username: username, Err: err}
+	}
+	if !ok {
+		a.fail(users, sources, source, username)
+		return nil, &LoginError{Username: username, Err: ErrInvalidCredentials}
+	}
+	// Only the username's history is cleared: a success must not let one
+	// source wipe the failures it racked up against other accounts. With a
+	// second factor the slate is only wiped by VerifySecondFactor, or
+	// someone holding the password could guess codes without limit.
+	if cred.TOTPConfirmed {
+		users.Release(username)
+	} else {
+		users.Reset(username)
+	}
+	if source != "" {
+		sources.Release(source)
+	}
+	if cred.Disabled {
+		return nil, &LoginError{Username: username, Err: ErrAccountDisabled}
+	}
+	p := &Principal{
+		Username: cred.Username,
+		UserID:   cred.UserID,
+		Method:   "password",
+		Source:   source,
+		AuthTime: a.now(),
+		// The session must wait for VerifySecondFactor
+		MFARequired: cred.TOTPConfirmed,
+	}
+	if err := a.loadRoles(p); err != nil {
+		return nil, &LoginError{Username: username, Err: err}
+	}
+	return p, nil
+}
+
+// loadRoles copies the roles of the user linked to p, if there is one
+func (a *Authenticator) loadRoles(p *Principal) error {
+	if a.accounts == nil || p.UserID == "" {
+		return nil
+	}
+	u, err := a.accounts.GetByID(p.UserID)
+	if errors.Is(err, user.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	p.Roles = u.Roles
+	return nil
+}
+
+// begin reserves an attempt by username from source with both limiters
+// source "" skips the per-source limit.
+func (a *Authenticator) begin(users, sources *Limiter, source, username string) error {
+	if err := users.Begin(username); err != nil {
+		return err
+	}
+	if source != "" {
+		if err := sources.Begin(source); err != nil {
+			users.Release(username)
+			return err
+		}
+	}
+	return nil
+}
+
+// release settles an attempt reserved by begin without counting it
+func (a *Authenticator) release(users, sources *Limiter, source, username string) {
+	users.Release(username)
+	if source != "" {
+		sources.Release(source)
+	}
+}
+
+// fail settles an attempt reserved by begin as a failure against username
+// and source, auditing any lockout it triggers
+func (a *Authenticator) fail(users, sources *Limiter, source, username string) {
+	users.Fail(username)
+	if users.Locked(username) {
+		recordEvent(a.auditor, Event{Type: EventLockout, User: username, Source: source,
+			Outcome: OutcomeFailure, Detail: "user locked out"})
+	}
+	if source != "" {
+		sources.Fail(source)
+		if sources.Locked(source) {
+			recordEvent(a.auditor, Event{Type: EventLockout, User: username, Source: source,
+				Outcome: OutcomeFailure, Detail: "source locked out"})
+		}
+	}
+}
+
+// accountCredential returns username's credential, or nil if there is none,
+// for logins that do not check the password but must still respect the
+// account, such as OAuth and API keys
+// A disabled account is an error.
+func (a *Authenticator) accountCredential(username string) (*Credential, error) {
+	cred, err := a.store.GetCredential(username)
+	if errors.Is(err, ErrCredentialNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cred.Disabled {
+		return nil, &LoginError{Username: username, Err: ErrAccountDisabled}
+	}
+	return &cred, nil
+}
+
+// argon2Limits bounds the Argon2 parameters a stored hash may ask for,
+// based on the hasher new passwords use
+func (a *Authenticator) argon2Limits() Argon2Hasher {
+	h, _ := a.hasher.(Argon2Hasher)
+	return h.limits()
+}
+
+// dummy lazily computes a hash that no real password is checked against
+func (a *Authenticator) dummy() string {
+	a.dummyOnce.Do(func() {
+		a.dummyHash, _ = a.hasher.Hash("not a real password")
+	})
+	return a.dummyHash
+}
+
+// Default is the Authenticator used by the package-level LogInWithCred
+// It starts with an empty in-memory store; programs that persist
+// credentials can replace it with NewAuthenticator(fileStore).
+var Default = NewAuthenticator(NewMemoryStore())
 
 // LogInWithCred is an exported function (starts with capital letter)
-// It handles user authentication with username and password
+// It authenticates against the Default Authenticator.
 // Parameters:
 //   - username: user's login name
 //   - password: user's password
-func LogInWithCred(username, password string)  {
-	forPrivate() // Calling an unexported function within the same package
-	fmt.Println("Logging in with credentials:", username, password)
+func LogInWithCred(username, password string) (*Principal, error) {
+	return Default.LogInWithCred(username, password)
 }
 
-// forPrivate is an unexported function (starts with lowercase letter)
+// normalizeUsername is an unexported function (starts with lowercase letter)
 // It can only be used within the auth package
-// This demonstrates Go's visibility rules for package members
-func forPrivate() string {
-	return ""
-}
\ No newline at end of file
+// Usernames are compared case-insensitively and without surrounding spaces.
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
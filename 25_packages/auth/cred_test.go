@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+// newTestAuthenticator returns an Authenticator over a memory store with a
+// fast hasher and one user, alice / "s3cret"
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	a := NewAuthenticator(NewMemoryStore())
+	a.SetHasher(fastArgon2)
+	if err := a.SetPassword("alice", "u1", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestLogInWithCred(t *testing.T) {
+	a := newTestAuthenticator(t)
+	tests := []struct {
+		name, username, password string
+		wantErr                  error
+	}{
+		{"right password", "alice", "s3cret", nil},
+		{"username is normalized", "  Alice ", "s3cret", nil},
+		{"wrong password", "alice", "nope", ErrInvalidCredentials},
+		{"unknown user", "bob", "s3cret", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := a.LogInWithCred(tt.username, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (p.Username != "alice" || p.UserID != "u1" || p.MFARequired) {
+				t.Errorf("got principal %+v", p)
+			}
+		})
+	}
+}
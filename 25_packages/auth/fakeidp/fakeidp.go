@@ -0,0 +1,217 @@
+// Package fakeidp is an in-process OAuth 2.0 / OpenID Connect identity
+// provider for exercising auth.OAuthProvider without network access
+// It runs on an httptest.Server, approves every authorization request for
+// the user last passed to SignIn, and enforces the parts of the protocol a
+// client can get wrong: client authentication, redirect URI matching,
+// single-use codes and the PKCE S256 check.
+package fakeidp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/rbrishi/Golang/auth"
+)
+
+// grant is an issued authorization code
+type grant struct {
+	identity    auth.Identity
+	redirectURI string
+	challenge   string
+}
+
+// Provider is a fake identity provider
+type Provider struct {
+	server *httptest.Server
+
+	ClientID     string
+	ClientSecret string
+
+	mu      sync.Mutex               // Protects the fields below
+	current *auth.Identity           // User "signed in" at the provider
+	codes   map[string]grant         // Outstanding authorization codes
+	tokens  map[string]auth.Identity // Issued access tokens
+}
+
+// New starts a provider that accepts the given client credentials
+// Call Close when done.
+func New(clientID, clientSecret string) *Provider {
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		codes:        make(map[string]grant),
+		tokens:       make(map[string]auth.Identity),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /authorize", p.handleAuthorize)
+	mux.HandleFunc("POST /token", p.handleToken)
+	mux.HandleFunc("GET /userinfo", p.handleUserInfo)
+	p.server = httptest.NewServer(mux)
+	return p
+}
+
+// Close shuts the provider down
+func (p *Provider) Close() {
+	p.server.Close()
+}
+
+// URL returns the provider's base URL
+func (p *Provider) URL() string {
+	return p.server.URL
+}
+
+// Config returns an auth.OAuthConfig pointing at this provider
+func (p *Provider) Config(redirectURL string) auth.OAuthConfig {
+	return auth.OAuthConfig{
+		Name:         "fake",
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		AuthURL:      p.server.URL + "/authorize",
+		TokenURL:     p.server.URL + "/token",
+		UserInfoURL:  p.server.URL + "/userinfo",
+		RedirectURL:  redirectURL,
+	}
+}
+
+// SignIn makes id the user who approves the next authorization requests
+func (p *Provider) SignIn(id auth.Identity) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current = &id
+}
+
+// Authorize plays the browser: it follows authURL to the provider and
+// returns the callback URL the provider redirects back to, carrying the
+// code and state
+func (p *Provider) Authorize(authURL string) (*url.URL, error) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return nil, errors.New("fakeidp: authorization refused: " + resp.Status)
+	}
+	return url.Parse(resp.Header.Get("Location"))
+}
+
+// handleAuthorize issues a code for the signed-in user and redirects back
+func (p *Provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != p.ClientID {
+		http.Error(w, "unauthorized_client", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid_request: PKCE S256 required", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "invalid_request: redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	if p.current == nil {
+		p.mu.Unlock()
+		http.Error(w, "access_denied: nobody signed in", http.StatusForbidden)
+		return
+	}
+	code := randomString()
+	p.codes[code] = grant{identity: *p.current, redirectURI: q.Get("redirect_uri"), challenge: q.Get("code_challenge")}
+	p.mu.Unlock()
+
+	cb := redirect.Query()
+	cb.Set("code", code)
+	cb.Set("state", q.Get("state"))
+	redirect.RawQuery = cb.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// handleToken redeems an authorization code
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if id != p.ClientID || subtle.ConstantTimeCompare([]byte(secret), []byte(p.ClientSecret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	p.mu.Lock()
+	code := r.PostFormValue("code")
+	g, ok := p.codes[code]
+	delete(p.codes, code) // Codes are single-use, even when redemption fails
+	p.mu.Unlock()
+	if !ok || g.redirectURI != r.PostFormValue("redirect_uri") {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	token := randomString()
+	p.mu.Lock()
+	p.tokens[token] = g.identity
+	p.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+}
+
+// handleUserInfo returns the identity behind a bearer token
+func (p *Provider) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	p.mu.Lock()
+	id, known := p.tokens[token]
+	p.mu.Unlock()
+	if !ok || !known {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid_token", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, id)
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// randomString returns an unguessable code or token
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
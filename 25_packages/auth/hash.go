@@ -0,0 +1,172 @@
+// Package auth (hash.go)
+// This file contains password hashing helpers used by the credential store.
+// Plaintext passwords never leave this file: everything else in the package
+// only ever sees the encoded hash strings produced here.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownHashFormat is returned when an encoded hash was not produced by
+// any of the hashers in this package.
+var ErrUnknownHashFormat = errors.New("auth: unknown password hash format")
+
+// Hasher turns a plaintext password into a salted, encoded hash string
+// The encoded string carries its own algorithm name, parameters and salt,
+// so a store can hold hashes produced by different hashers side by side.
+type Hasher interface {
+	Hash(password string) (string, error)
+}
+
+// BcryptHasher hashes passwords with bcrypt
+// bcrypt generates and embeds its own salt in the encoded hash.
+type BcryptHasher struct {
+	Cost int // Work factor; bcrypt.DefaultCost is used when zero
+}
+
+// Hash returns the bcrypt encoding of password
+func (h BcryptHasher) Hash(password string) (string, error) {
+	cost := h.Cost
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	b, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Argon2Hasher hashes passwords with Argon2id
+// The zero value uses the parameters recommended by RFC 9106 for
+// memory-constrained environments.
+type Argon2Hasher struct {
+	Time    uint32 // Number of passes over memory
+	Memory  uint32 // Memory in KiB
+	Threads uint8  // Degree of parallelism
+	KeyLen  uint32 // Length of the derived key in bytes
+	SaltLen int    // Length of the random salt in bytes
+}
+
+// withDefaults fills in any zero parameters
+func (h Argon2Hasher) withDefaults() Argon2Hasher {
+	if h.Time == 0 {
+		h.Time = 3
+	}
+	if h.Memory == 0 {
+		h.Memory = 64 * 1024
+	}
+	if h.Threads == 0 {
+		h.Threads = 2
+	}
+	if h.KeyLen == 0 {
+		h.KeyLen = 32
+	}
+	if h.SaltLen == 0 {
+		h.SaltLen = 16
+	}
+	return h
+}
+
+// Hash returns the Argon2id encoding of password in the PHC string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func (h Argon2Hasher) Hash(password string) (string, error) {
+	h = h.withDefaults()
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// limits returns the costliest parameters verifyArgon2 will run: four
+// times h's or the defaults', whichever are larger, so hashes made before
+// a moderate change of parameters keep verifying
+func (h Argon2Hasher) limits() Argon2Hasher {
+	h = h.withDefaults()
+	d := Argon2Hasher{}.withDefaults()
+	return Argon2Hasher{
+		Time:    uint32(min(4*uint64(max(h.Time, d.Time)), math.MaxUint32)),
+		Memory:  uint32(min(4*uint64(max(h.Memory, d.Memory)), math.MaxUint32)),
+		Threads: uint8(min(4*uint64(max(h.Threads, d.Threads)), math.MaxUint8)),
+	}
+}
+
+// verifyPassword reports whether password matches the encoded hash
+// The algorithm is chosen from the prefix of the encoded string. An Argon2
+// hash asking for more time, memory or threads than limits allows is
+// refused before any hashing, since the parameters come from the store.
+func verifyPassword(encoded, password string, limits Argon2Hasher) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return verifyArgon2(encoded, password, limits)
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	default:
+		return false, ErrUnknownHashFormat
+	}
+}
+
+// Shortest salt and key verifyArgon2 accepts; RFC 9106 asks for at least
+// 8 bytes of salt, and a key shorter than 16 bytes is too easy to collide
+const (
+	minArgon2SaltLen = 8
+	minArgon2KeyLen  = 16
+)
+
+// verifyArgon2 re-derives the key with the parameters stored in encoded
+// and compares it in constant time
+func verifyArgon2(encoded, password string, limits Argon2Hasher) (bool, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, ErrUnknownHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrUnknownHashFormat
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, ErrUnknownHashFormat
+	}
+	// argon2 panics on zero passes or threads, and an empty key would
+	// compare equal to the empty key derived for any password
+	if time < 1 || threads < 1 {
+		return false, ErrUnknownHashFormat
+	}
+	if time > limits.Time || memory > limits.Memory || threads > limits.Threads {
+		return false, fmt.Errorf("%w: m=%d,t=%d,p=%d is over the limit of m=%d,t=%d,p=%d",
+			ErrUnknownHashFormat, memory, time, threads, limits.Memory, limits.Time, limits.Threads)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) < minArgon2SaltLen {
+		return false, ErrUnknownHashFormat
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) < minArgon2KeyLen {
+		return false, ErrUnknownHashFormat
+	}
+	got := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// fastArgon2 keeps the tests quick; the parameters are not for production
+var fastArgon2 = Argon2Hasher{Time: 1, Memory: 64, Threads: 1}
+
+func TestHashRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher Hasher
+	}{
+		{"bcrypt", BcryptHasher{Cost: 4}},
+		{"argon2id", fastArgon2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.hasher.Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(encoded, "correct horse") {
+				t.Fatalf("hash %q contains the password", encoded)
+			}
+			if ok, err := verifyPassword(encoded, "correct horse", fastArgon2.limits()); !ok || err != nil {
+				t.Errorf("right password: got %v, %v", ok, err)
+			}
+			if ok, err := verifyPassword(encoded, "wrong horse", fastArgon2.limits()); ok || err != nil {
+				t.Errorf("wrong password: got %v, %v", ok, err)
+			}
+			again, _ := tt.hasher.Hash("correct horse")
+			if again == encoded {
+				t.Error("two hashes of one password are equal; salt is not random")
+			}
+		})
+	}
+}
+
+func TestVerifyPasswordRejectsMalformed(t *testing.T) {
+	const salt = "c2FsdHNhbHRzYWx0c2FsdA"          // 16 bytes
+	const key = "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5" // 24 bytes
+	tests := []struct {
+		name, encoded string
+	}{
+		{"empty", ""},
+		{"unknown algorithm", "$scrypt$ln=15$abc$def"},
+		{"too few parts", "$argon2id$v=19$m=64,t=1,p=1$" + salt},
+		{"wrong version", "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key},
+		{"bad params", "$argon2id$v=19$m=x,t=1,p=1$" + salt + "$" + key},
+		{"zero threads", "$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key},
+		{"zero passes", "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key},
+		{"empty key", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$"},
+		{"short key", "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$a2V5"},
+		{"empty salt", "$argon2id$v=19$m=64,t=1,p=1$$" + key},
+		{"bad base64", "$argon2id$v=19$m=64,t=1,p=1$!!!$" + key},
+		{"huge memory", "$argon2id$v=19$m=4294967295,t=1,p=1$" + salt + "$" + key},
+		{"too many passes", "$argon2id$v=19$m=64,t=13,p=1$" + salt + "$" + key},
+		{"too many threads", "$argon2id$v=19$m=64,t=1,p=9$" + salt + "$" + key},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := verifyPassword(tt.encoded, "anything", fastArgon2.limits())
+			if ok {
+				t.Fatal("malformed hash accepted a password")
+			}
+			if !errors.Is(err, ErrUnknownHashFormat) {
+				t.Errorf("got error %v, want ErrUnknownHashFormat", err)
+			}
+		})
+	}
+}
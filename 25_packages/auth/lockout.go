@@ -0,0 +1,262 @@
+// Package auth (lockout.go)
+// This file slows down password guessing. Failed logins are counted per
+// username and per source (for example a client IP); each failure doubles
+// the wait before the next attempt, and too many failures lock the key out
+// for a while.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Errors wrapped by *LockoutError
+var (
+	// ErrLoginThrottled means the caller must wait before trying again
+	ErrLoginThrottled = errors.New("auth: too many failed attempts, retry later")
+	// ErrAccountLocked means the username or source is locked out until
+	// the lockout expires or Unlock is called
+	ErrAccountLocked = errors.New("auth: temporarily locked after repeated failures")
+)
+
+// LockoutError is returned instead of checking the password when a login
+// attempt is throttled or locked out
+type LockoutError struct {
+	Scope      string        // "user" or "source"
+	Key        string        // The username or source that is limited
+	RetryAfter time.Duration // How long until an attempt will be considered
+	Err        error         // ErrLoginThrottled or ErrAccountLocked
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("%v (%s %q, retry after %s)", e.Err, e.Scope, e.Key, e.RetryAfter.Round(time.Second))
+}
+
+// Unwrap lets errors.Is match ErrLoginThrottled and ErrAccountLocked
+func (e *LockoutError) Unwrap() error { return e.Err }
+
+// LockoutPolicy configures a Limiter
+type LockoutPolicy struct {
+	MaxFailures     int           // Failures before a lockout; 0 disables lockout
+	BaseDelay       time.Duration // Wait after the first failure, doubled for each further one
+	MaxDelay        time.Duration // Upper bound on the backoff wait
+	LockoutDuration time.Duration // How long a lockout lasts
+	ResetAfter      time.Duration // Failures older than this are forgotten
+}
+
+// DefaultUserPolicy limits attempts against a single username
+var DefaultUserPolicy = LockoutPolicy{
+	MaxFailures:     5,
+	BaseDelay:       time.Second,
+	MaxDelay:        30 * time.Second,
+	LockoutDuration: 15 * time.Minute,
+	ResetAfter:      time.Hour,
+}
+
+// DefaultSourcePolicy limits attempts from a single source, which may
+// legitimately serve many users, so it tolerates more failures
+var DefaultSourcePolicy = LockoutPolicy{
+	MaxFailures:     50,
+	BaseDelay:       0,
+	LockoutDuration: 15 * time.Minute,
+	ResetAfter:      time.Hour,
+}
+
+// attempts is the failure history for one key
+type attempts struct {
+	failures    int
+	inFlight    int // Attempts let through by Begin and not yet settled
+	lastFailure time.Time
+	nextAllowed time.Time // End of the current backoff wait
+	lockedUntil time.Time // End of the current lockout
+}
+
+// Limiter tracks failed attempts per key
+// All methods are safe for concurrent use; as in the 23_mutex example the
+// mutex sits next to the map it protects.
+type Limiter struct {
+	scope  string
+	policy LockoutPolicy
+	now    func() time.Time
+
+	mu      sync.Mutex           // Protects keys and pruneAt
+	keys    map[string]*attempts // Failure history per key
+	pruneAt int                  // Size of keys at which Fail prunes it
+}
+
+// minPruneAt is the smallest map size at which Fail bothers to prune
+const minPruneAt = 1024
+
+// NewLimiter returns a Limiter applying policy
+// scope names the kind of key ("user" or "source") in returned errors.
+func NewLimiter(scope string, policy LockoutPolicy) *Limiter {
+	return &Limiter{scope: scope, policy: policy, now: time.Now, keys: make(map[string]*attempts), pruneAt: minPruneAt}
+}
+
+// Check returns a *LockoutError if key may not attempt a login right now
+// It reserves nothing; see Begin.
+func (l *Limiter) Check(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.check(key)
+}
+
+// check does the work of Check. The caller must hold l.mu.
+func (l *Limiter) check(key string) error {
+	now := l.now()
+	a := l.get(key, now)
+	if a == nil {
+		return nil
+	}
+	if now.Before(a.lockedUntil) {
+		return &LockoutError{Scope: l.scope, Key: key, RetryAfter: a.lockedUntil.Sub(now), Err: ErrAccountLocked}
+	}
+	if now.Before(a.nextAllowed) {
+		return &LockoutError{Scope: l.scope, Key: key, RetryAfter: a.nextAllowed.Sub(now), Err: ErrLoginThrottled}
+	}
+	return nil
+}
+
+// Begin is Check for an attempt about to be made: if key may try now, the
+// attempt is counted as in flight until Fail, Reset or Release settles it
+// Checking and reserving under one lock stops concurrent requests from all
+// passing Check while the first of them is still hashing. Attempts in
+// flight count towards MaxFailures, and with a BaseDelay only one attempt
+// at a time is let through, as its backoff is not known until it settles.
+func (l *Limiter) Begin(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.check(key); err != nil {
+		return err
+	}
+	now := l.now()
+	a := l.get(key, now)
+	if a == nil {
+		a = &attempts{}
+		l.keys[key] = a
+	}
+	full := l.policy.MaxFailures > 0 && a.failures+a.inFlight >= l.policy.MaxFailures
+	if full || (l.policy.BaseDelay > 0 && a.inFlight > 0) {
+		return &LockoutError{Scope: l.scope, Key: key, RetryAfter: max(l.policy.BaseDelay, time.Second), Err: ErrLoginThrottled}
+	}
+	a.inFlight++
+	return nil
+}
+
+// Release settles an attempt started with Begin that neither failed nor
+// should clear the history, for example because the store could not be read
+func (l *Limiter) Release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if a := l.get(key, l.now()); a != nil {
+		a.settle()
+		if a.failures == 0 && a.inFlight == 0 {
+			delete(l.keys, key)
+		}
+	}
+}
+
+// Fail records a failed attempt for key, settling one started with Begin
+// It also prunes stale keys whenever the map has doubled since the last
+// prune, so failures from many sources cannot grow it without bound.
+func (l *Limiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if len(l.keys) >= l.pruneAt {
+		l.prune(now)
+		l.pruneAt = max(2*len(l.keys), minPruneAt)
+	}
+	a := l.get(key, now)
+	if a == nil {
+		a = &attempts{}
+		l.keys[key] = a
+	}
+	a.settle()
+	a.failures++
+	a.lastFailure = now
+
+	if l.policy.MaxFailures > 0 && a.failures >= l.policy.MaxFailures {
+		a.lockedUntil = now.Add(l.policy.LockoutDuration)
+		return
+	}
+	if l.policy.BaseDelay > 0 {
+		// BaseDelay, 2*BaseDelay, 4*BaseDelay, ... capped at MaxDelay
+		delay := l.policy.BaseDelay << (a.failures - 1)
+		if delay <= 0 || (l.policy.MaxDelay > 0 && delay > l.policy.MaxDelay) {
+			delay = l.policy.MaxDelay
+		}
+		a.nextAllowed = now.Add(delay)
+	}
+}
+
+// Reset forgets every failure recorded for key, lifting any lockout and
+// settling one attempt started with Begin
+// Other attempts still in flight stay counted.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	a, ok := l.keys[key]
+	if !ok {
+		return
+	}
+	a.settle()
+	if a.inFlight == 0 {
+		delete(l.keys, key)
+		return
+	}
+	*a = attempts{inFlight: a.inFlight}
+}
+
+// Locked reports whether key is currently locked out
+func (l *Limiter) Locked(key string) bool {
+	return errors.Is(l.Check(key), ErrAccountLocked)
+}
+
+// Prune drops the history of keys that are neither limited nor have recent
+// failures, bounding memory use
+// Fail already calls it as the map grows; it is exported for callers that
+// want to reclaim memory on a timer as well.
+func (l *Limiter) Prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(l.now())
+}
+
+// prune does the work of Prune. The caller must hold l.mu.
+func (l *Limiter) prune(now time.Time) {
+	for key := range l.keys {
+		l.get(key, now)
+	}
+}
+
+// settle ends one attempt in flight, if any
+func (a *attempts) settle() {
+	if a.inFlight > 0 {
+		a.inFlight--
+	}
+}
+
+// get returns the live history for key, discarding it first if it has gone
+// stale. The caller must hold l.mu.
+func (l *Limiter) get(key string, now time.Time) *attempts {
+	a, ok := l.keys[key]
+	if !ok {
+		return nil
+	}
+	lockEnded := !a.lockedUntil.IsZero() && !now.Before(a.lockedUntil)
+	stale := l.policy.ResetAfter > 0 && now.Sub(a.lastFailure) >= l.policy.ResetAfter && now.After(a.lockedUntil)
+	if lockEnded || stale {
+		// A finished lockout starts the key over with a clean slate, but
+		// attempts still in flight must stay counted until they settle
+		if a.inFlight > 0 {
+			*a = attempts{inFlight: a.inFlight}
+			return a
+		}
+		delete(l.keys, key)
+		return nil
+	}
+	return a
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(policy LockoutPolicy) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	l := NewLimiter("user", policy)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestLimiterThresholds(t *testing.T) {
+	policy := LockoutPolicy{
+		MaxFailures:     3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Second,
+		LockoutDuration: time.Minute,
+		ResetAfter:      time.Hour,
+	}
+	tests := []struct {
+		name    string
+		fails   int
+		wait    time.Duration // Between the failures and the check
+		wantErr error
+	}{
+		{"no failures", 0, 0, nil},
+		{"backoff after one failure", 1, 0, ErrLoginThrottled},
+		{"backoff over", 1, time.Second, nil},
+		{"below the limit", 2, time.Second, nil},
+		{"at the limit", 3, time.Second, ErrAccountLocked},
+		{"lockout over", 3, time.Minute, nil},
+		{"forgotten after ResetAfter", 2, time.Hour, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, clock := newTestLimiter(policy)
+			for range tt.fails {
+				l.Fail("alice")
+			}
+			clock.advance(tt.wait)
+			if err := l.Check("alice"); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if err := l.Check("bob"); err != nil {
+				t.Errorf("other key: %v", err)
+			}
+		})
+	}
+}
+
+func TestLimiterBackoffDoubles(t *testing.T) {
+	l, clock := newTestLimiter(LockoutPolicy{BaseDelay: time.Second, MaxDelay: 4 * time.Second})
+	for _, want := range []time.Duration{1, 2, 4, 4} {
+		l.Fail("alice")
+		var le *LockoutError
+		if err := l.Check("alice"); !errors.As(err, &le) || le.RetryAfter != want*time.Second {
+			t.Fatalf("got %v, want retry after %s", err, want*time.Second)
+		}
+		clock.advance(want * time.Second)
+	}
+}
+
+func TestLimiterCountsAttemptsInFlight(t *testing.T) {
+	l, _ := newTestLimiter(LockoutPolicy{MaxFailures: 2, LockoutDuration: time.Minute})
+	for i := range 2 {
+		if err := l.Begin("alice"); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+	}
+	if err := l.Begin("alice"); !errors.Is(err, ErrLoginThrottled) {
+		t.Fatalf("third attempt in flight: got %v, want ErrLoginThrottled", err)
+	}
+	l.Release("alice")
+	if err := l.Begin("alice"); err != nil {
+		t.Fatalf("after a release: %v", err)
+	}
+	l.Fail("alice")
+	l.Fail("alice")
+	if !l.Locked("alice") {
+		t.Fatal("not locked after the attempts failed")
+	}
+}
+
+func TestLimiterPrunesOnFail(t *testing.T) {
+	l, clock := newTestLimiter(LockoutPolicy{ResetAfter: time.Minute})
+	for i := range minPruneAt {
+		l.Fail(fmt.Sprint("source", i))
+	}
+	clock.advance(time.Minute)
+	l.Fail("fresh")
+	if n := len(l.keys); n != 1 {
+		t.Fatalf("%d keys left after pruning, want 1", n)
+	}
+}
+
+// TestConcurrentGuessesAreLimited sends many wrong passwords at once, as a
+// script would; no more of them may be checked than the policy allows
+func TestConcurrentGuessesAreLimited(t *testing.T) {
+	a := newTestAuthenticator(t)
+	// A hash slow enough that the guesses overlap while it runs
+	a.SetHasher(Argon2Hasher{Time: 1, Memory: 8 << 10, Threads: 1})
+	if err := a.SetPassword("alice", "u1", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	a.SetLockoutPolicies(LockoutPolicy{MaxFailures: 5, LockoutDuration: time.Minute}, DefaultSourcePolicy)
+
+	var (
+		wg             sync.WaitGroup
+		mu             sync.Mutex
+		checked, other int
+	)
+	start := make(chan struct{})
+	for range 40 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := a.LogInWithCredFrom("10.0.0.1", "alice", "guess")
+			var le *LockoutError
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case errors.Is(err, ErrInvalidCredentials):
+				checked++
+			case !errors.As(err, &le):
+				other++
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if checked > 5 || other > 0 {
+		t.Fatalf("%d passwords checked and %d unexpected errors, want at most 5 and 0", checked, other)
+	}
+	if !a.Locked("alice") {
+		t.Error("alice is not locked out")
+	}
+}
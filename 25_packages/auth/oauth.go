@@ -0,0 +1,358 @@
+// Package auth (oauth.go)
+// This file signs users in through an external identity provider using the
+// OAuth 2.0 authorization-code flow with PKCE (RFC 7636). The provider's
+// OpenID Connect userinfo endpoint tells us who the user is, and that
+// identity is mapped onto a user.User by email address.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rbrishi/Golang/user"
+)
+
+// Errors returned by OAuthProvider
+var (
+	ErrOAuthState           = errors.New("auth: unknown or expired oauth state")
+	ErrOAuthExchange        = errors.New("auth: oauth code exchange failed")
+	ErrOAuthEmailUnverified = errors.New("auth: identity provider did not verify the email address")
+	ErrOAuthBusy            = errors.New("auth: too many oauth logins in progress")
+	ErrOAuthLinkConflict    = errors.New("auth: email belongs to a user linked to another account at this provider")
+)
+
+// maxPendingLogins bounds how many logins may be between AuthCodeURL and
+// Exchange at once, so a client calling AuthCodeURL in a loop cannot grow
+// the pending map without limit
+const maxPendingLogins = 10000
+
+// OAuthConfig describes a client registration at an identity provider
+type OAuthConfig struct {
+	Name         string // Short provider name recorded in Principal.Method, e.g. "google"
+	ClientID     string
+	ClientSecret string
+	AuthURL      string   // Authorization endpoint the browser is sent to
+	TokenURL     string   // Token endpoint the code is exchanged at
+	UserInfoURL  string   // OIDC userinfo endpoint
+	RedirectURL  string   // Our callback, registered with the provider
+	Scopes       []string // Defaults to openid, email and profile
+}
+
+// Identity is what the provider's userinfo endpoint reports
+// The field names are the standard OIDC claims.
+type Identity struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// pendingLogin remembers a login between AuthCodeURL and Exchange
+type pendingLogin struct {
+	verifier  string // PKCE code verifier
+	expiresAt time.Time
+}
+
+// OAuthProvider runs the authorization-code flow against one provider
+type OAuthProvider struct {
+	cfg     OAuthConfig
+	auth    *Authenticator // Optional; supplies the second factor and disabled flag
+	users   user.Repository
+	client  *http.Client
+	auditor Auditor
+	now     func() time.Time
+
+	mu      sync.Mutex              // Protects pending
+	pending map[string]pendingLogin // Keyed by the state parameter
+}
+
+// NewOAuthProvider returns a provider that maps identities onto users in
+// users, creating a user the first time a verified email signs in
+// A user who also has a credential in a is held to it: a disabled account
+// is refused, and a confirmed second factor sets Principal.MFARequired just
+// as LogInWithCred does. a may be nil when there are no credentials.
+func NewOAuthProvider(cfg OAuthConfig, a *Authenticator, users user.Repository) *OAuthProvider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.Name == "" {
+		cfg.Name = "oauth"
+	}
+	return &OAuthProvider{
+		cfg:     cfg,
+		auth:    a,
+		users:   users,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		now:     time.Now,
+		pending: make(map[string]pendingLogin),
+	}
+}
+
+// SetHTTPClient replaces the client used to call the provider
+func (o *OAuthProvider) SetHTTPClient(c *http.Client) {
+	o.client = c
+}
+
+// SetAuditor makes the provider report logins to auditor
+func (o *OAuthProvider) SetAuditor(auditor Auditor) {
+	o.auditor = auditor
+}
+
+// AuthCodeURL starts a login and returns the URL to send the browser to,
+// together with the state that will come back on the callback
+// The state should also be bound to the browser (for example in a
+// short-lived cookie) and compared on the callback, to stop login CSRF.
+// ErrOAuthBusy is returned while too many logins are in progress.
+func (o *OAuthProvider) AuthCodeURL() (authURL, state string, err error) {
+	state, err = newToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := newToken() // 43 characters, within RFC 7636's 43..128
+	if err != nil {
+		return "", "", err
+	}
+
+	o.mu.Lock()
+	now := o.now()
+	for s, p := range o.pending {
+		if now.After(p.expiresAt) {
+			delete(o.pending, s)
+		}
+	}
+	if len(o.pending) >= maxPendingLogins {
+		o.mu.Unlock()
+		return "", "", ErrOAuthBusy
+	}
+	o.pending[state] = pendingLogin{verifier: verifier, expiresAt: now.Add(10 * time.Minute)}
+	o.mu.Unlock()
+
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.cfg.ClientID},
+		"redirect_uri":          {o.cfg.RedirectURL},
+		"scope":                 {strings.Join(o.cfg.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(o.cfg.AuthURL, "?") {
+		sep = "&"
+	}
+	return o.cfg.AuthURL + sep + v.Encode(), state, nil
+}
+
+// Exchange finishes a login from the callback's state and code
+// It redeems the code with the PKCE verifier, fetches the identity and
+// returns a Principal for the matching user.User.
+func (o *OAuthProvider) Exchange(ctx context.Context, state, code string) (*Principal, error) {
+	return o.ExchangeFrom(ctx, "", state, code)
+}
+
+// ExchangeFrom is Exchange for a callback that came from source, typically
+// the browser's IP address, which is recorded in the audit event and
+// Principal
+func (o *OAuthProvider) ExchangeFrom(ctx context.Context, source, state, code string) (*Principal, error) {
+	p, err := o.exchange(ctx, state, code)
+	if p != nil {
+		p.Source = source
+	}
+	username := ""
+	if p != nil {
+		username = p.Username
+	}
+	typ := EventLoginSuccess
+	if err != nil {
+		typ = EventLoginFailure
+	}
+	result, detail := outcome(err)
+	recordEvent(o.auditor, Event{Type: typ, User: username, Source: source, Method: "oauth:" + o.cfg.Name, Outcome: result, Detail: detail})
+	return p, err
+}
+
+// exchange does the work of Exchange
+func (o *OAuthProvider) exchange(ctx context.Context, state, code string) (*Principal, error) {
+	o.mu.Lock()
+	pending, ok := o.pending[state]
+	delete(o.pending, state) // A state is good for one attempt only
+	o.mu.Unlock()
+	if !ok || o.now().After(pending.expiresAt) {
+		return nil, ErrOAuthState
+	}
+
+	accessToken, err := o.redeem(ctx, code, pending.verifier)
+	if err != nil {
+		return nil, err
+	}
+	id, err := o.userInfo(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	u, err := o.mapUser(id)
+	if err != nil {
+		return nil, err
+	}
+	p := &Principal{
+		Username: u.Email,
+		UserID:   u.ID,
+		Method:   "oauth:" + o.cfg.Name,
+		AuthTime: o.now(),
+		Roles:    u.Roles,
+	}
+	if err := o.checkCredential(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// checkCredential applies the password login's account checks to p
+// The provider vouches for the email address only, so a second factor the
+// user enrolled here must still be asked for.
+func (o *OAuthProvider) checkCredential(p *Principal) error {
+	if o.auth == nil {
+		return nil
+	}
+	cred, err := o.auth.accountCredential(p.Username)
+	if err != nil || cred == nil {
+		return err
+	}
+	p.MFARequired = cred.TOTPConfirmed
+	return nil
+}
+
+// redeem trades the authorization code for an access token
+func (o *OAuthProvider) redeem(ctx context.Context, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.cfg.ClientID), url.QueryEscape(o.cfg.ClientSecret))
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		Error       string `json:"error"`
+	}
+	if err := o.doJSON(req, &tok); err != nil {
+		return "", err
+	}
+	if tok.AccessToken == "" || !strings.EqualFold(tok.TokenType, "bearer") {
+		return "", fmt.Errorf("%w: no bearer token in response", ErrOAuthExchange)
+	}
+	return tok.AccessToken, nil
+}
+
+// userInfo fetches the identity behind accessToken
+func (o *OAuthProvider) userInfo(ctx context.Context, accessToken string) (*Identity, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.cfg.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	var id Identity
+	if err := o.doJSON(req, &id); err != nil {
+		return nil, err
+	}
+	if id.Subject == "" {
+		return nil, fmt.Errorf("%w: userinfo has no subject", ErrOAuthExchange)
+	}
+	return &id, nil
+}
+
+// doJSON sends req and decodes a 200 JSON response into v
+func (o *OAuthProvider) doJSON(req *http.Request, v any) error {
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOAuthExchange, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOAuthExchange, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s: %s", ErrOAuthExchange, resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrOAuthExchange, err)
+	}
+	return nil
+}
+
+// mapUser finds or creates the user.User for id
+// A user linked to id.Subject at this provider is that user, whatever email
+// the provider reports now. Otherwise the user is found by email and the
+// subject linked to it, so later logins no longer depend on the address.
+// Matching by email needs a verified email: otherwise anyone able to
+// register that address at the provider could take over the account. A
+// user already linked to a different subject here is refused, as the
+// address has moved to another account at the provider.
+func (o *OAuthProvider) mapUser(id *Identity) (*user.User, error) {
+	u, err := o.users.GetByExternalID(o.cfg.Name, id.Subject)
+	if err == nil {
+		return u, nil
+	}
+	if !errors.Is(err, user.ErrNotFound) {
+		return nil, err
+	}
+	if !id.EmailVerified {
+		return nil, ErrOAuthEmailUnverified
+	}
+	u, err = o.users.GetByEmail(id.Email)
+	if err == nil {
+		if _, linked := u.ExternalID(o.cfg.Name); linked {
+			return nil, ErrOAuthLinkConflict
+		}
+		u.LinkExternalID(o.cfg.Name, id.Subject)
+		u.EmailVerified = true
+		u.UpdatedAt = o.now().UTC()
+		if err := o.users.Update(u); err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+	if !errors.Is(err, user.ErrNotFound) {
+		return nil, err
+	}
+
+	name := id.Name
+	if name == "" {
+		name = id.Email
+	}
+	u, err = user.New(name, id.Email)
+	if err != nil {
+		return nil, err
+	}
+	u.EmailVerified = true
+	u.LinkExternalID(o.cfg.Name, id.Subject)
+	if err := o.users.Create(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// pkceChallenge returns the S256 code challenge for verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
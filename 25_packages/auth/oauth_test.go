@@ -0,0 +1,179 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rbrishi/Golang/auth"
+	"github.com/rbrishi/Golang/auth/fakeidp"
+	"github.com/rbrishi/Golang/user"
+)
+
+const callbackURL = "http://app.test/oauth/callback"
+
+// oauthTest wires an OAuthProvider to a running fake identity provider
+type oauthTest struct {
+	idp      *fakeidp.Provider
+	provider *auth.OAuthProvider
+	auth     *auth.Authenticator
+	users    *user.MemoryRepository
+}
+
+func newOAuthTest(t *testing.T) *oauthTest {
+	t.Helper()
+	idp := fakeidp.New("client", "secret")
+	t.Cleanup(idp.Close)
+	idp.SignIn(auth.Identity{Subject: "42", Email: "ann@example.com", EmailVerified: true, Name: "Ann"})
+	a := auth.NewAuthenticator(auth.NewMemoryStore())
+	a.SetHasher(auth.Argon2Hasher{Time: 1, Memory: 64, Threads: 1})
+	users := user.NewMemoryRepository()
+	return &oauthTest{idp: idp, provider: auth.NewOAuthProvider(idp.Config(callbackURL), a, users), auth: a, users: users}
+}
+
+// login runs the browser's part of the flow; tamper may change the
+// authorization URL before it is followed
+func (o *oauthTest) login(t *testing.T, tamper func(q url.Values)) (state string, callback url.Values) {
+	t.Helper()
+	authURL, state, err := o.provider.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tamper != nil {
+		q := u.Query()
+		tamper(q)
+		u.RawQuery = q.Encode()
+	}
+	cb, err := o.idp.Authorize(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state, cb.Query()
+}
+
+func TestOAuthExchange(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(q url.Values)
+		state   func(state string) string
+		wantErr error
+	}{
+		{"success", nil, nil, nil},
+		{"state mismatch", nil, func(string) string { return "forged" }, auth.ErrOAuthState},
+		{"pkce challenge replaced", func(q url.Values) {
+			q.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+		}, nil, auth.ErrOAuthExchange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOAuthTest(t)
+			state, cb := o.login(t, tt.tamper)
+			if cb.Get("state") != state {
+				t.Fatalf("provider returned state %q, want %q", cb.Get("state"), state)
+			}
+			if tt.state != nil {
+				state = tt.state(state)
+			}
+			p, err := o.provider.Exchange(context.Background(), state, cb.Get("code"))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			u, err := o.users.GetByEmail("ann@example.com")
+			if err != nil {
+				t.Fatalf("user not created: %v", err)
+			}
+			if p.UserID != u.ID || p.Method != "oauth:fake" || p.MFARequired || !u.EmailVerified {
+				t.Errorf("got principal %+v for user %+v", p, u)
+			}
+		})
+	}
+}
+
+func TestOAuthStateIsSingleUse(t *testing.T) {
+	o := newOAuthTest(t)
+	state, cb := o.login(t, nil)
+	if _, err := o.provider.Exchange(context.Background(), state, "wrong code"); !errors.Is(err, auth.ErrOAuthExchange) {
+		t.Fatalf("got %v, want ErrOAuthExchange", err)
+	}
+	if _, err := o.provider.Exchange(context.Background(), state, cb.Get("code")); !errors.Is(err, auth.ErrOAuthState) {
+		t.Fatalf("second use of the state: got %v, want ErrOAuthState", err)
+	}
+}
+
+func TestOAuthKeepsSecondFactor(t *testing.T) {
+	o := newOAuthTest(t)
+	u, err := user.New("Ann", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.users.Create(u); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.auth.SetPassword(u.Email, u.ID, "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	enrollment, err := o.auth.EnrollTOTP(u.Email, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := auth.DefaultTOTP.Code(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.auth.ConfirmTOTP(u.Email, code); err != nil {
+		t.Fatal(err)
+	}
+
+	state, cb := o.login(t, nil)
+	p, err := o.provider.Exchange(context.Background(), state, cb.Get("code"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.MFARequired {
+		t.Fatal("an OAuth login skipped the user's second factor")
+	}
+	if _, err := o.auth.VerifySecondFactor(p, enrollment.RecoveryCodes[0]); err != nil {
+		t.Fatalf("completing the login: %v", err)
+	}
+}
+
+func TestOAuthLinksSubject(t *testing.T) {
+	o := newOAuthTest(t)
+	exchange := func() (*auth.Principal, error) {
+		state, cb := o.login(t, nil)
+		return o.provider.Exchange(context.Background(), state, cb.Get("code"))
+	}
+	first, err := exchange()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := o.users.GetByExternalID("fake", "42")
+	if err != nil || u.ID != first.UserID {
+		t.Fatalf("subject not linked: got %v, %v", u, err)
+	}
+
+	// The account's address changes at the provider: still the same user
+	o.idp.SignIn(auth.Identity{Subject: "42", Email: "ann@new.example"})
+	again, err := exchange()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.UserID != first.UserID {
+		t.Errorf("got user %s, want %s", again.UserID, first.UserID)
+	}
+
+	// Another account now claims Ann's address: not Ann
+	o.idp.SignIn(auth.Identity{Subject: "43", Email: "ann@example.com", EmailVerified: true})
+	if _, err := exchange(); !errors.Is(err, auth.ErrOAuthLinkConflict) {
+		t.Fatalf("got %v, want ErrOAuthLinkConflict", err)
+	}
+}
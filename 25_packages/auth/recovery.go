@@ -0,0 +1,269 @@
+// Package auth (recovery.go)
+// This file implements the flows that go through the user's inbox: email
+// verification after sign-up and password reset. Both mail the user a
+// single-use, expiring token; like session tokens, only their hashes are
+// stored.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rbrishi/Golang/mailer"
+	"github.com/rbrishi/Golang/user"
+)
+
+// ErrInvalidToken is returned for a one-time token that is unknown, already
+// used, expired or meant for another purpose. The cases are not
+// distinguished, to give an attacker nothing to work with.
+var ErrInvalidToken = errors.New("auth: invalid or expired token")
+
+// Purpose says what a OneTimeToken may be used for
+type Purpose string
+
+// Purposes of one-time tokens
+const (
+	PurposeVerifyEmail   Purpose = "verify-email"
+	PurposeResetPassword Purpose = "reset-password"
+)
+
+// OneTimeToken is the stored form of a token mailed to a user
+type OneTimeToken struct {
+	ID        string    `json:"id"` // SHA-256 of the mailed token
+	Purpose   Purpose   `json:"purpose"`
+	UserID    string    `json:"user_id"`
+	Email     string    `json:"email"` // Address the token was sent to
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// OneTimeTokenStore is implemented by anything that can persist one-time
+// tokens
+// Implementations must be safe for concurrent use.
+type OneTimeTokenStore interface {
+	PutOneTimeToken(t OneTimeToken) error
+	// TakeOneTimeToken returns and deletes a token in one step, so that it
+	// can be used at most once even under concurrent requests.
+	TakeOneTimeToken(id string) (OneTimeToken, error)
+	// DeleteOneTimeTokensFunc deletes every token for which match returns
+	// true and reports how many were deleted.
+	DeleteOneTimeTokensFunc(match func(OneTimeToken) bool) (int, error)
+}
+
+// MemoryOneTimeTokenStore is a OneTimeTokenStore that keeps tokens in a map
+type MemoryOneTimeTokenStore struct {
+	mu     sync.Mutex              // Protects tokens
+	tokens map[string]OneTimeToken // Keyed by OneTimeToken.ID
+}
+
+// NewMemoryOneTimeTokenStore returns an empty MemoryOneTimeTokenStore
+func NewMemoryOneTimeTokenStore() *MemoryOneTimeTokenStore {
+	return &MemoryOneTimeTokenStore{tokens: make(map[string]OneTimeToken)}
+}
+
+// PutOneTimeToken stores t
+func (m *MemoryOneTimeTokenStore) PutOneTimeToken(t OneTimeToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[t.ID] = t
+	return nil
+}
+
+// TakeOneTimeToken removes and returns the token with the given ID
+func (m *MemoryOneTimeTokenStore) TakeOneTimeToken(id string) (OneTimeToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokens[id]
+	if !ok {
+		return OneTimeToken{}, ErrInvalidToken
+	}
+	delete(m.tokens, id)
+	return t, nil
+}
+
+// DeleteOneTimeTokensFunc removes every token matched by match
+func (m *MemoryOneTimeTokenStore) DeleteOneTimeTokensFunc(match func(OneTimeToken) bool) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, t := range m.tokens {
+		if match(t) {
+			delete(m.tokens, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Recovery runs the email verification and password reset flows
+// Credentials are looked up by the user's email address, which is the login
+// name used throughout this module.
+type Recovery struct {
+	auth     *Authenticator
+	sessions *SessionManager
+	users    user.Repository
+	mail     mailer.Mailer
+	tokens   OneTimeTokenStore
+	now      func() time.Time
+
+	// BaseURL is where the links in the mails point, e.g.
+	// "https://example.com"; the token is appended as a query parameter.
+	BaseURL string
+	// VerifyTTL and ResetTTL bound how long a mailed token stays usable.
+	VerifyTTL time.Duration
+	ResetTTL  time.Duration
+}
+
+// NewRecovery returns a Recovery with in-memory token storage
+// Verification links last 24 hours and reset links one hour.
+func NewRecovery(a *Authenticator, sessions *SessionManager, users user.Repository, mail mailer.Mailer) *Recovery {
+	return &Recovery{
+		auth:      a,
+		sessions:  sessions,
+		users:     users,
+		mail:      mail,
+		tokens:    NewMemoryOneTimeTokenStore(),
+		now:       time.Now,
+		BaseURL:   "http://localhost:8080",
+		VerifyTTL: 24 * time.Hour,
+		ResetTTL:  time.Hour,
+	}
+}
+
+// SetTokenStore replaces the in-memory token storage
+func (r *Recovery) SetTokenStore(s OneTimeTokenStore) {
+	r.tokens = s
+}
+
+// SendVerification mails u a link that confirms they own u.Email
+func (r *Recovery) SendVerification(u *user.User) error {
+	token, err := r.issue(PurposeVerifyEmail, u, r.VerifyTTL)
+	if err != nil {
+		return err
+	}
+	return r.mail.Send(mailer.Message{
+		To:      u.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening this link within %s:\n\n%s\n",
+			u.Name, r.VerifyTTL, r.link("/verify-email", token)),
+	})
+}
+
+// VerifyEmail consumes a verification token and marks the user's address
+// verified
+// A token sent to an address the user has since changed is rejected.
+func (r *Recovery) VerifyEmail(token string) (*user.User, error) {
+	t, err := r.take(PurposeVerifyEmail, token)
+	if err != nil {
+		return nil, err
+	}
+	u, err := r.users.GetByID(t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if u.Email != t.Email {
+		return nil, ErrInvalidToken
+	}
+	u.EmailVerified = true
+	u.UpdatedAt = r.now().UTC()
+	if err := r.users.Update(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// RequestPasswordReset mails a reset link to email if it belongs to a user
+// It returns nil for unknown addresses too, so the endpoint cannot be used
+// to find out who has an account.
+func (r *Recovery) RequestPasswordReset(email string) error {
+	u, err := r.users.GetByEmail(email)
+	if errors.Is(err, user.ErrNotFound) || errors.Is(err, user.ErrInvalidEmail) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Only the newest reset link works
+	r.tokens.DeleteOneTimeTokensFunc(func(t OneTimeToken) bool {
+		return t.Purpose == PurposeResetPassword && t.UserID == u.ID
+	})
+	token, err := r.issue(PurposeResetPassword, u, r.ResetTTL)
+	if err != nil {
+		return err
+	}
+	return r.mail.Send(mailer.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset your password. If it was you, open this link within %s:\n\n%s\n\nOtherwise you can ignore this email.\n",
+			u.Name, r.ResetTTL, r.link("/password-reset/confirm", token)),
+	})
+}
+
+// ResetPassword consumes a reset token and sets a new password
+// Every existing session of the user is revoked and any lockout lifted.
+// Receiving the link also proves ownership of the address, so it is marked
+// verified.
+func (r *Recovery) ResetPassword(token, newPassword string) error {
+	t, err := r.take(PurposeResetPassword, token)
+	if err != nil {
+		return err
+	}
+	u, err := r.users.GetByID(t.UserID)
+	if err != nil {
+		return err
+	}
+	if u.Email != t.Email {
+		return ErrInvalidToken
+	}
+	if err := r.auth.SetPassword(u.Email, u.ID, newPassword); err != nil {
+		return err
+	}
+	recordEvent(r.auth.auditor, Event{Type: EventPasswordReset, User: u.Email, Outcome: OutcomeSuccess})
+	r.auth.Unlock(u.Email)
+	if r.sessions != nil {
+		if _, err := r.sessions.RevokeAll(u.Email); err != nil {
+			return err
+		}
+	}
+	if !u.EmailVerified {
+		u.EmailVerified = true
+		u.UpdatedAt = r.now().UTC()
+		return r.users.Update(u)
+	}
+	return nil
+}
+
+// issue stores a new token for u and returns its mailed form
+func (r *Recovery) issue(purpose Purpose, u *user.User, ttl time.Duration) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	err = r.tokens.PutOneTimeToken(OneTimeToken{
+		ID:        hashToken(token),
+		Purpose:   purpose,
+		UserID:    u.ID,
+		Email:     u.Email,
+		ExpiresAt: r.now().Add(ttl),
+	})
+	return token, err
+}
+
+// take consumes token and checks that it is live and meant for purpose
+func (r *Recovery) take(purpose Purpose, token string) (OneTimeToken, error) {
+	t, err := r.tokens.TakeOneTimeToken(hashToken(token))
+	if err != nil {
+		return OneTimeToken{}, err
+	}
+	if t.Purpose != purpose || !r.now().Before(t.ExpiresAt) {
+		return OneTimeToken{}, ErrInvalidToken
+	}
+	return t, nil
+}
+
+// link builds the URL mailed to the user
+func (r *Recovery) link(path, token string) string {
+	return r.BaseURL + path + "?" + url.Values{"token": {token}}.Encode()
+}
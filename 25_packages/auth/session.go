@@ -1,12 +1,314 @@
 // Package auth (session.go)
 // This file is part of the auth package and shares the same package declaration
+// It turns a successful login into a server-side session identified by an
+// opaque random token.
 package auth
 
-// GetSession is an exported function that returns the current session status
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rbrishi/Golang/user"
+)
+
+// Errors returned by SessionManager
+var (
+	ErrSessionNotFound = errors.New("auth: session not found")
+	ErrSessionExpired  = errors.New("auth: session expired")
+	// ErrMFAIncomplete is returned by Create for a principal that still
+	// has to pass VerifySecondFactor
+	ErrMFAIncomplete = errors.New("auth: second factor required")
+)
+
+// Session is a logged-in principal remembered between requests
+type Session struct {
+	ID        string            `json:"id"`               // SHA-256 of the token; safe to store and log
+	Token     string            `json:"-"`                // Opaque token handed to the client; only set by Create
+	Username  string            `json:"username"`         // Principal.Username
+	UserID    string            `json:"user_id"`          // Principal.UserID
+	Method    string            `json:"method"`           // Principal.Method
+	Source    string            `json:"source,omitempty"` // Principal.Source: where the login came from
+	MFA       bool              `json:"mfa"`              // Principal.MFA: a second factor was verified
+	Roles     []string          `json:"roles"`            // Principal.Roles, used by Authorize
+	Scopes    []user.Permission `json:"scopes,omitempty"` // Principal.Scopes, used by Authorize
+	CreatedAt time.Time         `json:"created_at"`       // When the session was created
+	LastSeen  time.Time         `json:"last_seen"`        // Last successful lookup, for idle expiry
+	ExpiresAt time.Time         `json:"expires_at"`       // Absolute expiry, regardless of activity
+}
+
+// SessionStore is implemented by anything that can persist sessions
+// Sessions are keyed by Session.ID, never by the raw token, so a leaked
+// store cannot be replayed as cookies. Implementations must be safe for
+// concurrent use.
+type SessionStore interface {
+	GetSession(id string) (Session, error)
+	PutSession(s Session) error
+	// TouchSession sets the LastSeen of an existing session and returns
+	// ErrSessionNotFound if there is none. It must not recreate a session
+	// deleted meanwhile, so a lookup racing a revocation cannot undo it.
+	TouchSession(id string, lastSeen time.Time) error
+	DeleteSession(id string) error
+	// DeleteSessionsFunc deletes every session for which match returns
+	// true and reports how many were deleted.
+	DeleteSessionsFunc(match func(Session) bool) (int, error)
+}
+
+// MemorySessionStore is a SessionStore that keeps sessions in a map
+type MemorySessionStore struct {
+	mu       sync.RWMutex       // Protects sessions
+	sessions map[string]Session // Keyed by Session.ID
+}
+
+// NewMemorySessionStore returns an empty MemorySessionStore
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]Session)}
+}
+
+// GetSession returns the session with the given ID
+func (m *MemorySessionStore) GetSession(id string) (Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	return s, nil
+}
+
+// PutSession creates or replaces s
+func (m *MemorySessionStore) PutSession(s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.ID] = s
+	return nil
+}
+
+// TouchSession updates LastSeen of the session with the given ID, if it
+// still exists
+func (m *MemorySessionStore) TouchSession(id string, lastSeen time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	s.LastSeen = lastSeen
+	m.sessions[id] = s
+	return nil
+}
+
+// DeleteSession removes the session with the given ID
+func (m *MemorySessionStore) DeleteSession(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[id]; !ok {
+		return ErrSessionNotFound
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+// DeleteSessionsFunc removes every session matched by match
+func (m *MemorySessionStore) DeleteSessionsFunc(match func(Session) bool) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, s := range m.sessions {
+		if match(s) {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// SessionManager creates, looks up and revokes sessions
+type SessionManager struct {
+	store   SessionStore
+	now     func() time.Time
+	auditor Auditor // Optional; receives creation and revocation events
+
+	// IdleTimeout ends a session that has not been used for this long.
+	// Zero disables idle expiry.
+	IdleTimeout time.Duration
+	// MaxAge ends a session this long after it was created, however
+	// active it is. Zero disables absolute expiry.
+	MaxAge time.Duration
+}
+
+// NewSessionManager returns a SessionManager backed by store with a
+// 30 minute idle timeout and a 12 hour absolute lifetime
+func NewSessionManager(store SessionStore) *SessionManager {
+	return &SessionManager{
+		store:       store,
+		now:         time.Now,
+		IdleTimeout: 30 * time.Minute,
+		MaxAge:      12 * time.Hour,
+	}
+}
+
+// SetAuditor makes the SessionManager report created and revoked sessions
+// to auditor
+func (m *SessionManager) SetAuditor(auditor Auditor) {
+	m.auditor = auditor
+}
+
+// Create starts a new session for p
+// The returned Session is the only place the raw Token appears; hand it to
+// the client and forget it.
+func (m *SessionManager) Create(p *Principal) (*Session, error) {
+	if p.MFARequired {
+		return nil, ErrMFAIncomplete
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	s := Session{
+		ID:        hashToken(token),
+		Username:  p.Username,
+		UserID:    p.UserID,
+		Method:    p.Method,
+		Source:    p.Source,
+		MFA:       p.MFA,
+		Roles:     p.Roles,
+		Scopes:    p.Scopes,
+		CreatedAt: now,
+		LastSeen:  now,
+	}
+	if m.MaxAge > 0 {
+		s.ExpiresAt = now.Add(m.MaxAge)
+	}
+	if err := m.store.PutSession(s); err != nil {
+		return nil, err
+	}
+	recordEvent(m.auditor, Event{Type: EventSessionCreated, User: s.Username, Source: s.Source,
+		Method: s.Method, Outcome: OutcomeSuccess, Detail: "session " + s.ID[:12]})
+	s.Token = token
+	return &s, nil
+}
+
+// GetSession returns the live session identified by token and records the
+// lookup as activity
+// Expired sessions are deleted and reported as ErrSessionExpired.
+func (m *SessionManager) GetSession(token string) (*Session, error) {
+	id := hashToken(token)
+	s, err := m.store.GetSession(id)
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	if m.expired(s, now) {
+		m.store.DeleteSession(id)
+		return nil, ErrSessionExpired
+	}
+	// A Revoke may have deleted the session since it was read; touching
+	// fails then instead of writing it back
+	if err := m.store.TouchSession(id, now); err != nil {
+		return nil, err
+	}
+	s.LastSeen = now
+	return &s, nil
+}
+
+// Revoke ends the session identified by token
+// It is RevokeFrom with no source address.
+func (m *SessionManager) Revoke(token string) error {
+	return m.RevokeFrom("", token)
+}
+
+// RevokeFrom ends the session identified by token for a request coming
+// from source, which is recorded in the audit event
+func (m *SessionManager) RevokeFrom(source, token string) error {
+	id := hashToken(token)
+	s, err := m.store.GetSession(id)
+	if err != nil {
+		return err
+	}
+	if err := m.store.DeleteSession(id); err != nil {
+		return err
+	}
+	recordEvent(m.auditor, Event{Type: EventSessionRevoked, User: s.Username, Source: source,
+		Outcome: OutcomeSuccess, Detail: "session " + id[:12]})
+	return nil
+}
+
+// RevokeAll ends every session belonging to username, for example after a
+// password change, and reports how many were ended
+func (m *SessionManager) RevokeAll(username string) (int, error) {
+	username = normalizeUsername(username)
+	n, err := m.store.DeleteSessionsFunc(func(s Session) bool {
+		return s.Username == username
+	})
+	if err == nil {
+		recordEvent(m.auditor, Event{Type: EventSessionRevoked, User: username, Outcome: OutcomeSuccess,
+			Detail: fmt.Sprintf("all sessions (%d)", n)})
+	}
+	return n, err
+}
+
+// Cleanup deletes every expired session and reports how many were deleted
+// Expired sessions are already rejected by GetSession; Cleanup only
+// reclaims their storage.
+func (m *SessionManager) Cleanup() (int, error) {
+	now := m.now()
+	return m.store.DeleteSessionsFunc(func(s Session) bool {
+		return m.expired(s, now)
+	})
+}
+
+// expired applies the idle and absolute limits to s
+func (m *SessionManager) expired(s Session, now time.Time) bool {
+	if !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt) {
+		return true
+	}
+	return m.IdleTimeout > 0 && now.Sub(s.LastSeen) >= m.IdleTimeout
+}
+
+// newToken returns 32 random bytes encoded for use in a cookie or header
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken derives the storage key for a token, so stores never hold a
+// value that can be presented back to us
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SessionResolver looks up the session a token stands for
+// SessionManager resolves tokens through its store; TokenManager resolves
+// them from their signed claims alone.
+type SessionResolver interface {
+	GetSession(token string) (*Session, error)
+}
+
+// DefaultSessions is the SessionManager used by the package-level GetSession
+var DefaultSessions = NewSessionManager(NewMemorySessionStore())
+
+// DefaultResolver answers the package-level GetSession
+// Edge services without access to the session store can point it at a
+// TokenManager instead.
+var DefaultResolver SessionResolver = DefaultSessions
+
+// GetSession is an exported function that returns the session identified by
+// token from DefaultResolver
 // It demonstrates:
 // 1. Package member visibility (exported function)
 // 2. Multiple files in the same package
 // 3. Package-level organization of related functionality
-func GetSession() string {
-	return "session loged in"
-}
\ No newline at end of file
+func GetSession(token string) (*Session, error) {
+	return DefaultResolver.GetSession(token)
+}
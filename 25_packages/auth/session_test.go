@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for the managers' now fields
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestSessions() (*SessionManager, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	m := NewSessionManager(NewMemorySessionStore())
+	m.now = clock.now
+	return m, clock
+}
+
+func TestSessionExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []time.Duration // Waits, each followed by a lookup
+		wantErr error
+	}{
+		{"fresh", []time.Duration{0}, nil},
+		{"active within idle timeout", []time.Duration{20 * time.Minute, 20 * time.Minute, 20 * time.Minute}, nil},
+		{"idle too long", []time.Duration{31 * time.Minute}, ErrSessionExpired},
+		{"past max age while active", repeat(25*time.Minute, 29), ErrSessionExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, clock := newTestSessions()
+			s, err := m.Create(&Principal{Username: "alice"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, d := range tt.steps {
+				clock.advance(d)
+				_, err = m.GetSession(s.Token)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				// Expired sessions are deleted, not just refused
+				if _, err := m.GetSession(s.Token); !errors.Is(err, ErrSessionNotFound) {
+					t.Errorf("after expiry got %v, want ErrSessionNotFound", err)
+				}
+			}
+		})
+	}
+}
+
+func repeat(d time.Duration, n int) []time.Duration {
+	out := make([]time.Duration, n)
+	for i := range out {
+		out[i] = d
+	}
+	return out
+}
+
+func TestCreateRefusesIncompleteMFA(t *testing.T) {
+	m, _ := newTestSessions()
+	if _, err := m.Create(&Principal{Username: "alice", MFARequired: true}); !errors.Is(err, ErrMFAIncomplete) {
+		t.Fatalf("got %v, want ErrMFAIncomplete", err)
+	}
+}
+
+// slowStore widens the gap between reading a session and touching it
+type slowStore struct{ *MemorySessionStore }
+
+func (s slowStore) GetSession(id string) (Session, error) {
+	sess, err := s.MemorySessionStore.GetSession(id)
+	time.Sleep(2 * time.Millisecond)
+	return sess, err
+}
+
+func TestRevokeWinsOverConcurrentLookup(t *testing.T) {
+	for range 20 {
+		m := NewSessionManager(slowStore{NewMemorySessionStore()})
+		s, err := m.Create(&Principal{Username: "alice"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); m.GetSession(s.Token) }()
+		go func() { defer wg.Done(); time.Sleep(time.Millisecond); m.RevokeAll("alice") }()
+		wg.Wait()
+		if _, err := m.GetSession(s.Token); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatalf("revoked session came back: %v", err)
+		}
+	}
+}
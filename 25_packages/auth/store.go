@@ -0,0 +1,199 @@
+// Package auth (store.go)
+// This file defines where credentials live. The CredentialStore interface
+// lets LogInWithCred verify passwords without caring whether the hashes are
+// kept in memory or on disk.
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/rbrishi/Golang/internal/atomicfile"
+)
+
+// ErrCredentialNotFound is returned by a CredentialStore when no credential
+// exists for the requested username.
+var ErrCredentialNotFound = errors.New("auth: credential not found")
+
+// Credential is the stored form of a user's password
+// It never contains the plaintext password, only the encoded hash.
+type Credential struct {
+	Username string `json:"username"`          // Login name, normalized with normalizeUsername
+	UserID   string `json:"user_id,omitempty"` // Optional link to a user.User ID
+	Hash     string `json:"hash"`              // Encoded hash produced by a Hasher
+	Disabled bool   `json:"disabled,omitempty"`
+
+	// Second factor, see totp.go
+	TOTPSecret    string   `json:"totp_secret,omitempty"`    // Base32 TOTP secret
+	TOTPConfirmed bool     `json:"totp_confirmed,omitempty"` // Enrollment confirmed with a valid code
+	TOTPLastStep  int64    `json:"totp_last_step,omitempty"` // Last accepted time step, to stop replays
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // SHA-256 of unused recovery codes
+}
+
+// CredentialStore is implemented by anything that can save and look up
+// credentials by username
+// Implementations must be safe for concurrent use.
+type CredentialStore interface {
+	GetCredential(username string) (Credential, error)
+	PutCredential(c Credential) error
+	DeleteCredential(username string) error
+}
+
+// MemoryStore is a CredentialStore that keeps credentials in a map
+// It is useful for tests and demos; everything is lost when the process exits.
+type MemoryStore struct {
+	mu    sync.RWMutex          // Protects creds
+	creds map[string]Credential // Keyed by normalized username
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{creds: make(map[string]Credential)}
+}
+
+// GetCredential returns the credential stored for username
+func (s *MemoryStore) GetCredential(username string) (Credential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.creds[normalizeUsername(username)]
+	if !ok {
+		return Credential{}, ErrCredentialNotFound
+	}
+	return c, nil
+}
+
+// PutCredential creates or replaces the credential for c.Username
+func (s *MemoryStore) PutCredential(c Credential) error {
+	c.Username = normalizeUsername(c.Username)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds[c.Username] = c
+	return nil
+}
+
+// DeleteCredential removes the credential for username
+func (s *MemoryStore) DeleteCredential(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	username = normalizeUsername(username)
+	if _, ok := s.creds[username]; !ok {
+		return ErrCredentialNotFound
+	}
+	delete(s.creds, username)
+	return nil
+}
+
+// FileStore is a CredentialStore backed by a JSON file
+// The whole file is loaded into memory when the store is opened and
+// rewritten atomically after every change.
+type FileStore struct {
+	path string
+	mem  *MemoryStore         // In-memory copy of the file contents
+	mu   sync.Mutex           // Serializes writes so the file matches mem
+	lock *atomicfile.FileLock // Keeps other FileStores off the file until Close
+}
+
+// OpenFileStore loads the credentials in path
+// A missing file is treated as an empty store and created on the first write.
+// The store holds path's lock until Close, so a second store on the same
+// file, which would overwrite the first one's changes, cannot be opened.
+func OpenFileStore(path string) (*FileStore, error) {
+	lock, err := atomicfile.Lock(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{path: path, mem: NewMemoryStore(), lock: lock}
+	if err := s.load(); err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the file into s.mem
+func (s *FileStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var creds []Credential
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return err
+	}
+	for _, c := range creds {
+		s.mem.PutCredential(c)
+	}
+	return nil
+}
+
+// Close releases the file for other stores
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lock.Unlock()
+}
+
+// GetCredential returns the credential stored for username
+func (s *FileStore) GetCredential(username string) (Credential, error) {
+	return s.mem.GetCredential(username)
+}
+
+// PutCredential creates or replaces the credential for c.Username and
+// persists the store
+func (s *FileStore) PutCredential(c Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := s.mem.GetCredential(c.Username)
+	s.mem.PutCredential(c)
+	if werr := s.save(); werr != nil {
+		// Roll back so memory keeps matching what is on disk
+		if err == nil {
+			s.mem.PutCredential(old)
+		} else {
+			s.mem.DeleteCredential(c.Username)
+		}
+		return werr
+	}
+	return nil
+}
+
+// DeleteCredential removes the credential for username and persists the store
+func (s *FileStore) DeleteCredential(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, err := s.mem.GetCredential(username)
+	if err != nil {
+		return err
+	}
+	s.mem.DeleteCredential(username)
+	if err := s.save(); err != nil {
+		s.mem.PutCredential(old)
+		return err
+	}
+	return nil
+}
+
+// save writes every credential to the file, sorted by username so the
+// file diffs cleanly. The caller must hold s.mu.
+func (s *FileStore) save() error {
+	s.mem.mu.RLock()
+	creds := make([]Credential, 0, len(s.mem.creds))
+	for _, c := range s.mem.creds {
+		creds = append(creds, c)
+	}
+	s.mem.mu.RUnlock()
+	sort.Slice(creds, func(i, j int) bool { return creds[i].Username < creds[j].Username })
+
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	// 0600: password hashes should only be readable by the owner
+	return atomicfile.WriteFile(s.path, data, 0o600)
+}
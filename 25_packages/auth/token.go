@@ -0,0 +1,305 @@
+// Package auth (token.go)
+// This file mints and verifies signed, stateless session tokens.
+// Tokens use the compact JWT layout (header.claims.signature, each part
+// base64url encoded) so they can be inspected with standard tooling, but
+// only the HS256 and EdDSA algorithms are accepted.
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rbrishi/Golang/user"
+)
+
+// Errors returned by TokenManager.Verify
+var (
+	ErrTokenMalformed = errors.New("auth: malformed token")
+	ErrTokenSignature = errors.New("auth: invalid token signature")
+	ErrTokenExpired   = errors.New("auth: token expired")
+	ErrTokenAudience  = errors.New("auth: token not valid for this audience")
+	ErrUnknownKey     = errors.New("auth: unknown signing key")
+	// ErrInvalidKey is returned by the key constructors for key material
+	// of the wrong size
+	ErrInvalidKey = errors.New("auth: invalid signing key")
+)
+
+// MinHMACSecretLen is the shortest secret NewHMACKey accepts: HS256 is only
+// as strong as its secret, and a short one can be guessed offline from any
+// token
+const MinHMACSecretLen = 32
+
+// Signing algorithms, named as in the JWT "alg" header
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Claims is the payload carried by a token
+type Claims struct {
+	ID        string            `json:"jti"`             // Unique token ID, used as Session.ID
+	Subject   string            `json:"sub"`             // Principal.Username
+	UserID    string            `json:"uid,omitempty"`   // Principal.UserID
+	Method    string            `json:"amr,omitempty"`   // Principal.Method
+	MFA       bool              `json:"mfa,omitempty"`   // Principal.MFA
+	Roles     []string          `json:"roles,omitempty"` // Principal.Roles
+	Scopes    []user.Permission `json:"scp,omitempty"`   // Principal.Scopes
+	Audience  string            `json:"aud"`             // Service the token is meant for
+	IssuedAt  int64             `json:"iat"`             // Unix seconds
+	ExpiresAt int64             `json:"exp"`             // Unix seconds
+}
+
+// header is the first part of a token
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// SigningKey is one key in a TokenManager's key set
+// A key that can only verify (an Ed25519 public key on an edge service)
+// has no private half.
+type SigningKey struct {
+	ID  string // Key ID written to the "kid" header
+	Alg string // AlgHS256 or AlgEdDSA
+
+	secret []byte             // HS256 shared secret
+	priv   ed25519.PrivateKey // EdDSA signing key, nil for verify-only keys
+	pub    ed25519.PublicKey  // EdDSA verification key
+}
+
+// NewHMACKey returns an HS256 key
+// The secret must be at least MinHMACSecretLen random bytes.
+func NewHMACKey(id string, secret []byte) (SigningKey, error) {
+	if len(secret) < MinHMACSecretLen {
+		return SigningKey{}, fmt.Errorf("%w: HS256 secret is %d bytes, want at least %d", ErrInvalidKey, len(secret), MinHMACSecretLen)
+	}
+	return SigningKey{ID: id, Alg: AlgHS256, secret: secret}, nil
+}
+
+// NewEd25519Key returns an EdDSA key that can both sign and verify
+func NewEd25519Key(id string, priv ed25519.PrivateKey) (SigningKey, error) {
+	if len(priv) != ed25519.PrivateKeySize {
+		return SigningKey{}, fmt.Errorf("%w: Ed25519 private key is %d bytes, want %d", ErrInvalidKey, len(priv), ed25519.PrivateKeySize)
+	}
+	return SigningKey{ID: id, Alg: AlgEdDSA, priv: priv, pub: priv.Public().(ed25519.PublicKey)}, nil
+}
+
+// NewEd25519VerifyKey returns an EdDSA key that can only verify
+func NewEd25519VerifyKey(id string, pub ed25519.PublicKey) (SigningKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return SigningKey{}, fmt.Errorf("%w: Ed25519 public key is %d bytes, want %d", ErrInvalidKey, len(pub), ed25519.PublicKeySize)
+	}
+	return SigningKey{ID: id, Alg: AlgEdDSA, pub: pub}, nil
+}
+
+// canSign reports whether k holds the material needed to sign
+func (k SigningKey) canSign() bool {
+	return (k.Alg == AlgHS256 && len(k.secret) >= MinHMACSecretLen) || (k.Alg == AlgEdDSA && len(k.priv) == ed25519.PrivateKeySize)
+}
+
+func (k SigningKey) sign(msg []byte) []byte {
+	if k.Alg == AlgEdDSA {
+		return ed25519.Sign(k.priv, msg)
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// verify also refuses keys not made by the constructors, such as a bare
+// SigningKey{Alg: AlgHS256}, whose empty secret anyone could sign with
+func (k SigningKey) verify(msg, sig []byte) bool {
+	if k.Alg == AlgEdDSA {
+		// ed25519.Verify panics on a public key of the wrong size
+		return len(k.pub) == ed25519.PublicKeySize && ed25519.Verify(k.pub, msg, sig)
+	}
+	return len(k.secret) >= MinHMACSecretLen && hmac.Equal(k.sign(msg), sig)
+}
+
+// TokenManager mints and verifies tokens for one audience
+// Keys are looked up by ID, so rotating is: AddKey the new key, make it the
+// signing key, and RemoveKey the old one once its tokens have expired.
+type TokenManager struct {
+	mu      sync.RWMutex          // Protects keys and current
+	keys    map[string]SigningKey // Keyed by SigningKey.ID
+	current string                // ID of the key used by Mint
+
+	audience string
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+// NewTokenManager returns a TokenManager with no keys
+// Minted tokens are valid for ttl and only verify for audience.
+func NewTokenManager(audience string, ttl time.Duration) *TokenManager {
+	return &TokenManager{
+		keys:     make(map[string]SigningKey),
+		audience: audience,
+		ttl:      ttl,
+		now:      time.Now,
+	}
+}
+
+// AddKey adds k to the key set
+// The first key that can sign becomes the signing key.
+func (t *TokenManager) AddKey(k SigningKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keys[k.ID] = k
+	if t.current == "" && k.canSign() {
+		t.current = k.ID
+	}
+}
+
+// SetSigningKey makes the key with the given ID the one used by Mint
+func (t *TokenManager) SetSigningKey(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k, ok := t.keys[id]
+	if !ok || !k.canSign() {
+		return ErrUnknownKey
+	}
+	t.current = id
+	return nil
+}
+
+// RemoveKey drops a key; tokens signed with it stop verifying
+func (t *TokenManager) RemoveKey(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.keys, id)
+	if t.current == id {
+		t.current = ""
+	}
+}
+
+// Mint issues a token for p that expires after the manager's TTL
+// Like SessionManager.Create it refuses principals that still owe a second
+// factor.
+func (t *TokenManager) Mint(p *Principal) (string, error) {
+	if p.MFARequired {
+		return "", ErrMFAIncomplete
+	}
+	jti, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	now := t.now()
+	return t.Sign(Claims{
+		ID:        jti,
+		Subject:   p.Username,
+		UserID:    p.UserID,
+		Method:    p.Method,
+		MFA:       p.MFA,
+		Roles:     p.Roles,
+		Scopes:    p.Scopes,
+		Audience:  t.audience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(t.ttl).Unix(),
+	})
+}
+
+// Sign encodes c and signs it with the current signing key
+func (t *TokenManager) Sign(c Claims) (string, error) {
+	t.mu.RLock()
+	k, ok := t.keys[t.current]
+	t.mu.RUnlock()
+	if !ok {
+		return "", ErrUnknownKey
+	}
+
+	h, err := json.Marshal(header{Alg: k.Alg, Typ: "JWT", Kid: k.ID})
+	if err != nil {
+		return "", err
+	}
+	p, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(h) + "." + enc.EncodeToString(p)
+	return signed + "." + enc.EncodeToString(k.sign([]byte(signed))), nil
+}
+
+// Verify checks the signature, expiry and audience of token and returns its
+// claims
+func (t *TokenManager) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	enc := base64.RawURLEncoding
+	hb, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	var h header
+	if err := json.Unmarshal(hb, &h); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	t.mu.RLock()
+	k, ok := t.keys[h.Kid]
+	t.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	// The algorithm comes from our key, never from the token, so a token
+	// cannot downgrade an EdDSA key to HMAC or to "none"
+	if h.Alg != k.Alg || !k.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrTokenSignature
+	}
+
+	pb, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	var c Claims
+	if err := json.Unmarshal(pb, &c); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	if c.Audience != t.audience {
+		return nil, ErrTokenAudience
+	}
+	if !t.now().Before(time.Unix(c.ExpiresAt, 0)) {
+		return nil, ErrTokenExpired
+	}
+	return &c, nil
+}
+
+// GetSession answers a session lookup from the token alone, without a
+// SessionStore
+// It makes TokenManager a SessionResolver, interchangeable with
+// SessionManager. Stateless sessions cannot be revoked before they expire.
+func (t *TokenManager) GetSession(token string) (*Session, error) {
+	c, err := t.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		ID:        c.ID,
+		Token:     token,
+		Username:  c.Subject,
+		UserID:    c.UserID,
+		Method:    c.Method,
+		MFA:       c.MFA,
+		Roles:     c.Roles,
+		Scopes:    c.Scopes,
+		CreatedAt: time.Unix(c.IssuedAt, 0),
+		LastSeen:  t.now(),
+		ExpiresAt: time.Unix(c.ExpiresAt, 0),
+	}, nil
+}
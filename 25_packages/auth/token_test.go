@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func newTestTokens(t *testing.T) (*TokenManager, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	m := NewTokenManager("api", 15*time.Minute)
+	m.now = clock.now
+	k, err := NewHMACKey("k1", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AddKey(k)
+	return m, clock
+}
+
+func TestTokenVerify(t *testing.T) {
+	enc := base64.RawURLEncoding
+	tests := []struct {
+		name    string
+		mutate  func(m *TokenManager, clock *fakeClock, token string) string
+		wantErr error
+	}{
+		{"valid", func(_ *TokenManager, _ *fakeClock, tok string) string { return tok }, nil},
+		{"expired", func(_ *TokenManager, c *fakeClock, tok string) string {
+			c.advance(15 * time.Minute)
+			return tok
+		}, ErrTokenExpired},
+		{"claims tampered", func(_ *TokenManager, _ *fakeClock, tok string) string {
+			parts := strings.Split(tok, ".")
+			claims, _ := enc.DecodeString(parts[1])
+			parts[1] = enc.EncodeToString([]byte(strings.Replace(string(claims), "alice", "admin", 1)))
+			return strings.Join(parts, ".")
+		}, ErrTokenSignature},
+		{"signature tampered", func(_ *TokenManager, _ *fakeClock, tok string) string {
+			return tok[:len(tok)-2] + "AA"
+		}, ErrTokenSignature},
+		{"alg none", func(_ *TokenManager, _ *fakeClock, tok string) string {
+			parts := strings.Split(tok, ".")
+			parts[0] = enc.EncodeToString([]byte(`{"alg":"none","typ":"JWT","kid":"k1"}`))
+			return parts[0] + "." + parts[1] + "."
+		}, ErrTokenSignature},
+		{"unknown key", func(m *TokenManager, _ *fakeClock, tok string) string {
+			m.RemoveKey("k1")
+			return tok
+		}, ErrUnknownKey},
+		{"malformed", func(_ *TokenManager, _ *fakeClock, _ string) string { return "not.a-token" }, ErrTokenMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, clock := newTestTokens(t)
+			tok, err := m.Mint(&Principal{Username: "alice", Roles: []string{"viewer"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := m.Verify(tt.mutate(m, clock, tok))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (c.Subject != "alice" || c.Audience != "api") {
+				t.Errorf("got claims %+v", c)
+			}
+		})
+	}
+}
+
+func TestTokenAudience(t *testing.T) {
+	m, _ := newTestTokens(t)
+	other := NewTokenManager("billing", time.Hour)
+	k, _ := NewHMACKey("k1", testSecret)
+	other.AddKey(k)
+	tok, err := other.Mint(&Principal{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Verify(tok); !errors.Is(err, ErrTokenAudience) {
+		t.Fatalf("got %v, want ErrTokenAudience", err)
+	}
+}
+
+func TestTokenKeyRotation(t *testing.T) {
+	m, _ := newTestTokens(t)
+	old, _ := m.Mint(&Principal{Username: "alice"})
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	k2, err := NewEd25519Key("k2", priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.AddKey(k2)
+	if err := m.SetSigningKey("k2"); err != nil {
+		t.Fatal(err)
+	}
+	fresh, _ := m.Mint(&Principal{Username: "alice"})
+	for _, tok := range []string{old, fresh} {
+		if _, err := m.Verify(tok); err != nil {
+			t.Errorf("verify during rotation: %v", err)
+		}
+	}
+	m.RemoveKey("k1")
+	if _, err := m.Verify(old); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("old key removed: got %v, want ErrUnknownKey", err)
+	}
+
+	// An edge service holding only the public key verifies but cannot sign
+	edge := NewTokenManager("api", time.Hour)
+	edge.now = m.now
+	vk, err := NewEd25519VerifyKey("k2", priv.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edge.AddKey(vk)
+	if _, err := edge.Verify(fresh); err != nil {
+		t.Errorf("verify-only key: %v", err)
+	}
+	if _, err := edge.Mint(&Principal{Username: "alice"}); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("verify-only key signed: %v", err)
+	}
+}
+
+func TestKeyConstructorsRejectBadMaterial(t *testing.T) {
+	tests := []struct {
+		name string
+		make func() (SigningKey, error)
+	}{
+		{"empty HMAC secret", func() (SigningKey, error) { return NewHMACKey("k", nil) }},
+		{"short HMAC secret", func() (SigningKey, error) { return NewHMACKey("k", []byte("short")) }},
+		{"short public key", func() (SigningKey, error) { return NewEd25519VerifyKey("k", make([]byte, 31)) }},
+		{"short private key", func() (SigningKey, error) { return NewEd25519Key("k", make([]byte, 10)) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.make(); !errors.Is(err, ErrInvalidKey) {
+				t.Fatalf("got %v, want ErrInvalidKey", err)
+			}
+		})
+	}
+
+	// Keys built by hand instead of by the constructors never verify
+	m := NewTokenManager("api", time.Hour)
+	m.AddKey(SigningKey{ID: "bare", Alg: AlgEdDSA})
+	m.AddKey(SigningKey{ID: "empty", Alg: AlgHS256})
+	enc := base64.RawURLEncoding
+	for _, kid := range []string{"bare", "empty"} {
+		alg := AlgEdDSA
+		if kid == "empty" {
+			alg = AlgHS256
+		}
+		h := enc.EncodeToString([]byte(`{"alg":"` + alg + `","typ":"JWT","kid":"` + kid + `"}`))
+		c := enc.EncodeToString([]byte(`{"sub":"admin","aud":"api","exp":9999999999}`))
+		forged := SigningKey{Alg: AlgHS256}.sign([]byte(h + "." + c))
+		if _, err := m.Verify(h + "." + c + "." + enc.EncodeToString(forged)); !errors.Is(err, ErrTokenSignature) {
+			t.Errorf("key %s: got %v, want ErrTokenSignature", kid, err)
+		}
+	}
+}
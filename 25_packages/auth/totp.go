@@ -0,0 +1,315 @@
+// Package auth (totp.go)
+// This file adds an optional second factor: time-based one-time passwords
+// (RFC 6238) as produced by authenticator apps, plus single-use recovery
+// codes for when the phone is lost.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Errors returned by the second-factor functions
+var (
+	ErrMFANotEnrolled     = errors.New("auth: no second factor enrolled")
+	ErrMFAAlreadyEnrolled = errors.New("auth: second factor already enrolled")
+	ErrInvalidOTP         = errors.New("auth: invalid one-time code")
+)
+
+// TOTP holds the parameters of the one-time password algorithm
+// They must match what the authenticator app was provisioned with. A Period
+// under a second or Digits outside 1 to 9 fall back to the RFC 6238
+// defaults of 30 seconds and 6 digits.
+type TOTP struct {
+	Period time.Duration // Length of one time step
+	Digits int           // Number of digits in a code
+	Skew   int           // Accepted steps before and after the current one
+}
+
+// period returns the step length in whole seconds
+func (t TOTP) period() int64 {
+	if t.Period < time.Second {
+		return 30
+	}
+	return int64(t.Period / time.Second)
+}
+
+// digits returns the code length; more than 9 digits would overflow the
+// 31-bit value a code is cut from
+func (t TOTP) digits() int {
+	if t.Digits < 1 || t.Digits > 9 {
+		return 6
+	}
+	return t.Digits
+}
+
+// DefaultTOTP is what virtually every authenticator app expects, with one
+// step of tolerance for clock drift either way
+var DefaultTOTP = TOTP{Period: 30 * time.Second, Digits: 6, Skew: 1}
+
+// b32 is the unpadded base32 alphabet used for secrets and recovery codes
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Code returns the code for secret at time at
+func (t TOTP) Code(secret string, at time.Time) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return t.code(key, t.step(at)), nil
+}
+
+// step returns the RFC 6238 time step counter for at
+func (t TOTP) step(at time.Time) int64 {
+	return at.Unix() / t.period()
+}
+
+// code is the HOTP value (RFC 4226) of key for counter step
+func (t TOTP) code(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation: the low nibble of the last byte picks 4 bytes
+	off := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	digits := t.digits()
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, n%mod)
+}
+
+// match looks for code within Skew steps of at and returns the step it
+// belongs to
+func (t TOTP) match(key []byte, code string, at time.Time) (int64, bool) {
+	now := t.step(at)
+	for d := -int64(t.Skew); d <= int64(t.Skew); d++ {
+		if subtle.ConstantTimeCompare([]byte(t.code(key, now+d)), []byte(code)) == 1 {
+			return now + d, true
+		}
+	}
+	return 0, false
+}
+
+// ProvisioningURI returns the otpauth:// URI that authenticator apps scan
+// from a QR code
+func (t TOTP) ProvisioningURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(t.digits()))
+	v.Set("period", fmt.Sprint(t.period()))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// TOTPEnrollment is everything the user must see once when enrolling
+type TOTPEnrollment struct {
+	Secret        string   // Base32 secret, for manual entry
+	URI           string   // otpauth:// provisioning URI
+	RecoveryCodes []string // Single-use codes; only their hashes are stored
+}
+
+// EnrollTOTP generates a new TOTP secret and recovery codes for username
+// The enrollment stays pending, and logins do not ask for a code, until
+// ConfirmTOTP proves the user's app produces matching codes.
+// Parameters:
+//   - username: user to enroll
+//   - issuer: service name shown in the authenticator app
+func (a *Authenticator) EnrollTOTP(username, issuer string) (*TOTPEnrollment, error) {
+	a.credMu.Lock()
+	defer a.credMu.Unlock()
+	cred, err := a.store.GetCredential(username)
+	if err != nil {
+		return nil, err
+	}
+	if cred.TOTPConfirmed {
+		return nil, ErrMFAAlreadyEnrolled
+	}
+
+	key := make([]byte, 20) // 160 bits, as recommended by RFC 4226
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	secret := b32.EncodeToString(key)
+	codes, hashes, err := newRecoveryCodes(10)
+	if err != nil {
+		return nil, err
+	}
+
+	cred.TOTPSecret = secret
+	cred.TOTPConfirmed = false
+	cred.TOTPLastStep = 0
+	cred.RecoveryCodes = hashes
+	if err := a.store.PutCredential(cred); err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{
+		Secret:        secret,
+		URI:           a.totp.ProvisioningURI(issuer, cred.Username, secret),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// ConfirmTOTP activates a pending enrollment once the user submits a valid
+// code from their app
+func (a *Authenticator) ConfirmTOTP(username, code string) error {
+	a.credMu.Lock()
+	defer a.credMu.Unlock()
+	cred, err := a.store.GetCredential(username)
+	if err != nil {
+		return err
+	}
+	if cred.TOTPSecret == "" {
+		return ErrMFANotEnrolled
+	}
+	if cred.TOTPConfirmed {
+		return ErrMFAAlreadyEnrolled
+	}
+	key, err := b32.DecodeString(cred.TOTPSecret)
+	if err != nil {
+		return err
+	}
+	step, ok := a.totp.match(key, code, a.now())
+	if !ok {
+		return ErrInvalidOTP
+	}
+	cred.TOTPConfirmed = true
+	cred.TOTPLastStep = step
+	return a.store.PutCredential(cred)
+}
+
+// DisableTOTP removes the second factor from username
+func (a *Authenticator) DisableTOTP(username string) error {
+	a.credMu.Lock()
+	defer a.credMu.Unlock()
+	cred, err := a.store.GetCredential(username)
+	if err != nil {
+		return err
+	}
+	cred.TOTPSecret = ""
+	cred.TOTPConfirmed = false
+	cred.TOTPLastStep = 0
+	cred.RecoveryCodes = nil
+	return a.store.PutCredential(cred)
+}
+
+// VerifySecondFactor completes a login for a principal whose MFARequired
+// is set
+// It is VerifySecondFactorFrom with no source address.
+func (a *Authenticator) VerifySecondFactor(p *Principal, code string) (*Principal, error) {
+	return a.VerifySecondFactorFrom("", p, code)
+}
+
+// VerifySecondFactorFrom completes a login for a principal whose
+// MFARequired is set, for a request coming from source
+// code may be a TOTP code or one of the recovery codes; a recovery code is
+// consumed by a successful check, and a TOTP code cannot be replayed. Wrong
+// codes count as failed attempts for the lockout limits of the username
+// and, unless source is "", the source. The returned Principal has MFA set
+// and can be passed to SessionManager.Create.
+func (a *Authenticator) VerifySecondFactorFrom(source string, p *Principal, code string) (*Principal, error) {
+	out, err := a.verifySecondFactor(source, p, code)
+	typ := EventMFASuccess
+	if err != nil {
+		typ = EventMFAFailure
+	}
+	result, detail := outcome(err)
+	recordEvent(a.auditor, Event{Type: typ, User: p.Username, Source: source, Method: p.Method, Outcome: result, Detail: detail})
+	return out, err
+}
+
+// verifySecondFactor does the work of VerifySecondFactorFrom
+func (a *Authenticator) verifySecondFactor(source string, p *Principal, code string) (*Principal, error) {
+	users, sources := a.limiters()
+	if err := a.begin(users, sources, source, p.Username); err != nil {
+		return nil, &LoginError{Username: p.Username, Err: err}
+	}
+	a.credMu.Lock()
+	defer a.credMu.Unlock()
+	cred, err := a.store.GetCredential(p.Username)
+	if err == nil && !cred.TOTPConfirmed {
+		err = ErrMFANotEnrolled
+	}
+	var key []byte
+	if err == nil {
+		key, err = b32.DecodeString(cred.TOTPSecret)
+	}
+	if err != nil {
+		a.release(users, sources, source, p.Username)
+		return nil, &LoginError{Username: p.Username, Err: err}
+	}
+
+	code = strings.TrimSpace(code)
+	if step, ok := a.totp.match(key, code, a.now()); ok && step > cred.TOTPLastStep {
+		cred.TOTPLastStep = step
+	} else if i := findRecoveryCode(cred.RecoveryCodes, code); i >= 0 {
+		cred.RecoveryCodes = append(cred.RecoveryCodes[:i:i], cred.RecoveryCodes[i+1:]...)
+	} else {
+		a.fail(users, sources, source, p.Username)
+		return nil, &LoginError{Username: p.Username, Err: ErrInvalidOTP}
+	}
+	if err := a.store.PutCredential(cred); err != nil {
+		a.release(users, sources, source, p.Username)
+		return nil, &LoginError{Username: p.Username, Err: err}
+	}
+
+	users.Reset(p.Username)
+	if source != "" {
+		sources.Release(source)
+	}
+	out := *p
+	out.MFARequired = false
+	out.MFA = true
+	return &out, nil
+}
+
+// newRecoveryCodes returns n codes formatted as XXXXX-XXXXX together with
+// their hashes
+func newRecoveryCodes(n int) (codes, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s := b32.EncodeToString(b)[:10]
+		codes = append(codes, s[:5]+"-"+s[5:])
+		hashes = append(hashes, hashRecoveryCode(s))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a code ignoring case and dashes
+// The codes are random, so a fast hash is enough to protect them at rest.
+func hashRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.ReplaceAll(code, "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// findRecoveryCode returns the index of code's hash in hashes, or -1
+func findRecoveryCode(hashes []string, code string) int {
+	h := hashRecoveryCode(code)
+	for i, stored := range hashes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(h)) == 1 {
+			return i
+		}
+	}
+	return -1
+}
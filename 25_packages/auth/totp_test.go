@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 appendix B test vectors
+var rfc6238Secret = b32.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	totp := TOTP{Period: 30 * time.Second, Digits: 8}
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		got, err := totp.Code(rfc6238Secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("at %d: got %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPFallsBackToDefaults(t *testing.T) {
+	at := time.Unix(59, 0)
+	want, err := TOTP{Period: 30 * time.Second, Digits: 6}.Code(rfc6238Secret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, totp := range []TOTP{{}, {Period: time.Millisecond, Digits: 10}, {Digits: -1}} {
+		got, err := totp.Code(rfc6238Secret, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%+v: got %s, want the default code %s", totp, got, want)
+		}
+	}
+}
+
+// newTestMFA returns an authenticator whose user alice has a confirmed
+// second factor, a principal waiting for it and the clock codes are read at
+func newTestMFA(t *testing.T) (*Authenticator, *Principal, *fakeClock) {
+	t.Helper()
+	a := newTestAuthenticator(t)
+	clock := &fakeClock{t: time.Unix(1111111111, 0)}
+	a.now = clock.now
+	if _, err := a.EnrollTOTP("alice", "test"); err != nil {
+		t.Fatal(err)
+	}
+	cred, _ := a.store.GetCredential("alice")
+	code, _ := a.totp.Code(cred.TOTPSecret, clock.now())
+	if err := a.ConfirmTOTP("alice", code); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(time.Minute)
+	p, err := a.LogInWithCred("alice", "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.MFARequired {
+		t.Fatal("login did not ask for the second factor")
+	}
+	return a, p, clock
+}
+
+func TestVerifySecondFactor(t *testing.T) {
+	a, p, clock := newTestMFA(t)
+	cred, _ := a.store.GetCredential("alice")
+	code, _ := a.totp.Code(cred.TOTPSecret, clock.now())
+
+	out, err := a.VerifySecondFactor(p, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.MFA || out.MFARequired {
+		t.Errorf("got principal %+v", out)
+	}
+	if _, err := a.VerifySecondFactor(p, code); !errors.Is(err, ErrInvalidOTP) {
+		t.Errorf("replayed code: got %v, want ErrInvalidOTP", err)
+	}
+}
+
+func TestWrongCodesCountAgainstSource(t *testing.T) {
+	a, p, _ := newTestMFA(t)
+	a.SetLockoutPolicies(LockoutPolicy{}, LockoutPolicy{MaxFailures: 2, LockoutDuration: time.Minute})
+	for range 2 {
+		if _, err := a.VerifySecondFactorFrom("10.0.0.1", p, "wrong"); !errors.Is(err, ErrInvalidOTP) {
+			t.Fatalf("got %v, want ErrInvalidOTP", err)
+		}
+	}
+	if _, err := a.VerifySecondFactorFrom("10.0.0.1", p, "wrong"); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("got %v, want the source locked out", err)
+	}
+}
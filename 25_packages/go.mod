@@ -2,11 +2,14 @@ module github.com/rbrishi/Golang
 
 go 1.24.0
 
-require github.com/fatih/color v1.18.0
+require (
+	github.com/fatih/color v1.18.0
+	github.com/mattn/go-isatty v0.0.20
+	golang.org/x/crypto v0.27.0
+)
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
 	golang.org/x/sys v0.25.0 // indirect
 
 //indirect -> means not in use
@@ -0,0 +1,49 @@
+// Package atomicfile writes files so that readers never observe a partially
+// written file, and locks them so that only one process writes at a time.
+// It is internal to this module: only packages rooted at
+// github.com/rbrishi/Golang can import it.
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFile writes data to a temporary file in the same directory as path
+// and then renames it over path
+// Rename within one directory is atomic on POSIX systems, so after a crash
+// path holds either the old contents or the new ones, never a mix. The
+// directory is synced after the rename so the rename itself is durable.
+// Parameters:
+//   - path: destination file
+//   - data: complete new contents
+//   - perm: permission bits for the new file
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// Remove the temp file on any failure; after a successful rename
+	// this is a harmless no-op error that we ignore.
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// Flush the data to disk before the rename makes it visible
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
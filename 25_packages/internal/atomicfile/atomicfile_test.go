@@ -0,0 +1,94 @@
+package atomicfile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestWriteFileReplacesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data")
+	older := bytes.Repeat([]byte("a"), 1<<16)
+	newer := bytes.Repeat([]byte("b"), 1<<17)
+	if err := WriteFile(path, older, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reader racing the writes must only ever see one whole version
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Errorf("read during replace: %v", err)
+				return
+			}
+			if !bytes.Equal(got, older) && !bytes.Equal(got, newer) {
+				t.Errorf("read a mix of versions, %d bytes", len(got))
+				return
+			}
+		}
+	}()
+	for i := range 50 {
+		data := older
+		if i%2 == 0 {
+			data = newer
+		}
+		if err := WriteFile(path, data, 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(older)) {
+		t.Errorf("got %d bytes, want the last write's %d", info.Size(), len(older))
+	}
+	if perm := info.Mode().Perm(); perm != 0o640 && os.PathSeparator == '/' {
+		t.Errorf("got mode %v, want 0640", perm)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestWriteFileFailureKeepsOld(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data")
+	if err := WriteFile(path, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// A directory cannot be renamed over, so the replace fails after the
+	// temporary file is written
+	if err := WriteFile(dir, []byte("new"), 0o600); err == nil {
+		t.Fatal("replacing a directory succeeded")
+	}
+	if got, _ := os.ReadFile(path); string(got) != "old" {
+		t.Errorf("got %q, want old", got)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(dir))
+	for _, e := range entries {
+		if e.Name() != filepath.Base(dir) {
+			t.Errorf("temporary file %s left behind", e.Name())
+		}
+	}
+}
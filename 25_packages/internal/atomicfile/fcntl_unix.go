@@ -0,0 +1,24 @@
+//go:build aix || (solaris && !illumos)
+
+package atomicfile
+
+import "syscall"
+
+// lockFD takes an exclusive fcntl lock on all of fd without blocking, as
+// these systems have no flock
+// Unlike a flock, the lock belongs to the process, so Lock keeps other
+// processes out here but not a second FileStore in this one.
+func lockFD(fd int) error {
+	lk := syscall.Flock_t{Type: syscall.F_WRLCK}
+	err := syscall.FcntlFlock(uintptr(fd), syscall.F_SETLK, &lk)
+	if err == syscall.EAGAIN || err == syscall.EACCES {
+		return syscall.EWOULDBLOCK
+	}
+	return err
+}
+
+// unlockFD drops fd's fcntl lock
+func unlockFD(fd int) error {
+	lk := syscall.Flock_t{Type: syscall.F_UNLCK}
+	return syscall.FcntlFlock(uintptr(fd), syscall.F_SETLK, &lk)
+}
@@ -0,0 +1,15 @@
+//go:build unix && !aix && !(solaris && !illumos)
+
+package atomicfile
+
+import "syscall"
+
+// lockFD takes an exclusive flock on fd without blocking
+func lockFD(fd int) error {
+	return syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// unlockFD drops fd's flock
+func unlockFD(fd int) error {
+	return syscall.Flock(fd, syscall.LOCK_UN)
+}
@@ -0,0 +1,62 @@
+package atomicfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrLocked is returned by Lock when another holder still has the lock
+// once the timeout is up
+var ErrLocked = errors.New("atomicfile: locked by another process")
+
+// FileLock is an exclusive advisory lock taken with Lock
+type FileLock struct {
+	f *os.File
+}
+
+// Lock takes the exclusive advisory lock for path, waiting up to timeout
+// The lock lives on path+".lock" rather than on path itself, because
+// WriteFile replaces path with a new file and a lock on the old one would
+// guard nothing. It binds only code that calls Lock too: a second FileStore
+// opened on the same file, in this process or another, but not a text
+// editor. On AIX and Solaris, which lack flock, only other processes are
+// kept out. Where the standard library offers no locking at all, Lock fails
+// with errors.ErrUnsupported. A timeout of 0 tries once.
+// Parameters:
+//   - path: file to be guarded
+//   - timeout: how long to wait for the current holder to let go
+func Lock(path string, timeout time.Duration) (*FileLock, error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		held, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("atomicfile: lock %s: %w", path, err)
+		}
+		if !held {
+			return &FileLock{f: f}, nil
+		}
+		if !time.Now().Before(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%w: %s", ErrLocked, path)
+		}
+		time.Sleep(min(50*time.Millisecond, time.Until(deadline)))
+	}
+}
+
+// Unlock releases the lock
+// The .lock file stays: removing it could let a waiter lock the old file
+// while a newcomer creates and locks a new one.
+func (l *FileLock) Unlock() error {
+	err := unlock(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package atomicfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// skipWithoutFileLocks skips tests that need a lock to keep out other
+// holders in this process, which fcntl locks do not
+func skipWithoutFileLocks(t *testing.T) {
+	if runtime.GOOS == "aix" || runtime.GOOS == "solaris" {
+		t.Skip("fcntl locks do not exclude holders in one process")
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	skipWithoutFileLocks(t)
+	path := filepath.Join(t.TempDir(), "data")
+	held, err := Lock(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Lock(path, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Lock with no timeout: got %v, want ErrLocked", err)
+	}
+	const timeout = 150 * time.Millisecond
+	start := time.Now()
+	if _, err := Lock(path, timeout); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Lock: got %v, want ErrLocked", err)
+	}
+	if waited := time.Since(start); waited < timeout {
+		t.Errorf("gave up after %v, before the %v timeout", waited, timeout)
+	}
+
+	// A waiter gets the lock once the holder lets go
+	time.AfterFunc(50*time.Millisecond, func() { held.Unlock() })
+	l, err := Lock(path, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Lock after Unlock: %v", err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockExcludesOtherHolders(t *testing.T) {
+	skipWithoutFileLocks(t)
+	path := filepath.Join(t.TempDir(), "counter")
+	if err := WriteFile(path, []byte("0"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	const workers = 8
+	var inside atomic.Int32
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, err := Lock(path, 10*time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer l.Unlock()
+			if n := inside.Add(1); n != 1 {
+				t.Errorf("%d holders at once", n)
+			}
+			defer inside.Add(-1)
+
+			// Read-modify-write loses updates unless the lock serializes it
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			n, _ := strconv.Atoi(string(b))
+			time.Sleep(time.Millisecond)
+			if err := WriteFile(path, []byte(strconv.Itoa(n+1)), 0o600); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if b, _ := os.ReadFile(path); string(b) != strconv.Itoa(workers) {
+		t.Errorf("counter is %s, want %d", b, workers)
+	}
+}
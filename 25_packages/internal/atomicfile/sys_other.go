@@ -0,0 +1,27 @@
+//go:build !unix && !windows
+
+package atomicfile
+
+import (
+	"errors"
+	"os"
+)
+
+// tryLock fails: the standard library offers no file locking here, and
+// a lock that keeps nobody out would let two writers fork a file
+func tryLock(f *os.File) (held bool, err error) {
+	return false, errors.ErrUnsupported
+}
+
+// unlock has nothing to release
+func unlock(f *os.File) error { return nil }
+
+// syncDir syncs dir where that is possible, ignoring systems that refuse
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	d.Sync()
+	return d.Close()
+}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package atomicfile
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLock locks f without blocking and reports whether someone else holds
+// it instead
+// The lock is a flock, or an fcntl lock where there is no flock (see
+// flock_unix.go and fcntl_unix.go).
+func tryLock(f *os.File) (held bool, err error) {
+	for {
+		err = lockFD(int(f.Fd()))
+		switch err {
+		case nil:
+			return false, nil
+		case syscall.EWOULDBLOCK:
+			return true, nil
+		case syscall.EINTR:
+			continue
+		}
+		return false, err
+	}
+}
+
+// unlock drops f's lock
+func unlock(f *os.File) error {
+	return unlockFD(int(f.Fd()))
+}
+
+// syncDir makes the entries of dir, such as a rename into it, durable
+// Some file systems cannot sync a directory and say so with EINVAL; there
+// is nothing more to do on those.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	d.Close()
+	if errors.Is(err, syscall.EINVAL) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package atomicfile
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// package syscall has no LockFileEx, so it is loaded from kernel32
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33 // Someone else holds the range
+)
+
+// tryLock locks the first byte of f without blocking and reports whether
+// someone else holds it instead
+func tryLock(f *os.File) (held bool, err error) {
+	var ol syscall.Overlapped
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileFailImmediately|lockfileExclusiveLock, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return false, nil
+	}
+	if err == errorLockViolation {
+		return true, nil
+	}
+	return false, err
+}
+
+// unlock releases the byte tryLock locked
+func unlock(f *os.File) error {
+	var ol syscall.Overlapped
+	if r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&ol))); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// syncDir does nothing: Windows cannot sync a directory, and NTFS makes the
+// rename durable itself
+func syncDir(dir string) error {
+	return nil
+}
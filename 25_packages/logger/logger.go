@@ -0,0 +1,247 @@
+// Package logger is a small leveled logger built on log/slog
+// On a terminal each level gets its own color through fatih/color; anywhere
+// else the output is plain text or JSON lines. Loggers are ordinary
+// *slog.Logger values, so fields are added the slog way:
+//
+//	log := logger.New(os.Stderr, nil)
+//	log.Info("user signed up", "user", u.Email, "id", u.ID)
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+)
+
+// Levels, the same values slog uses
+const (
+	LevelDebug = slog.LevelDebug
+	LevelInfo  = slog.LevelInfo
+	LevelWarn  = slog.LevelWarn
+	LevelError = slog.LevelError
+)
+
+// Format selects how records are written
+type Format int
+
+// Output formats
+const (
+	FormatText Format = iota // key=value pairs, colored on a terminal
+	FormatJSON               // One JSON object per line, never colored
+)
+
+// Options configure a logger; the zero value logs Info and above as text
+type Options struct {
+	Level  slog.Leveler // Minimum level; nil means LevelInfo
+	Format Format
+	// Color forces colors on (true) or off (false). nil colors text output
+	// only when the writer is a terminal and NO_COLOR is not set.
+	Color *bool
+}
+
+// New returns a logger writing to w
+func New(w io.Writer, opts *Options) *slog.Logger {
+	return slog.New(NewHandler(w, opts))
+}
+
+// NewHandler returns the slog.Handler behind New, for use with slog.New or
+// slog.SetDefault
+func NewHandler(w io.Writer, opts *Options) slog.Handler {
+	if opts == nil {
+		opts = &Options{}
+	}
+	level := opts.Level
+	if level == nil {
+		level = LevelInfo
+	}
+	if opts.Format == FormatJSON {
+		return slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	}
+	useColor := IsTerminal(w) && os.Getenv("NO_COLOR") == ""
+	if opts.Color != nil {
+		useColor = *opts.Color
+	}
+	return &TextHandler{w: w, mu: new(sync.Mutex), level: level, color: useColor}
+}
+
+// IsTerminal reports whether w is a terminal
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// levelColors are the colors used for each level's label
+var levelColors = map[slog.Level]*color.Color{
+	LevelDebug: color.New(color.FgHiBlack),
+	LevelInfo:  color.New(color.FgCyan),
+	LevelWarn:  color.New(color.FgYellow),
+	LevelError: color.New(color.FgRed, color.Bold),
+}
+
+// keyColor dims field names so messages stand out
+var keyColor = color.New(color.Faint)
+
+func init() {
+	// fatih/color decides once, for stdout, whether to color. TextHandler
+	// makes that decision per writer, so its colors are always enabled and
+	// only used when the handler wants them.
+	for _, c := range levelColors {
+		c.EnableColor()
+	}
+	keyColor.EnableColor()
+}
+
+// TextHandler is a slog.Handler writing one line per record:
+//
+//	2024-05-01T12:00:00.000Z INFO  user signed up user=a@b.com id=42
+type TextHandler struct {
+	mu    *sync.Mutex // Shared with derived handlers; keeps lines whole
+	w     io.Writer
+	level slog.Leveler
+	color bool
+
+	group string // Prefix for keys, e.g. "req." inside WithGroup("req")
+	attrs string // Fields added with WithAttrs, already formatted
+}
+
+// Enabled reports whether records at level are logged
+func (h *TextHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle writes r as one line
+func (h *TextHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	if !r.Time.IsZero() {
+		b.WriteString(r.Time.UTC().Format("2006-01-02T15:04:05.000Z"))
+		b.WriteByte(' ')
+	}
+	label := fmt.Sprintf("%-5s", r.Level.String())
+	if h.color {
+		label = levelColor(r.Level).Sprint(label)
+	}
+	b.WriteString(label)
+	b.WriteByte(' ')
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		h.appendAttr(&b, h.group, a)
+		return true
+	})
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// WithAttrs returns a handler that adds attrs to every record
+func (h *TextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	for _, a := range attrs {
+		h.appendAttr(&b, h.group, a)
+	}
+	h2 := *h
+	h2.attrs += b.String()
+	return &h2
+}
+
+// WithGroup returns a handler that puts later fields under name
+func (h *TextHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.group += name + "."
+	return &h2
+}
+
+// appendAttr writes " key=value" for a, flattening groups into dotted keys
+func (h *TextHandler) appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			h.appendAttr(b, prefix, ga)
+		}
+		return
+	}
+	key := prefix + a.Key + "="
+	if h.color {
+		key = keyColor.Sprint(key)
+	}
+	b.WriteByte(' ')
+	b.WriteString(key)
+	b.WriteString(formatValue(a.Value))
+}
+
+// formatValue renders v, quoting it when it would not read back as one token
+func formatValue(v slog.Value) string {
+	var s string
+	switch v.Kind() {
+	case slog.KindTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			s = err.Error()
+		} else {
+			s = v.String()
+		}
+	default:
+		s = v.String()
+	}
+	if needsQuote(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// needsQuote reports whether s is empty or has spaces, quotes, '=' or
+// control characters
+func needsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// levelColor returns the color for level, using the nearest level below it
+// for custom levels such as LevelInfo+2
+func levelColor(level slog.Level) *color.Color {
+	switch {
+	case level >= LevelError:
+		return levelColors[LevelError]
+	case level >= LevelWarn:
+		return levelColors[LevelWarn]
+	case level >= LevelInfo:
+		return levelColors[LevelInfo]
+	default:
+		return levelColors[LevelDebug]
+	}
+}
@@ -0,0 +1,157 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/slogtest"
+	"time"
+)
+
+// splitFields splits a line at spaces outside quoted values
+func splitFields(t *testing.T, line string) []string {
+	t.Helper()
+	var fields []string
+	for s := strings.TrimSpace(line); s != ""; s = strings.TrimLeft(s, " ") {
+		i := 0
+		for i < len(s) && s[i] != ' ' {
+			if s[i] != '"' {
+				i++
+				continue
+			}
+			q, err := strconv.QuotedPrefix(s[i:])
+			if err != nil {
+				t.Fatalf("bad quoting in %q: %v", line, err)
+			}
+			i += len(q)
+		}
+		fields = append(fields, s[:i])
+		s = s[i:]
+	}
+	return fields
+}
+
+// parseLine turns one line of TextHandler output back into the map
+// slogtest expects, with dotted keys as nested groups
+func parseLine(t *testing.T, line string) map[string]any {
+	t.Helper()
+	fields := splitFields(t, line)
+	m := make(map[string]any)
+	if ts, err := time.Parse("2006-01-02T15:04:05.000Z", fields[0]); err == nil {
+		m[slog.TimeKey] = ts
+		fields = fields[1:]
+	}
+	m[slog.LevelKey], m[slog.MessageKey] = fields[0], fields[1]
+	for _, f := range fields[2:] {
+		key, val, ok := strings.Cut(f, "=")
+		if !ok {
+			t.Fatalf("field %q in %q is not key=value", f, line)
+		}
+		if strings.HasPrefix(val, `"`) {
+			var err error
+			if val, err = strconv.Unquote(val); err != nil {
+				t.Fatal(err)
+			}
+		}
+		group := m
+		path := strings.Split(key, ".")
+		for _, g := range path[:len(path)-1] {
+			sub, ok := group[g].(map[string]any)
+			if !ok {
+				sub = make(map[string]any)
+				group[g] = sub
+			}
+			group = sub
+		}
+		group[path[len(path)-1]] = val
+	}
+	return m
+}
+
+func TestTextHandlerConforms(t *testing.T) {
+	var buf bytes.Buffer
+	off := false
+	h := NewHandler(&buf, &Options{Level: LevelDebug, Color: &off})
+	results := func() []map[string]any {
+		var ms []map[string]any
+		for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+			ms = append(ms, parseLine(t, line))
+		}
+		return ms
+	}
+	if err := slogtest.TestHandler(h, results); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTextHandlerQuoting(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{"plain", "k=plain"},
+		{"two words", `k="two words"`},
+		{"", `k=""`},
+		{`say "hi"`, `k="say \"hi\""`},
+		{"a=b", `k="a=b"`},
+		{"line\nbreak", `k="line\nbreak"`},
+		{"tab\there", `k="tab\there"`},
+		{42, "k=42"},
+		{true, "k=true"},
+		{1500 * time.Millisecond, "k=1.5s"},
+		{errors.New("disk full"), `k="disk full"`},
+		{time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600)), "k=2024-05-01T10:00:00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			var buf bytes.Buffer
+			off := false
+			New(&buf, &Options{Color: &off}).Info("m", "k", tt.value)
+			got := strings.TrimSpace(buf.String())
+			if !strings.HasSuffix(got, " m "+tt.want) {
+				t.Errorf("got %q, want it to end in %q", got, " m "+tt.want)
+			}
+		})
+	}
+}
+
+func TestTextHandlerColor(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		color bool
+		want  string // Start of the level label
+	}{
+		{LevelDebug, false, "DEBUG"},
+		{LevelError, false, "ERROR"},
+		{LevelDebug, true, "\x1b[90mDEBUG"},
+		{LevelInfo, true, "\x1b[36mINFO"},
+		{LevelWarn, true, "\x1b[33mWARN"},
+		{LevelError, true, "\x1b[31;1mERROR"},
+		{LevelInfo + 2, true, "\x1b[36mINFO+2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level.String()+"/"+strconv.FormatBool(tt.color), func(t *testing.T) {
+			var buf bytes.Buffer
+			color := tt.color
+			New(&buf, &Options{Level: LevelDebug, Color: &color}).Log(t.Context(), tt.level, "m", "k", "v")
+			fields := strings.SplitN(buf.String(), " ", 2) // Time, then the rest
+			if !strings.HasPrefix(fields[1], tt.want) {
+				t.Errorf("got %q, want the label to start with %q", fields[1], tt.want)
+			}
+			if hasEscape := strings.Contains(buf.String(), "\x1b["); hasEscape != tt.color {
+				t.Errorf("escape codes present: %v, want %v in %q", hasEscape, tt.color, buf.String())
+			}
+		})
+	}
+}
+
+func TestNoColorForPipes(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, nil).Warn("m")
+	if strings.Contains(buf.String(), "\x1b[") {
+		t.Errorf("colored output to a buffer: %q", buf.String())
+	}
+}
@@ -0,0 +1,75 @@
+// Package mailer sends email on behalf of the other packages
+// Real deployments plug in an SMTP or API-backed Mailer; the stand-ins here
+// print messages or drop them in a directory so the flows that send mail can
+// be exercised without a mail server.
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is implemented by anything that can deliver a Message
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// format renders msg in RFC 5322 layout
+func format(msg Message, date time.Time) string {
+	return fmt.Sprintf("Date: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		date.Format(time.RFC1123Z), msg.To, msg.Subject,
+		strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+}
+
+// WriterMailer writes every message to an io.Writer such as os.Stdout
+type WriterMailer struct {
+	mu sync.Mutex // Keeps concurrent messages from interleaving
+	w  io.Writer
+}
+
+// NewWriterMailer returns a Mailer that writes messages to w
+func NewWriterMailer(w io.Writer) *WriterMailer {
+	return &WriterMailer{w: w}
+}
+
+// Send writes msg followed by a separator line
+func (m *WriterMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := io.WriteString(m.w, format(msg, time.Now())+"----\r\n")
+	return err
+}
+
+// DirMailer saves every message as a .eml file in a directory, where tests
+// and developers can open it
+type DirMailer struct {
+	Dir string
+}
+
+// Send writes msg to a new file in m.Dir
+func (m DirMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	now := time.Now()
+	f, err := os.CreateTemp(m.Dir, now.Format("20060102-150405")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(format(msg, now)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
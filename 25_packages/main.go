@@ -7,13 +7,25 @@ package main
 // 2. External package imports (github.com/fatih/color)
 // 3. Local module imports (auth and user packages)
 import (
-	"fmt" // Standard library for formatting and printing
+	"crypto/rand" // Standard library source of secure random bytes
+	"errors"      // Standard library for inspecting wrapped errors
+	"flag"        // Standard library for command-line flags
+	"fmt"         // Standard library for formatting and printing
+	"log/slog"    // Standard library structured logging
+	"net"         // Standard library for splitting host and port
+	"net/http"    // Standard library HTTP server
+	"os"          // Standard library access to stdout
+	"time"        // Standard library for dates and durations
 
 	"github.com/fatih/color" // Third-party package for colored console output
 
 	// Local module imports using the module path defined in go.mod
-	"github.com/rbrishi/Golang/auth" // Local authentication package
-	"github.com/rbrishi/Golang/user" // Local user package
+	"github.com/rbrishi/Golang/auth"         // Local authentication package
+	"github.com/rbrishi/Golang/auth/fakeidp" // Local fake OAuth identity provider
+	"github.com/rbrishi/Golang/logger"       // Local leveled logging package
+	"github.com/rbrishi/Golang/mailer"       // Local mail sending package
+	"github.com/rbrishi/Golang/server"       // Local HTTP API package
+	"github.com/rbrishi/Golang/user"         // Local user package
 )
 
 // Go Modules System:
@@ -30,24 +42,205 @@ import (
 // 2. Creating and using struct types from local packages
 // 3. Using third-party package functionality
 func main() {
+	// Pass -serve :8080 to run the HTTP API instead of the walkthrough
+	serve := flag.String("serve", "", "serve the HTTP login API on this address, e.g. :8080")
+	baseURL := flag.String("base-url", "", "public URL of the API used in mailed links and OAuth redirects (default http://localhost and the -serve port)")
+	insecure := flag.Bool("insecure-cookies", false, "send the session cookie over plain HTTP (development only)")
+	auditPath := flag.String("audit", "", "append authentication events to this audit log")
+	auditKey := flag.String("audit-key", "", "file holding the audit log's HMAC key, created if missing; keep it away from the log")
+	logJSON := flag.Bool("log-json", false, "write server logs as JSON lines")
+	verbose := flag.Bool("v", false, "log debug messages")
+	fakeIdP := flag.Bool("fake-idp", false, "offer /oauth/login through an in-process fake identity provider")
+	flag.Parse()
+
+	// Creating an instance of User struct from user package
+	// user.New validates the fields and assigns an ID and timestamps
+	u, err := user.New("rishabh", "brishabh@Gmail.com")
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+	fmt.Println(u.ID, u.Name, u.Email)
+
+	// Roles bundle permissions; admin inherits everything viewer may do
+	user.DefaultRoles.Define(user.Role{Name: "viewer", Permissions: []user.Permission{"users:read"}})
+	user.DefaultRoles.Define(user.Role{Name: "admin", Inherits: []string{"viewer"}, Permissions: []user.Permission{"users:write"}})
+	u.AddRole("viewer")
+
+	// Keep users in a repository; the email must be unique
+	users := user.NewMemoryRepository()
+	if err := users.Create(u); err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	auth.Default.SetUserRepository(users)
+
+	// Register a demo credential linked to the user; only its Argon2id
+	// hash is stored. Users log in with their email address.
+	if err := auth.Default.SetPassword(u.Email, u.ID, "password123"); err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	if *serve != "" {
+		// Leveled logs on stderr: colored on a terminal, plain or JSON otherwise
+		opts := &logger.Options{Level: logger.LevelInfo}
+		if *logJSON {
+			opts.Format = logger.FormatJSON
+		}
+		if *verbose {
+			opts.Level = logger.LevelDebug
+		}
+		log := logger.New(os.Stderr, opts)
+		slog.SetDefault(log)
+
+		if *auditPath != "" {
+			key, err := loadOrCreateKey(*auditKey)
+			if err != nil {
+				log.Error("cannot load audit key", "path", *auditKey, "err", err)
+				return
+			}
+			audit, err := auth.OpenAuditLog(*auditPath, key)
+			if err != nil {
+				log.Error("cannot open audit log", "path", *auditPath, "err", err)
+				return
+			}
+			log.Debug("audit log opened", "path", *auditPath)
+			defer audit.Close()
+			auth.Default.SetAuditor(audit)
+			auth.DefaultSessions.SetAuditor(audit)
+		}
+		// Links must name a host; ":8080" alone listens on every interface
+		if *baseURL == "" {
+			host, port, err := net.SplitHostPort(*serve)
+			if err != nil {
+				log.Error("invalid -serve address", "addr", *serve, "err", err)
+				return
+			}
+			if host == "" || host == "0.0.0.0" || host == "::" {
+				host = "localhost"
+			}
+			*baseURL = "http://" + net.JoinHostPort(host, port)
+		}
+		api := server.New(auth.Default, auth.DefaultSessions, users)
+		api.InsecureCookies = *insecure
+		// Verification and reset mails are printed instead of sent
+		recovery := auth.NewRecovery(auth.Default, auth.DefaultSessions, users, mailer.NewWriterMailer(os.Stdout))
+		recovery.BaseURL = *baseURL
+		api.EnableRecovery(recovery)
+
+		// Print a key for u so the API can be tried with curl -H "Authorization: Bearer ..."
+		// The full key goes to stdout once; logs only ever get its prefix.
+		keys := auth.NewAPIKeys(auth.NewMemoryAPIKeyStore(), auth.Default, users)
+		if key, k, err := keys.Issue(u, "demo", []user.Permission{"users:read"}, 24*time.Hour); err == nil {
+			fmt.Println("Demo API key:", key)
+			log.Info("issued demo API key", "user", u.Email, "prefix", k.Prefix)
+		}
+		api.EnableAPIKeys(keys)
+
+		if *fakeIdP {
+			// The fake provider approves every login as this identity
+			idp := fakeidp.New("demo-client", "demo-secret")
+			defer idp.Close()
+			idp.SignIn(auth.Identity{Subject: "1001", Email: "oauth.user@example.com", EmailVerified: true, Name: "OAuth User"})
+			api.EnableOAuth(auth.NewOAuthProvider(idp.Config(*baseURL+"/oauth/callback"), auth.Default, users))
+			log.Info("fake identity provider running", "url", idp.URL())
+		}
+		log.Info("serving the login API", "addr", *serve)
+		if err := http.ListenAndServe(*serve, api); err != nil {
+			log.Error("server stopped", "err", err)
+		}
+		return
+	}
+
 	// Using exported function from auth package
 	// Note: LogInWithCred is capitalized, making it exported (public)
-	auth.LogInWithCred("rishabh", "password123")
-	fmt.Println(auth.GetSession())
+	principal, err := auth.LogInWithCred(u.Email, "password123")
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+	fmt.Println("Logged in as:", principal.Username)
 
-	// Creating an instance of User struct from user package
-	// Demonstrates struct initialization with field names
-	user := user.User{
-		Name: "rishabh",
-		Email: "brishabh@gmail.com",
+	// Turn the login into a session; the client keeps only the opaque token
+	session, err := auth.DefaultSessions.Create(principal)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+	if s, err := auth.GetSession(session.Token); err == nil {
+		fmt.Println("Session for:", s.Username, "expires at", s.ExpiresAt.Format(time.RFC3339))
+		// Authorization: the session carries the roles held at login
+		fmt.Println("May read users:", auth.Authorize(s, "users:read") == nil)
+		fmt.Println("May write users:", auth.Authorize(s, "users:write") == nil)
+	}
+
+	// The same principal as a signed, stateless token that any service
+	// holding the key can verify without the session store
+	tokens := auth.NewTokenManager("demo", 15*time.Minute)
+	// The key constructors reject secrets too short to be safe
+	key, err := auth.NewHMACKey("k1", []byte("demo-secret-please-use-32-random-bytes"))
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+	tokens.AddKey(key)
+	if token, err := tokens.Mint(principal); err == nil {
+		if s, err := tokens.GetSession(token); err == nil {
+			fmt.Println("Token for:", s.Username, "expires at", s.ExpiresAt.Format(time.RFC3339))
+		}
+	}
+
+	// Machine clients get an API key instead of a password; it acts as u
+	// but only within its scopes
+	keys := auth.NewAPIKeys(auth.NewMemoryAPIKeyStore(), auth.Default, users)
+	if key, k, err := keys.Issue(u, "reporting job", []user.Permission{"users:read"}, 0); err == nil {
+		fmt.Println("Issued API key", k.Prefix)
+		if s, err := keys.GetSession(key); err == nil {
+			fmt.Println("Key may read users:", auth.Authorize(s, "users:read") == nil)
+		}
+		keys.Revoke(k.Prefix)
+		if _, err := keys.Verify(key); errors.Is(err, auth.ErrAPIKeyRevoked) {
+			fmt.Println("Revoked key rejected:", err)
+		}
+	}
+
+	// A wrong password yields a typed error we can inspect with errors.Is
+	if _, err := auth.LogInWithCred(u.Email, "wrong"); errors.Is(err, auth.ErrInvalidCredentials) {
+		fmt.Println("Rejected:", err)
 	}
-	fmt.Println(user)
 
 	// Using third-party package (color) to print colored text
 	// Demonstrates the use of an external package
-	color.Red(user.Email)
+	color.Red(u.Email)
 }
 
+// loadOrCreateKey reads the secret key in path, first writing 32 random
+// bytes there if the file does not exist
+func loadOrCreateKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("-audit needs -audit-key")
+	}
+	key, err := os.ReadFile(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return key, err
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	// O_EXCL: never overwrite a key that appeared meanwhile
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return key, f.Close()
+}
 
 // Go Visibility Rules:
 // 1. Exported (Public) names:
@@ -58,7 +251,7 @@ func main() {
 // 2. Unexported (Private) names:
 //    - Start with a lower case letter
 //    - Only accessible within the same package
-//    Example: forPrivate
+//    Example: normalizeUsername
 
 // Package Management in Go:
 // 1. Finding Packages:
@@ -75,5 +268,3 @@ func main() {
 //      * Removes unused dependencies
 //      * Updates go.mod and go.sum files
 //      * Ensures dependency graph is complete and accurate
-
-
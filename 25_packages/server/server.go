@@ -0,0 +1,629 @@
+// Package server exposes the auth and user packages over HTTP
+// It demonstrates how the library packages in this module compose into a
+// small JSON API:
+//
+//	POST /login   {"username", "password", "code"?} -> sets the session cookie
+//	POST /logout                                    -> revokes the session
+//	GET  /me                                        -> the current session's user
+//	POST /users   {"name", "email", "password"}     -> signs up a new user
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rbrishi/Golang/auth"
+	"github.com/rbrishi/Golang/user"
+)
+
+// DefaultCookieName is the name of the session cookie
+const DefaultCookieName = "session"
+
+// Server is an http.Handler serving the login API
+type Server struct {
+	auth     *auth.Authenticator
+	sessions *auth.SessionManager
+	users    user.Repository
+	recovery *auth.Recovery      // Optional, see EnableRecovery
+	oauth    *auth.OAuthProvider // Optional, see EnableOAuth
+	apiKeys  *auth.APIKeys       // Optional, see EnableAPIKeys
+	mux      *http.ServeMux
+
+	mfaMu      sync.Mutex            // Protects mfaPending
+	mfaPending map[string]pendingMFA // OAuth logins waiting for a code, keyed by cookie value
+
+	// CookieName is the name of the session cookie
+	CookieName string
+	// InsecureCookies drops the Secure flag from the session cookie so it
+	// is sent over plain HTTP. Only use this for local development.
+	InsecureCookies bool
+}
+
+// New returns a Server that logs users in with a, keeps their sessions in
+// sessions and stores sign-ups in users
+// Users log in with their email address as the username.
+func New(a *auth.Authenticator, sessions *auth.SessionManager, users user.Repository) *Server {
+	s := &Server{
+		auth:       a,
+		sessions:   sessions,
+		users:      users,
+		mux:        http.NewServeMux(),
+		CookieName: DefaultCookieName,
+	}
+	s.mux.HandleFunc("POST /login", s.handleLogin)
+	s.mux.HandleFunc("POST /logout", s.handleLogout)
+	s.mux.HandleFunc("GET /me", s.handleMe)
+	s.mux.HandleFunc("POST /users", s.handleCreateUser)
+	return s
+}
+
+// EnableRecovery adds the email verification and password reset endpoints
+// and makes sign-up send a verification mail:
+//
+//	GET  /verify-email?token=...                        -> page confirming the address
+//	POST /verify-email            {"token"}             -> marks the email verified
+//	POST /password-reset          {"email"}             -> mails a reset link
+//	GET  /password-reset/confirm?token=...              -> page asking for the new password
+//	POST /password-reset/confirm  {"token", "password"} -> sets the new password
+//
+// The GET routes are where the mailed links point. They only show a form
+// that posts the token back, so a mail scanner fetching the link does not
+// use it up. The POST routes take the form's fields as well as JSON.
+func (s *Server) EnableRecovery(r *auth.Recovery) {
+	s.recovery = r
+	s.mux.HandleFunc("GET /verify-email", s.handleVerifyEmailPage)
+	s.mux.HandleFunc("POST /verify-email", s.handleVerifyEmail)
+	s.mux.HandleFunc("POST /password-reset", s.handleRequestReset)
+	s.mux.HandleFunc("GET /password-reset/confirm", s.handleResetPasswordPage)
+	s.mux.HandleFunc("POST /password-reset/confirm", s.handleResetPassword)
+}
+
+// Cookies of the OAuth login
+const (
+	oauthStateCookie = "oauth_state" // Binds a login to the browser that started it
+	oauthMFACookie   = "oauth_mfa"   // Names a login waiting for its second factor
+)
+
+// pendingMFA is an OAuth login whose user still has to enter a code
+type pendingMFA struct {
+	principal *auth.Principal
+	expiresAt time.Time
+}
+
+// maxPendingMFA bounds how many OAuth logins may wait for a code at once
+const maxPendingMFA = 10000
+
+// EnableOAuth adds sign-in through an external identity provider:
+//
+//	GET  /oauth/login              -> redirects to the provider
+//	GET  /oauth/callback           -> the provider's redirect target; sets the session cookie
+//	POST /oauth/mfa      {"code"}  -> finishes a login the callback answered with mfa_required
+func (s *Server) EnableOAuth(p *auth.OAuthProvider) {
+	s.oauth = p
+	s.mfaPending = make(map[string]pendingMFA)
+	s.mux.HandleFunc("GET /oauth/login", s.handleOAuthLogin)
+	s.mux.HandleFunc("GET /oauth/callback", s.handleOAuthCallback)
+	s.mux.HandleFunc("POST /oauth/mfa", s.handleOAuthMFA)
+}
+
+// EnableAPIKeys lets clients authenticate with "Authorization: Bearer <key>"
+// instead of the session cookie
+func (s *Server) EnableAPIKeys(k *auth.APIKeys) {
+	s.apiKeys = k
+}
+
+// ServeHTTP makes Server an http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// loginRequest is the body of POST /login
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Code     string `json:"code,omitempty"` // TOTP or recovery code, if the user has a second factor
+}
+
+// sessionResponse describes the logged-in user
+type sessionResponse struct {
+	Username  string    `json:"username"`
+	UserID    string    `json:"user_id,omitempty"`
+	Roles     []string  `json:"roles"`
+	MFA       bool      `json:"mfa"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// handleLogin checks the credentials (and second factor) and starts a
+// session
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req loginRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	p, err := s.auth.LogInWithCredFrom(clientIP(r), req.Username, req.Password)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	if p.MFARequired {
+		if req.Code == "" {
+			writeError(w, http.StatusUnauthorized, "mfa_required", "a one-time code is required")
+			return
+		}
+		if p, err = s.auth.VerifySecondFactorFrom(clientIP(r), p, req.Code); err != nil {
+			writeAuthError(w, err)
+			return
+		}
+	}
+	s.startSession(w, p)
+}
+
+// handleLogout revokes the current session and clears the cookie
+// It succeeds even without a session, so clients can always call it.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(s.CookieName); err == nil {
+		s.sessions.RevokeFrom(clientIP(r), c.Value)
+	}
+	expired := s.cookie("", time.Unix(0, 0))
+	expired.MaxAge = -1
+	http.SetCookie(w, expired)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMe returns the session behind the request's cookie
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	sess, ok := s.session(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, toResponse(sess))
+}
+
+// createUserRequest is the body of POST /users
+type createUserRequest struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// handleCreateUser signs up a new user with a password
+func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req createUserRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Password == "" {
+		writeError(w, http.StatusBadRequest, "invalid_password", "password must not be empty")
+		return
+	}
+	u, err := user.New(req.Name, req.Email)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_user", err.Error())
+		return
+	}
+	if err := s.users.Create(u); err != nil {
+		if errors.Is(err, user.ErrDuplicateEmail) {
+			writeError(w, http.StatusConflict, "email_taken", err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "could not store user")
+		return
+	}
+	if err := s.auth.SetPassword(u.Email, u.ID, req.Password); err != nil {
+		// Do not leave a user behind that can never log in
+		s.users.Delete(u.ID)
+		writeError(w, http.StatusInternalServerError, "internal", "could not store password")
+		return
+	}
+	if s.recovery != nil {
+		// The account exists either way; a lost mail can be resent later
+		if err := s.recovery.SendVerification(u); err != nil {
+			slog.Error("cannot send verification mail", "user", u.ID, "err", err)
+		}
+	}
+	w.Header().Set("Location", "/users/"+u.ID)
+	writeJSON(w, http.StatusCreated, u)
+}
+
+// handleOAuthLogin sends the browser to the identity provider
+func (s *Server) handleOAuthLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, state, err := s.oauth.AuthCodeURL()
+	if errors.Is(err, auth.ErrOAuthBusy) {
+		writeError(w, http.StatusServiceUnavailable, "busy", "too many logins in progress, retry later")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", "could not start login")
+		return
+	}
+	c := s.cookie(state, time.Now().Add(10*time.Minute))
+	c.Name = oauthStateCookie
+	c.Path = "/oauth/"
+	http.SetCookie(w, c)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleOAuthCallback finishes the login the provider redirected back with
+func (s *Server) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		writeError(w, http.StatusUnauthorized, "oauth_denied", e)
+		return
+	}
+	// The state must come back to the same browser that started the login
+	c, err := r.Cookie(oauthStateCookie)
+	if err != nil || c.Value != q.Get("state") {
+		writeError(w, http.StatusBadRequest, "oauth_state", "login was not started from this browser")
+		return
+	}
+	p, err := s.oauth.ExchangeFrom(r.Context(), clientIP(r), q.Get("state"), q.Get("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrOAuthState):
+			writeError(w, http.StatusBadRequest, "oauth_state", "login expired, please start again")
+		case errors.Is(err, auth.ErrOAuthEmailUnverified):
+			writeError(w, http.StatusForbidden, "email_unverified", err.Error())
+		case errors.Is(err, auth.ErrOAuthLinkConflict):
+			writeError(w, http.StatusConflict, "oauth_link_conflict", err.Error())
+		case errors.Is(err, auth.ErrAccountDisabled):
+			writeAuthError(w, err)
+		default:
+			writeError(w, http.StatusBadGateway, "oauth_failed", "identity provider login failed")
+		}
+		return
+	}
+	if p.MFARequired {
+		s.awaitMFA(w, p)
+		return
+	}
+	s.startSession(w, p)
+}
+
+// awaitMFA parks an OAuth login until POST /oauth/mfa brings its code
+func (s *Server) awaitMFA(w http.ResponseWriter, p *auth.Principal) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", "could not start login")
+		return
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now()
+	expires := now.Add(5 * time.Minute)
+
+	s.mfaMu.Lock()
+	for k, m := range s.mfaPending {
+		if now.After(m.expiresAt) {
+			delete(s.mfaPending, k)
+		}
+	}
+	full := len(s.mfaPending) >= maxPendingMFA
+	if !full {
+		s.mfaPending[id] = pendingMFA{principal: p, expiresAt: expires}
+	}
+	s.mfaMu.Unlock()
+	if full {
+		writeError(w, http.StatusServiceUnavailable, "busy", "too many logins in progress, retry later")
+		return
+	}
+
+	c := s.cookie(id, expires)
+	c.Name = oauthMFACookie
+	c.Path = "/oauth/"
+	http.SetCookie(w, c)
+	writeError(w, http.StatusUnauthorized, "mfa_required", "post the one-time code to /oauth/mfa")
+}
+
+// mfaRequest is the body of POST /oauth/mfa
+type mfaRequest struct {
+	Code string `json:"code"`
+}
+
+// handleOAuthMFA checks the second factor of a parked OAuth login and
+// starts its session
+// Wrong codes count against the lockout limits like any other; the login
+// stays parked until it succeeds or expires.
+func (s *Server) handleOAuthMFA(w http.ResponseWriter, r *http.Request) {
+	var req mfaRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	c, err := r.Cookie(oauthMFACookie)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "unauthenticated", "no login is waiting for a code")
+		return
+	}
+	s.mfaMu.Lock()
+	m, ok := s.mfaPending[c.Value]
+	s.mfaMu.Unlock()
+	if !ok || time.Now().After(m.expiresAt) {
+		writeError(w, http.StatusUnauthorized, "unauthenticated", "login expired, please start again")
+		return
+	}
+	p, err := s.auth.VerifySecondFactorFrom(clientIP(r), m.principal, req.Code)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	s.mfaMu.Lock()
+	delete(s.mfaPending, c.Value)
+	s.mfaMu.Unlock()
+	expired := s.cookie("", time.Unix(0, 0))
+	expired.Name, expired.Path, expired.MaxAge = oauthMFACookie, "/oauth/", -1
+	http.SetCookie(w, expired)
+	s.startSession(w, p)
+}
+
+// startSession creates a session for p and sets the session cookie
+func (s *Server) startSession(w http.ResponseWriter, p *auth.Principal) {
+	sess, err := s.sessions.Create(p)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	http.SetCookie(w, s.cookie(sess.Token, sess.ExpiresAt))
+	writeJSON(w, http.StatusOK, toResponse(sess))
+}
+
+// tokenRequest is the body of POST /verify-email and
+// POST /password-reset/confirm
+type tokenRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password,omitempty"`
+}
+
+// recoveryPage is the form behind a mailed link
+var recoveryPage = template.Must(template.New("recovery").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+{{if .AskPassword}}<p><label>New password <input type="password" name="password" autocomplete="new-password" required></label></p>
+{{end}}<p><button type="submit">{{.Button}}</button></p>
+</form>
+</body></html>
+`))
+
+// recoveryPageData fills in recoveryPage
+type recoveryPageData struct {
+	Title, Action, Button, Token string
+	AskPassword                  bool
+}
+
+// writePage renders recoveryPage
+// The token is in the page's URL, so it must not leak through the Referer
+// header to anything the page links to.
+func writePage(w http.ResponseWriter, data recoveryPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+	recoveryPage.Execute(w, data)
+}
+
+// handleVerifyEmailPage shows the page the verification mail links to
+func (s *Server) handleVerifyEmailPage(w http.ResponseWriter, r *http.Request) {
+	writePage(w, recoveryPageData{
+		Title:  "Confirm your email address",
+		Action: "/verify-email",
+		Button: "Confirm",
+		Token:  r.URL.Query().Get("token"),
+	})
+}
+
+// handleResetPasswordPage shows the page the reset mail links to
+func (s *Server) handleResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	writePage(w, recoveryPageData{
+		Title:       "Choose a new password",
+		Action:      "/password-reset/confirm",
+		Button:      "Set password",
+		Token:       r.URL.Query().Get("token"),
+		AskPassword: true,
+	})
+}
+
+// decodeToken reads a tokenRequest from a JSON body or from the form posted
+// by recoveryPage, writing a 400 on failure
+func decodeToken(w http.ResponseWriter, r *http.Request, req *tokenRequest) bool {
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/x-www-form-urlencoded" {
+		return decode(w, r, req)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid form body")
+		return false
+	}
+	req.Token = r.PostForm.Get("token")
+	req.Password = r.PostForm.Get("password")
+	return true
+}
+
+// handleVerifyEmail consumes an email verification token
+func (s *Server) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if !decodeToken(w, r, &req) {
+		return
+	}
+	u, err := s.recovery.VerifyEmail(req.Token)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// resetRequest is the body of POST /password-reset
+type resetRequest struct {
+	Email string `json:"email"`
+}
+
+// handleRequestReset mails a reset link
+// It answers 202 whether or not the address is known, and also when the
+// mail could not be sent: a different answer would tell the caller that
+// the address has an account. Such errors are logged instead.
+func (s *Server) handleRequestReset(w http.ResponseWriter, r *http.Request) {
+	var req resetRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := s.recovery.RequestPasswordReset(req.Email); err != nil {
+		slog.Error("cannot send password reset mail", "err", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleResetPassword consumes a reset token and sets the new password
+func (s *Server) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if !decodeToken(w, r, &req) {
+		return
+	}
+	if req.Password == "" {
+		writeError(w, http.StatusBadRequest, "invalid_password", "password must not be empty")
+		return
+	}
+	if err := s.recovery.ResetPassword(req.Token, req.Password); err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// session resolves the request's API key or cookie, writing a 401 if there
+// is no live session
+func (s *Server) session(w http.ResponseWriter, r *http.Request) (*auth.Session, bool) {
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && s.apiKeys != nil {
+		sess, err := s.apiKeys.GetSessionFrom(clientIP(r), key)
+		if err != nil {
+			writeAuthError(w, err)
+			return nil, false
+		}
+		return sess, true
+	}
+	c, err := r.Cookie(s.CookieName)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "unauthenticated", "no session")
+		return nil, false
+	}
+	sess, err := s.sessions.GetSession(c.Value)
+	if err != nil {
+		writeAuthError(w, err)
+		return nil, false
+	}
+	return sess, true
+}
+
+// cookie builds the session cookie
+// HttpOnly keeps it away from JavaScript; SameSite=Lax stops it riding along
+// on cross-site POSTs.
+func (s *Server) cookie(value string, expires time.Time) *http.Cookie {
+	c := &http.Cookie{
+		Name:     s.CookieName,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   !s.InsecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if !expires.IsZero() {
+		c.Expires = expires
+	}
+	return c
+}
+
+// toResponse converts a session to its JSON form
+func toResponse(sess *auth.Session) sessionResponse {
+	roles := sess.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+	return sessionResponse{
+		Username:  sess.Username,
+		UserID:    sess.UserID,
+		Roles:     roles,
+		MFA:       sess.MFA,
+		ExpiresAt: sess.ExpiresAt,
+	}
+}
+
+// writeAuthError maps errors from the auth package to status codes
+func writeAuthError(w http.ResponseWriter, err error) {
+	var lockout *auth.LockoutError
+	if errors.As(err, &lockout) {
+		secs := int((lockout.RetryAfter + time.Second - 1) / time.Second) // Round up
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+	}
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		writeError(w, http.StatusUnauthorized, "invalid_credentials", "invalid username or password")
+	case errors.Is(err, auth.ErrInvalidOTP):
+		writeError(w, http.StatusUnauthorized, "invalid_code", "invalid one-time code")
+	case errors.Is(err, auth.ErrMFAIncomplete):
+		writeError(w, http.StatusUnauthorized, "mfa_required", "a one-time code is required")
+	case errors.Is(err, auth.ErrMFANotEnrolled):
+		writeError(w, http.StatusBadRequest, "mfa_not_enrolled", "no second factor enrolled")
+	case errors.Is(err, auth.ErrLoginThrottled):
+		writeError(w, http.StatusTooManyRequests, "throttled", "too many failed attempts, retry later")
+	case errors.Is(err, auth.ErrAccountLocked):
+		writeError(w, http.StatusLocked, "locked", "temporarily locked after repeated failures")
+	case errors.Is(err, auth.ErrAccountDisabled):
+		writeError(w, http.StatusForbidden, "disabled", "account disabled")
+	case errors.Is(err, auth.ErrSessionNotFound), errors.Is(err, auth.ErrSessionExpired):
+		writeError(w, http.StatusUnauthorized, "unauthenticated", "session expired or revoked")
+	case errors.Is(err, auth.ErrInvalidAPIKey), errors.Is(err, auth.ErrAPIKeyRevoked), errors.Is(err, auth.ErrAPIKeyExpired):
+		writeError(w, http.StatusUnauthorized, "invalid_api_key", "api key invalid, revoked or expired")
+	case errors.Is(err, auth.ErrInvalidToken):
+		writeError(w, http.StatusBadRequest, "invalid_token", "invalid or expired token")
+	case errors.Is(err, auth.ErrForbidden):
+		writeError(w, http.StatusForbidden, "forbidden", "permission denied")
+	default:
+		writeError(w, http.StatusInternalServerError, "internal", "internal error")
+	}
+}
+
+// errorResponse is the body of every non-2xx response
+type errorResponse struct {
+	Error   string `json:"error"`   // Machine-readable code
+	Message string `json:"message"` // Human-readable explanation
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, errorResponse{Error: code, Message: message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// decode reads a JSON body into v, writing a 400 on failure
+// Bodies are capped at 64 KiB; nobody needs more to log in.
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid JSON body")
+		return false
+	}
+	return true
+}
+
+// clientIP returns the address used for per-source login limits
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,370 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rbrishi/Golang/auth"
+	"github.com/rbrishi/Golang/auth/fakeidp"
+	"github.com/rbrishi/Golang/mailer"
+	"github.com/rbrishi/Golang/user"
+)
+
+func TestWriteAuthError(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{auth.ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials"},
+		{&auth.LoginError{Username: "alice", Err: auth.ErrInvalidOTP}, http.StatusUnauthorized, "invalid_code"},
+		{auth.ErrMFAIncomplete, http.StatusUnauthorized, "mfa_required"},
+		{&auth.LoginError{Username: "alice", Err: auth.ErrMFANotEnrolled}, http.StatusBadRequest, "mfa_not_enrolled"},
+		{auth.ErrSessionExpired, http.StatusUnauthorized, "unauthenticated"},
+		{fmt.Errorf("disk on fire"), http.StatusInternalServerError, "internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeAuthError(rec, tt.err)
+			var body errorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.wantStatus || body.Error != tt.wantCode {
+				t.Errorf("got %d %q, want %d %q", rec.Code, body.Error, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
+
+// mailbox is a mailer.Mailer that keeps what it is sent, or fails
+type mailbox struct {
+	sent []mailer.Message
+	err  error
+}
+
+func (m *mailbox) Send(msg mailer.Message) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// mailedLink returns the path and query of the link in msg
+func mailedLink(t *testing.T, msg mailer.Message) string {
+	t.Helper()
+	i := strings.Index(msg.Body, "http://example.test")
+	if i < 0 {
+		t.Fatalf("no link in %q", msg.Body)
+	}
+	link := strings.Fields(msg.Body[i:])[0]
+	return strings.TrimPrefix(link, "http://example.test")
+}
+
+func newTestRecoveryServer(t *testing.T, mail *mailbox) (*Server, *user.User) {
+	t.Helper()
+	a := auth.NewAuthenticator(auth.NewMemoryStore())
+	a.SetHasher(auth.Argon2Hasher{Time: 1, Memory: 64, Threads: 1})
+	users := user.NewMemoryRepository()
+	sessions := auth.NewSessionManager(auth.NewMemorySessionStore())
+	u, err := user.New("Ann", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Create(u); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetPassword(u.Email, u.ID, "old password"); err != nil {
+		t.Fatal(err)
+	}
+	s := New(a, sessions, users)
+	r := auth.NewRecovery(a, sessions, users, mail)
+	r.BaseURL = "http://example.test"
+	s.EnableRecovery(r)
+	return s, u
+}
+
+func serve(s *Server, method, target, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMailedLinksWork(t *testing.T) {
+	mail := &mailbox{}
+	s, u := newTestRecoveryServer(t, mail)
+	if err := s.recovery.SendVerification(u); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serve(s, "POST", "/password-reset", "application/json", `{"email":"ann@example.com"}`); rec.Code != http.StatusAccepted {
+		t.Fatalf("reset request: got %d", rec.Code)
+	}
+	if len(mail.sent) != 2 {
+		t.Fatalf("%d mails sent, want 2", len(mail.sent))
+	}
+
+	tests := []struct {
+		name, link, form string
+		wantPage         string
+		wantStatus       int
+	}{
+		{"verify email", mailedLink(t, mail.sent[0]), "", `action="/verify-email"`, http.StatusOK},
+		{"reset password", mailedLink(t, mail.sent[1]), "&password=new+password", `name="password"`, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := serve(s, "GET", tt.link, "", "")
+			if page.Code != http.StatusOK || !strings.Contains(page.Body.String(), tt.wantPage) {
+				t.Fatalf("GET %s: got %d %q", tt.link, page.Code, page.Body)
+			}
+			target, err := url.Parse(tt.link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			form := "token=" + url.QueryEscape(target.Query().Get("token")) + tt.form
+			rec := serve(s, "POST", target.Path, "application/x-www-form-urlencoded", form)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("POST %s: got %d %q, want %d", target.Path, rec.Code, rec.Body, tt.wantStatus)
+			}
+		})
+	}
+	if _, err := s.auth.LogInWithCred(u.Email, "new password"); err != nil {
+		t.Errorf("new password: %v", err)
+	}
+}
+
+func TestResetRequestHidesMailFailures(t *testing.T) {
+	s, _ := newTestRecoveryServer(t, &mailbox{err: errors.New("smtp down")})
+	for _, email := range []string{"ann@example.com", "nobody@example.com"} {
+		if rec := serve(s, "POST", "/password-reset", "application/json", `{"email":"`+email+`"}`); rec.Code != http.StatusAccepted {
+			t.Errorf("%s: got %d, want 202", email, rec.Code)
+		}
+	}
+}
+
+func TestOAuthLoginAsksForSecondFactor(t *testing.T) {
+	mail := &mailbox{}
+	s, u := newTestRecoveryServer(t, mail)
+	enrollment, err := s.auth.EnrollTOTP(u.Email, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := auth.DefaultTOTP.Code(enrollment.Secret, time.Now())
+	if err := s.auth.ConfirmTOTP(u.Email, code); err != nil {
+		t.Fatal(err)
+	}
+	// No backoff, so the right code may follow the wrong one at once
+	s.auth.SetLockoutPolicies(auth.LockoutPolicy{MaxFailures: 5, LockoutDuration: time.Minute}, auth.DefaultSourcePolicy)
+	idp := fakeidp.New("client", "secret")
+	defer idp.Close()
+	idp.SignIn(auth.Identity{Subject: "42", Email: u.Email, EmailVerified: true})
+	s.EnableOAuth(auth.NewOAuthProvider(idp.Config("http://app.test/oauth/callback"), s.auth, s.users))
+
+	start := serve(s, "GET", "/oauth/login", "", "")
+	cb, err := idp.Authorize(start.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", cb.RequestURI(), nil)
+	for _, c := range start.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "mfa_required") {
+		t.Fatalf("callback: got %d %q, want 401 mfa_required", rec.Code, rec.Body)
+	}
+	pending := rec.Result().Cookies()
+
+	for _, tt := range []struct {
+		code       string
+		wantStatus int
+	}{
+		{"wrong", http.StatusUnauthorized},
+		{enrollment.RecoveryCodes[0], http.StatusOK},
+		{enrollment.RecoveryCodes[1], http.StatusUnauthorized}, // The login is finished
+	} {
+		req := httptest.NewRequest("POST", "/oauth/mfa", strings.NewReader(`{"code":"`+tt.code+`"}`))
+		for _, c := range pending {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("code %q: got %d %q, want %d", tt.code, rec.Code, rec.Body, tt.wantStatus)
+		}
+	}
+}
+
+// loginCookie logs in through POST /login and returns the session cookie
+func loginCookie(t *testing.T, s *Server, username, password string) *http.Cookie {
+	t.Helper()
+	rec := serve(s, "POST", "/login", "application/json", `{"username":"`+username+`","password":"`+password+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: got %d %q", rec.Code, rec.Body)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == s.CookieName {
+			return c
+		}
+	}
+	t.Fatal("login set no session cookie")
+	return nil
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name, body string
+		wantStatus int
+		wantCode   string
+	}{
+		{"success", `{"username":"ann@example.com","password":"old password"}`, http.StatusOK, ""},
+		{"username ignores case", `{"username":"Ann@Example.com","password":"old password"}`, http.StatusOK, ""},
+		{"wrong password", `{"username":"ann@example.com","password":"guess"}`, http.StatusUnauthorized, "invalid_credentials"},
+		{"unknown user", `{"username":"bob@example.com","password":"old password"}`, http.StatusUnauthorized, "invalid_credentials"},
+		{"not json", `username=ann`, http.StatusBadRequest, "bad_request"},
+		{"unknown field", `{"username":"ann@example.com","password":"old password","admin":true}`, http.StatusBadRequest, "bad_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestRecoveryServer(t, &mailbox{})
+			rec := serve(s, "POST", "/login", "application/json", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got %d %q, want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if tt.wantCode != "" {
+				var body errorResponse
+				json.NewDecoder(rec.Body).Decode(&body)
+				if body.Error != tt.wantCode {
+					t.Errorf("got error %q, want %q", body.Error, tt.wantCode)
+				}
+			}
+			if cookies := rec.Result().Cookies(); (tt.wantStatus == http.StatusOK) != (len(cookies) == 1) {
+				t.Errorf("got cookies %v", cookies)
+			}
+		})
+	}
+}
+
+func TestSessionCookieFlags(t *testing.T) {
+	for _, insecure := range []bool{false, true} {
+		t.Run(fmt.Sprint("insecure=", insecure), func(t *testing.T) {
+			s, _ := newTestRecoveryServer(t, &mailbox{})
+			s.InsecureCookies = insecure
+			c := loginCookie(t, s, "ann@example.com", "old password")
+			if !c.HttpOnly || c.Secure == insecure || c.SameSite != http.SameSiteLaxMode || c.Path != "/" {
+				t.Errorf("got HttpOnly=%v Secure=%v SameSite=%v Path=%q", c.HttpOnly, c.Secure, c.SameSite, c.Path)
+			}
+			if c.Expires.Before(time.Now()) {
+				t.Errorf("cookie expires at %v", c.Expires)
+			}
+		})
+	}
+}
+
+func TestMeAndLogout(t *testing.T) {
+	s, u := newTestRecoveryServer(t, &mailbox{})
+	c := loginCookie(t, s, "ann@example.com", "old password")
+	get := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/me", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get(nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a cookie: got %d", rec.Code)
+	}
+	if rec := get(&http.Cookie{Name: s.CookieName, Value: "forged"}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("forged cookie: got %d", rec.Code)
+	}
+	rec := get(c)
+	var me sessionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&me); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("got %d, %v", rec.Code, err)
+	}
+	if me.Username != u.Email || me.UserID != u.ID {
+		t.Errorf("got %+v", me)
+	}
+
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(c)
+	out := httptest.NewRecorder()
+	s.ServeHTTP(out, req)
+	if out.Code != http.StatusNoContent {
+		t.Fatalf("logout: got %d", out.Code)
+	}
+	cleared := out.Result().Cookies()
+	if len(cleared) != 1 || cleared[0].MaxAge >= 0 || cleared[0].Value != "" || !cleared[0].HttpOnly {
+		t.Errorf("logout set %v, want the cookie cleared", cleared)
+	}
+	if rec := get(c); rec.Code != http.StatusUnauthorized {
+		t.Errorf("after logout: got %d", rec.Code)
+	}
+	if rec := serve(s, "POST", "/logout", "", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("logout without a session: got %d", rec.Code)
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	tests := []struct {
+		name, body string
+		wantStatus int
+		wantCode   string
+	}{
+		{"created", `{"name":"Bob","email":"Bob@Example.com","password":"pw"}`, http.StatusCreated, ""},
+		{"email taken", `{"name":"Ann","email":"ANN@example.com","password":"pw"}`, http.StatusConflict, "email_taken"},
+		{"no password", `{"name":"Bob","email":"bob@example.com"}`, http.StatusBadRequest, "invalid_password"},
+		{"no name", `{"name":" ","email":"bob@example.com","password":"pw"}`, http.StatusBadRequest, "invalid_user"},
+		{"bad email", `{"name":"Bob","email":"bob","password":"pw"}`, http.StatusBadRequest, "invalid_user"},
+		{"unknown field", `{"name":"Bob","email":"bob@example.com","password":"pw","roles":["admin"]}`, http.StatusBadRequest, "bad_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mail := &mailbox{}
+			s, _ := newTestRecoveryServer(t, mail)
+			rec := serve(s, "POST", "/users", "application/json", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got %d %q, want %d", rec.Code, rec.Body, tt.wantStatus)
+			}
+			if tt.wantCode != "" {
+				var body errorResponse
+				json.NewDecoder(rec.Body).Decode(&body)
+				if body.Error != tt.wantCode {
+					t.Errorf("got error %q, want %q", body.Error, tt.wantCode)
+				}
+				if _, total, _ := s.users.List(0, 0); total != 1 {
+					t.Errorf("%d users stored, want only Ann", total)
+				}
+				return
+			}
+			var u user.User
+			if err := json.NewDecoder(rec.Body).Decode(&u); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Header().Get("Location") != "/users/"+u.ID || u.Email != "Bob@example.com" {
+				t.Errorf("got Location %q for %+v", rec.Header().Get("Location"), u)
+			}
+			if len(mail.sent) != 1 {
+				t.Errorf("%d verification mails sent, want 1", len(mail.sent))
+			}
+			loginCookie(t, s, "Bob@example.com", "pw")
+		})
+	}
+}
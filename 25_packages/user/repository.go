@@ -0,0 +1,394 @@
+// Package user (repository.go)
+// This file stores users. The Repository interface hides whether users live
+// in memory or in a JSON file, so callers can swap backends freely.
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rbrishi/Golang/internal/atomicfile"
+)
+
+// Errors returned by Repository implementations
+var (
+	ErrNotFound       = errors.New("user: not found")
+	ErrDuplicateEmail = errors.New("user: email already in use")
+	ErrDuplicateID    = errors.New("user: id already in use")
+	ErrEmptyID        = errors.New("user: id must not be empty")
+	ErrDuplicateLink  = errors.New("user: external account already linked to another user")
+)
+
+// Repository is implemented by anything that can store users
+// Emails are unique ignoring case. Implementations must be safe for
+// concurrent use and must return copies, so callers can modify a returned
+// User without affecting the stored one until they call Update.
+type Repository interface {
+	Create(u *User) error
+	GetByID(id string) (*User, error)
+	GetByEmail(email string) (*User, error)
+	// GetByExternalID returns the user linked to subject at provider
+	GetByExternalID(provider, subject string) (*User, error)
+	Update(u *User) error
+	Delete(id string) error
+	// List returns up to limit users starting at offset, ordered by
+	// creation time, together with the total number of users. A limit
+	// of zero or less returns every user from offset on.
+	List(offset, limit int) ([]*User, int, error)
+}
+
+// clone returns a copy of u that shares no memory with it
+func clone(u User) *User {
+	u.Roles = append([]string(nil), u.Roles...)
+	u.ExternalIDs = append([]ExternalID(nil), u.ExternalIDs...)
+	return &u
+}
+
+// validate checks the fields New would have checked, so users built as
+// struct literals cannot be stored half-empty
+func validate(u *User) error {
+	if u.ID == "" {
+		return ErrEmptyID
+	}
+	if _, err := normalizeName(u.Name); err != nil {
+		return err
+	}
+	email, err := NormalizeEmail(u.Email)
+	if err != nil {
+		return err
+	}
+	if email != u.Email {
+		return fmt.Errorf("%w: %q is not normalized", ErrInvalidEmail, u.Email)
+	}
+	return nil
+}
+
+// emailKey is the form of an email used for the uniqueness index
+func emailKey(email string) string {
+	return strings.ToLower(email)
+}
+
+// linkKey is the form of an ExternalID used for its index
+func linkKey(provider, subject string) string {
+	return provider + "\x00" + subject
+}
+
+// MemoryRepository is a Repository that keeps users in maps
+type MemoryRepository struct {
+	mu      sync.RWMutex      // Protects byID, byEmail and byLink
+	byID    map[string]User   // Keyed by User.ID
+	byEmail map[string]string // emailKey(User.Email) -> User.ID
+	byLink  map[string]string // linkKey of each of User.ExternalIDs -> User.ID
+}
+
+// NewMemoryRepository returns an empty MemoryRepository
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{byID: make(map[string]User), byEmail: make(map[string]string), byLink: make(map[string]string)}
+}
+
+// linkTaken reports whether one of u's external IDs belongs to another user.
+// The caller must hold r.mu.
+func (r *MemoryRepository) linkTaken(u *User) bool {
+	for _, l := range u.ExternalIDs {
+		if id, ok := r.byLink[linkKey(l.Provider, l.Subject)]; ok && id != u.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// setLinks replaces the index entries of old's external IDs with u's.
+// The caller must hold r.mu.
+func (r *MemoryRepository) setLinks(old, u *User) {
+	if old != nil {
+		for _, l := range old.ExternalIDs {
+			delete(r.byLink, linkKey(l.Provider, l.Subject))
+		}
+	}
+	if u != nil {
+		for _, l := range u.ExternalIDs {
+			r.byLink[linkKey(l.Provider, l.Subject)] = u.ID
+		}
+	}
+}
+
+// Create stores a new user
+// u must have an ID, a name and a normalized email, as New returns.
+func (r *MemoryRepository) Create(u *User) error {
+	if err := validate(u); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.byID[u.ID]; ok {
+		return ErrDuplicateID
+	}
+	if _, ok := r.byEmail[emailKey(u.Email)]; ok {
+		return ErrDuplicateEmail
+	}
+	if r.linkTaken(u) {
+		return ErrDuplicateLink
+	}
+	r.byID[u.ID] = *clone(*u)
+	r.byEmail[emailKey(u.Email)] = u.ID
+	r.setLinks(nil, u)
+	return nil
+}
+
+// GetByID returns the user with the given ID
+func (r *MemoryRepository) GetByID(id string) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	u, ok := r.byID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(u), nil
+}
+
+// GetByEmail returns the user with the given email, ignoring case
+func (r *MemoryRepository) GetByEmail(email string) (*User, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	id, ok := r.byEmail[emailKey(email)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(r.byID[id]), nil
+}
+
+// GetByExternalID returns the user linked to subject at provider
+func (r *MemoryRepository) GetByExternalID(provider, subject string) (*User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	id, ok := r.byLink[linkKey(provider, subject)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(r.byID[id]), nil
+}
+
+// Update replaces the stored user that has u.ID
+func (r *MemoryRepository) Update(u *User) error {
+	if err := validate(u); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, ok := r.byID[u.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	newKey := emailKey(u.Email)
+	if id, taken := r.byEmail[newKey]; taken && id != u.ID {
+		return ErrDuplicateEmail
+	}
+	if r.linkTaken(u) {
+		return ErrDuplicateLink
+	}
+	delete(r.byEmail, emailKey(old.Email))
+	r.byEmail[newKey] = u.ID
+	r.setLinks(&old, u)
+	r.byID[u.ID] = *clone(*u)
+	return nil
+}
+
+// Delete removes the user with the given ID
+func (r *MemoryRepository) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.byID[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(r.byEmail, emailKey(u.Email))
+	r.setLinks(&u, nil)
+	delete(r.byID, id)
+	return nil
+}
+
+// List returns one page of users ordered by creation time
+func (r *MemoryRepository) List(offset, limit int) ([]*User, int, error) {
+	r.mu.RLock()
+	all := r.sorted()
+	r.mu.RUnlock()
+
+	total := len(all)
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+	page := make([]*User, 0, end-offset)
+	for i := offset; i < end; i++ {
+		page = append(page, clone(all[i]))
+	}
+	return page, total, nil
+}
+
+// sorted returns a copy of every user ordered by CreatedAt, then ID.
+// The caller must hold r.mu.
+func (r *MemoryRepository) sorted() []User {
+	all := make([]User, 0, len(r.byID))
+	for _, u := range r.byID {
+		all = append(all, u)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].CreatedAt.Equal(all[j].CreatedAt) {
+			return all[i].CreatedAt.Before(all[j].CreatedAt)
+		}
+		return all[i].ID < all[j].ID
+	})
+	return all
+}
+
+// FileRepository is a Repository backed by a JSON file
+// Like auth.FileStore, it keeps the users in memory and rewrites the file
+// atomically after every change.
+type FileRepository struct {
+	path string
+	mem  *MemoryRepository    // In-memory copy of the file contents
+	mu   sync.Mutex           // Serializes writes so the file matches mem
+	lock *atomicfile.FileLock // Keeps other FileRepositories off the file until Close
+}
+
+// OpenFileRepository loads the users in path
+// A missing file is treated as an empty repository and created on the first
+// write. Until Close the repository holds path's lock, and opening the
+// same file again fails rather than let two copies overwrite each other.
+func OpenFileRepository(path string) (*FileRepository, error) {
+	lock, err := atomicfile.Lock(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	r := &FileRepository{path: path, mem: NewMemoryRepository(), lock: lock}
+	if err := r.load(); err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads the file into r.mem
+func (r *FileRepository) load() error {
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var users []User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return err
+	}
+	for i := range users {
+		if err := r.mem.Create(&users[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the file for other repositories
+func (r *FileRepository) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lock.Unlock()
+}
+
+// Create stores a new user and persists the repository
+func (r *FileRepository) Create(u *User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.mem.Create(u); err != nil {
+		return err
+	}
+	if err := r.save(); err != nil {
+		r.mem.Delete(u.ID)
+		return err
+	}
+	return nil
+}
+
+// GetByID returns the user with the given ID
+func (r *FileRepository) GetByID(id string) (*User, error) {
+	return r.mem.GetByID(id)
+}
+
+// GetByEmail returns the user with the given email, ignoring case
+func (r *FileRepository) GetByEmail(email string) (*User, error) {
+	return r.mem.GetByEmail(email)
+}
+
+// GetByExternalID returns the user linked to subject at provider
+func (r *FileRepository) GetByExternalID(provider, subject string) (*User, error) {
+	return r.mem.GetByExternalID(provider, subject)
+}
+
+// Update replaces the stored user that has u.ID and persists the repository
+func (r *FileRepository) Update(u *User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, err := r.mem.GetByID(u.ID)
+	if err != nil {
+		return err
+	}
+	if err := r.mem.Update(u); err != nil {
+		return err
+	}
+	if err := r.save(); err != nil {
+		r.mem.Update(old)
+		return err
+	}
+	return nil
+}
+
+// Delete removes the user with the given ID and persists the repository
+func (r *FileRepository) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, err := r.mem.GetByID(id)
+	if err != nil {
+		return err
+	}
+	r.mem.Delete(id)
+	if err := r.save(); err != nil {
+		r.mem.Create(old)
+		return err
+	}
+	return nil
+}
+
+// List returns one page of users ordered by creation time
+func (r *FileRepository) List(offset, limit int) ([]*User, int, error) {
+	return r.mem.List(offset, limit)
+}
+
+// save writes every user to the file in creation order.
+// The caller must hold r.mu.
+func (r *FileRepository) save() error {
+	r.mem.mu.RLock()
+	all := r.mem.sorted()
+	r.mem.mu.RUnlock()
+
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(r.path, data, 0o600)
+}
@@ -0,0 +1,242 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCreateValidates(t *testing.T) {
+	valid, err := New("Ann", "ann@Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		edit    func(u *User)
+		wantErr error
+	}{
+		{"valid", func(u *User) {}, nil},
+		{"empty id", func(u *User) { u.ID = "" }, ErrEmptyID},
+		{"empty name", func(u *User) { u.Name = " " }, ErrEmptyName},
+		{"empty email", func(u *User) { u.Email = "" }, ErrInvalidEmail},
+		{"bad email", func(u *User) { u.Email = "ann" }, ErrInvalidEmail},
+		{"email not normalized", func(u *User) { u.Email = "ann@EXAMPLE.com" }, ErrInvalidEmail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := *valid
+			tt.edit(&u)
+			r := NewMemoryRepository()
+			if err := r.Create(&u); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if _, total, _ := r.List(0, 10); tt.wantErr != nil && total != 0 {
+				t.Errorf("rejected user was stored")
+			}
+		})
+	}
+}
+
+func TestUpdateValidates(t *testing.T) {
+	u, err := New("Ann", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewMemoryRepository()
+	if err := r.Create(u); err != nil {
+		t.Fatal(err)
+	}
+	u.Email = ""
+	if err := r.Update(u); !errors.Is(err, ErrInvalidEmail) {
+		t.Fatalf("got %v, want ErrInvalidEmail", err)
+	}
+	if got, _ := r.GetByID(u.ID); got.Email != "ann@example.com" {
+		t.Errorf("email changed to %q", got.Email)
+	}
+}
+
+func TestCreateRejectsDuplicateEmail(t *testing.T) {
+	r := NewMemoryRepository()
+	ann, err := New("Ann", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Create(ann); err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []string{"ann@example.com", "Ann@Example.com", "ANN@EXAMPLE.COM"} {
+		u, err := New("Other Ann", email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Create(u); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("%s: got %v, want ErrDuplicateEmail", email, err)
+		}
+	}
+	if got, err := r.GetByEmail("ANN@example.com"); err != nil || got.ID != ann.ID {
+		t.Errorf("lookup ignoring case: got %v, %v", got, err)
+	}
+}
+
+// newListedRepository stores n users created one second apart
+func newListedRepository(t *testing.T, n int) *MemoryRepository {
+	t.Helper()
+	r := NewMemoryRepository()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range n {
+		u, err := New(fmt.Sprint("user", i), fmt.Sprintf("user%d@example.com", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		u.CreatedAt = start.Add(time.Duration(i) * time.Second)
+		if err := r.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestList(t *testing.T) {
+	r := newListedRepository(t, 5)
+	tests := []struct {
+		offset, limit int
+		want          []string
+	}{
+		{0, 2, []string{"user0", "user1"}},
+		{2, 2, []string{"user2", "user3"}},
+		{4, 2, []string{"user4"}},
+		{5, 2, nil},
+		{9, 2, nil},
+		{-1, 1, []string{"user0"}},
+		{3, 0, []string{"user3", "user4"}},
+		{0, -1, []string{"user0", "user1", "user2", "user3", "user4"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("offset %d limit %d", tt.offset, tt.limit), func(t *testing.T) {
+			page, total, err := r.List(tt.offset, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, u := range page {
+				names = append(names, u.Name)
+			}
+			if total != 5 || fmt.Sprint(names) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v of %d, want %v of 5", names, total, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileRepositoryReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	r, err := OpenFileRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := New("Ann", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Roles = []string{"admin"}
+	if err := r.Create(u); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = OpenFileRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := r.GetByEmail("ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != u.ID || got.Name != "Ann" || len(got.Roles) != 1 || !got.CreatedAt.Equal(u.CreatedAt) {
+		t.Errorf("got %+v after reopening, want %+v", got, u)
+	}
+}
+
+func TestFileRepositoryRollsBackFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	r, err := OpenFileRepository(filepath.Join(dir, "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ann, err := New("Ann", "ann@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Create(ann); err != nil {
+		t.Fatal(err)
+	}
+	// Without its directory the file cannot be rewritten
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := New("Bob", "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Create(bob); err == nil {
+		t.Fatal("Create succeeded without a directory to write to")
+	}
+	if _, err := r.GetByID(bob.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("failed Create left the user behind: %v", err)
+	}
+	renamed := *ann
+	renamed.Name = "Annie"
+	if err := r.Update(&renamed); err == nil {
+		t.Fatal("Update succeeded without a directory to write to")
+	}
+	if err := r.Delete(ann.ID); err == nil {
+		t.Fatal("Delete succeeded without a directory to write to")
+	}
+	if got, err := r.GetByID(ann.ID); err != nil || got.Name != "Ann" {
+		t.Errorf("got %v, %v; want Ann unchanged", got, err)
+	}
+}
+
+func TestExternalIDsAreUnique(t *testing.T) {
+	r := NewMemoryRepository()
+	ann, _ := New("Ann", "ann@example.com")
+	ann.LinkExternalID("google", "42")
+	if err := r.Create(ann); err != nil {
+		t.Fatal(err)
+	}
+	bob, _ := New("Bob", "bob@example.com")
+	bob.LinkExternalID("google", "42")
+	if err := r.Create(bob); !errors.Is(err, ErrDuplicateLink) {
+		t.Fatalf("got %v, want ErrDuplicateLink", err)
+	}
+	bob.LinkExternalID("google", "43")
+	if err := r.Create(bob); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.GetByExternalID("google", "43"); err != nil || got.ID != bob.ID {
+		t.Fatalf("got %v, %v", got, err)
+	}
+
+	ann.LinkExternalID("google", "44") // Relinking frees the old subject
+	if err := r.Update(ann); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetByExternalID("google", "42"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("old link still found: %v", err)
+	}
+	if err := r.Delete(bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetByExternalID("google", "43"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted user still found: %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+// Package user (role.go)
+// This file models authorization: a Role is a named set of permissions that
+// may inherit the permissions of other roles, and a User holds any number
+// of roles.
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned while defining or loading roles
+var (
+	ErrUnknownRole   = errors.New("user: unknown role")
+	ErrRoleCycle     = errors.New("user: role inheritance cycle")
+	ErrEmptyRoleName = errors.New("user: role name must not be empty")
+)
+
+// Permission names an action, conventionally "resource:verb" such as
+// "users:read"
+type Permission string
+
+// AllPermissions grants every permission to a role that holds it
+const AllPermissions Permission = "*"
+
+// Role is a named set of permissions
+type Role struct {
+	Name        string       `json:"name"`
+	Permissions []Permission `json:"permissions,omitempty"`
+	Inherits    []string     `json:"inherits,omitempty"` // Roles whose permissions this role also has
+}
+
+// Roles is a set of role definitions
+// It is safe for concurrent use.
+type Roles struct {
+	mu    sync.RWMutex    // Protects roles
+	roles map[string]Role // Keyed by Role.Name
+}
+
+// NewRoles returns an empty set of roles
+func NewRoles() *Roles {
+	return &Roles{roles: make(map[string]Role)}
+}
+
+// DefaultRoles holds the role definitions used by auth.Authorize
+var DefaultRoles = NewRoles()
+
+// LoadRoles reads role definitions from a JSON file holding an array of
+// roles, for example:
+//
+//	[
+//	  {"name": "viewer", "permissions": ["users:read"]},
+//	  {"name": "admin", "inherits": ["viewer"], "permissions": ["users:write"]}
+//	]
+//
+// Inheritance is checked once everything is loaded, so roles may appear in
+// any order.
+func LoadRoles(path string) (*Roles, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var defs []Role
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("user: parsing %s: %w", path, err)
+	}
+	r := NewRoles()
+	for _, def := range defs {
+		if err := r.Define(def); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Define adds or replaces a role
+// Inherited roles need not exist yet; call Validate after defining them all.
+func (r *Roles) Define(role Role) error {
+	role.Name = strings.TrimSpace(role.Name)
+	if role.Name == "" {
+		return ErrEmptyRoleName
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.roles[role.Name] = role
+	return nil
+}
+
+// Get returns the role with the given name
+func (r *Roles) Get(name string) (Role, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	role, ok := r.roles[name]
+	return role, ok
+}
+
+// Names returns the names of all defined roles, sorted
+func (r *Roles) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.roles))
+	for name := range r.roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks that every inherited role exists and that no role
+// inherits from itself, directly or indirectly
+func (r *Roles) Validate() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// Depth-first search colouring: 1 = on the current path, 2 = done
+	state := make(map[string]int)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		role, ok := r.roles[name]
+		if !ok {
+			return fmt.Errorf("%w %q (inherited by %q)", ErrUnknownRole, name, path[len(path)-1])
+		}
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("%w: %s", ErrRoleCycle, strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, parent := range role.Inherits {
+			if err := visit(parent, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		return nil
+	}
+	for name := range r.roles {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Permissions returns every permission granted by the named roles,
+// including inherited ones
+// Unknown roles grant nothing.
+func (r *Roles) Permissions(names ...string) map[Permission]bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	perms := make(map[Permission]bool)
+	seen := make(map[string]bool) // Also guards against cycles
+	var walk func(name string)
+	walk = func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		role := r.roles[name]
+		for _, p := range role.Permissions {
+			perms[p] = true
+		}
+		for _, parent := range role.Inherits {
+			walk(parent)
+		}
+	}
+	for _, name := range names {
+		walk(name)
+	}
+	return perms
+}
+
+// Allows reports whether the named roles grant p
+func (r *Roles) Allows(names []string, p Permission) bool {
+	perms := r.Permissions(names...)
+	return perms[p] || perms[AllPermissions]
+}
+
+// HasRole reports whether the user holds the named role
+func (u *User) HasRole(name string) bool {
+	for _, r := range u.Roles {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AddRole gives the user a role; adding a role twice has no effect
+func (u *User) AddRole(name string) {
+	if !u.HasRole(name) {
+		u.Roles = append(u.Roles, name)
+		u.UpdatedAt = time.Now().UTC()
+	}
+}
+
+// RemoveRole takes a role away from the user
+func (u *User) RemoveRole(name string) {
+	for i, r := range u.Roles {
+		if r == name {
+			u.Roles = append(u.Roles[:i:i], u.Roles[i+1:]...)
+			u.UpdatedAt = time.Now().UTC()
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package user
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestRolesValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		roles   []Role
+		wantErr error
+	}{
+		{"flat", []Role{{Name: "viewer"}, {Name: "editor"}}, nil},
+		{"chain", []Role{{Name: "admin", Inherits: []string{"editor"}}, {Name: "editor", Inherits: []string{"viewer"}}, {Name: "viewer"}}, nil},
+		{"diamond", []Role{{Name: "a", Inherits: []string{"b", "c"}}, {Name: "b", Inherits: []string{"d"}}, {Name: "c", Inherits: []string{"d"}}, {Name: "d"}}, nil},
+		{"unknown parent", []Role{{Name: "admin", Inherits: []string{"ghost"}}}, ErrUnknownRole},
+		{"self", []Role{{Name: "admin", Inherits: []string{"admin"}}}, ErrRoleCycle},
+		{"cycle", []Role{{Name: "a", Inherits: []string{"b"}}, {Name: "b", Inherits: []string{"c"}}, {Name: "c", Inherits: []string{"a"}}}, ErrRoleCycle},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRoles()
+			for _, role := range tt.roles {
+				if err := r.Define(role); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := r.Validate(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefineRejectsEmptyName(t *testing.T) {
+	if err := NewRoles().Define(Role{Name: "  "}); !errors.Is(err, ErrEmptyRoleName) {
+		t.Fatalf("got %v, want ErrEmptyRoleName", err)
+	}
+}
+
+// testRoles is viewer < editor < admin, plus a root role holding "*"
+func testRoles(t *testing.T) *Roles {
+	t.Helper()
+	r := NewRoles()
+	for _, role := range []Role{
+		{Name: "viewer", Permissions: []Permission{"users:read"}},
+		{Name: "editor", Inherits: []string{"viewer"}, Permissions: []Permission{"users:write"}},
+		{Name: "admin", Inherits: []string{"editor"}, Permissions: []Permission{"roles:write"}},
+		{Name: "root", Permissions: []Permission{AllPermissions}},
+	} {
+		if err := r.Define(role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestRolesPermissions(t *testing.T) {
+	r := testRoles(t)
+	tests := []struct {
+		roles []string
+		want  []Permission
+	}{
+		{[]string{"viewer"}, []Permission{"users:read"}},
+		{[]string{"admin"}, []Permission{"roles:write", "users:read", "users:write"}},
+		{[]string{"viewer", "editor"}, []Permission{"users:read", "users:write"}},
+		{[]string{"ghost"}, nil},
+		{nil, nil},
+	}
+	for _, tt := range tests {
+		perms := r.Permissions(tt.roles...)
+		var got []Permission
+		for p := range perms {
+			got = append(got, p)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+		if len(got) != len(tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.roles, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%v: got %v, want %v", tt.roles, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRolesAllows(t *testing.T) {
+	r := testRoles(t)
+	tests := []struct {
+		roles []string
+		perm  Permission
+		want  bool
+	}{
+		{[]string{"viewer"}, "users:read", true},
+		{[]string{"viewer"}, "users:write", false},
+		{[]string{"admin"}, "users:read", true},
+		{[]string{"editor"}, "roles:write", false},
+		{[]string{"root"}, "anything:at-all", true},
+		{[]string{"ghost"}, "users:read", false},
+	}
+	for _, tt := range tests {
+		if got := r.Allows(tt.roles, tt.perm); got != tt.want {
+			t.Errorf("%v may %s: got %v, want %v", tt.roles, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestLoadRoles(t *testing.T) {
+	tests := []struct {
+		name, json string
+		wantErr    error
+	}{
+		{"parent after child", `[{"name":"admin","inherits":["viewer"]},{"name":"viewer","permissions":["users:read"]}]`, nil},
+		{"unknown parent", `[{"name":"admin","inherits":["viewer"]}]`, ErrUnknownRole},
+		{"cycle", `[{"name":"a","inherits":["b"]},{"name":"b","inherits":["a"]}]`, ErrRoleCycle},
+		{"empty name", `[{"name":""}]`, ErrEmptyRoleName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "roles.json")
+			if err := os.WriteFile(path, []byte(tt.json), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			r, err := LoadRoles(path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !r.Allows([]string{"admin"}, "users:read") {
+				t.Error("admin did not inherit users:read")
+			}
+		})
+	}
+}
@@ -1,12 +1,151 @@
 // Package user defines user-related types and functionality
 package user
 
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Validation errors returned by New and the setters
+var (
+	ErrEmptyName    = errors.New("user: name must not be empty")
+	ErrInvalidEmail = errors.New("user: invalid email address")
+)
+
 // User is an exported struct type (starts with capital letter)
 // It demonstrates:
 // 1. Exported struct type with exported fields
 // 2. Go's struct definition syntax
 // 3. Field naming and visibility
+// Build values with New rather than a struct literal so that the name and
+// email are validated and the ID and timestamps are filled in.
 type User struct {
-	Name string  // Exported field, accessible from other packages
-	Email string // Exported field, accessible from other packages
+	ID    string `json:"id"`    // Random, stable identifier assigned by New
+	Name  string `json:"name"`  // Exported field, accessible from other packages
+	Email string `json:"email"` // Normalized address, see NormalizeEmail
+	// EmailVerified is set once the user has proved they receive mail at
+	// Email; changing the address clears it
+	EmailVerified bool      `json:"email_verified"`
+	Roles         []string  `json:"roles"`      // Names of roles defined in a Roles set
+	CreatedAt     time.Time `json:"created_at"` // When New was called
+	UpdatedAt     time.Time `json:"updated_at"` // Last change made through a setter
+	// ExternalIDs are accounts at identity providers that sign in as this
+	// user; each one belongs to at most one user
+	ExternalIDs []ExternalID `json:"external_ids,omitempty"`
+}
+
+// ExternalID names an account at an outside identity provider
+type ExternalID struct {
+	Provider string `json:"provider"` // Provider name, e.g. "google"
+	Subject  string `json:"subject"`  // The provider's stable ID for the account
+}
+
+// ExternalID returns the subject linked to the user at provider, if any
+func (u *User) ExternalID(provider string) (string, bool) {
+	for _, id := range u.ExternalIDs {
+		if id.Provider == provider {
+			return id.Subject, true
+		}
+	}
+	return "", false
+}
+
+// LinkExternalID records that subject at provider signs in as the user,
+// replacing any earlier link to the same provider
+func (u *User) LinkExternalID(provider, subject string) {
+	for i, id := range u.ExternalIDs {
+		if id.Provider == provider {
+			u.ExternalIDs[i].Subject = subject
+			u.UpdatedAt = time.Now().UTC()
+			return
+		}
+	}
+	u.ExternalIDs = append(u.ExternalIDs, ExternalID{Provider: provider, Subject: subject})
+	u.UpdatedAt = time.Now().UTC()
+}
+
+// New returns a validated User with a fresh ID
+// Parameters:
+//   - name: display name; surrounding spaces are trimmed and it must not be empty
+//   - email: address in any form accepted by net/mail, e.g. "Ann <ann@Example.com>"
+func New(name, email string) (*User, error) {
+	name, err := normalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+	email, err = NormalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	return &User{ID: id, Name: name, Email: email, CreatedAt: now, UpdatedAt: now}, nil
+}
+
+// SetName validates and changes the user's name
+func (u *User) SetName(name string) error {
+	name, err := normalizeName(name)
+	if err != nil {
+		return err
+	}
+	u.Name = name
+	u.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// SetEmail validates, normalizes and changes the user's email address
+func (u *User) SetEmail(email string) error {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	if email != u.Email {
+		u.EmailVerified = false
+	}
+	u.Email = email
+	u.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// NormalizeEmail parses email as an RFC 5322 address and returns the bare
+// address with its domain lower-cased
+// The local part (before the @) is left alone because RFC 5321 allows
+// mail servers to treat it case-sensitively.
+func NormalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidEmail, err)
+	}
+	at := strings.LastIndex(addr.Address, "@")
+	if at <= 0 || at == len(addr.Address)-1 {
+		return "", ErrInvalidEmail
+	}
+	return addr.Address[:at] + "@" + strings.ToLower(addr.Address[at+1:]), nil
+}
+
+// normalizeName trims name and rejects empty names
+func normalizeName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", ErrEmptyName
+	}
+	return name, nil
+}
+
+// newID returns a random RFC 4122 version 4 UUID
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
 }
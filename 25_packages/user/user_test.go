@@ -0,0 +1,88 @@
+package user
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr error
+	}{
+		{"ann@example.com", "ann@example.com", nil},
+		{"Ann.Smith@EXAMPLE.Com", "Ann.Smith@example.com", nil},
+		{"  ann@example.com  ", "ann@example.com", nil},
+		{"Ann Smith <Ann@Example.COM>", "Ann@example.com", nil},
+		{`"Smith, Ann" <ann@example.com>`, "ann@example.com", nil},
+		{"", "", ErrInvalidEmail},
+		{"ann", "", ErrInvalidEmail},
+		{"ann@", "", ErrInvalidEmail},
+		{"@example.com", "", ErrInvalidEmail},
+		{"ann@example.com, bob@example.com", "", ErrInvalidEmail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := NormalizeEmail(tt.in)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name, email string
+		wantName    string
+		wantEmail   string
+		wantErr     error
+	}{
+		{"Ann", "Ann@Example.com", "Ann", "Ann@example.com", nil},
+		{"  Ann  ", "Ann <ann@example.com>", "Ann", "ann@example.com", nil},
+		{"", "ann@example.com", "", "", ErrEmptyName},
+		{" \t", "ann@example.com", "", "", ErrEmptyName},
+		{"Ann", "not an address", "", "", ErrInvalidEmail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.email, func(t *testing.T) {
+			u, err := New(tt.name, tt.email)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if u.Name != tt.wantName || u.Email != tt.wantEmail {
+				t.Errorf("got %q <%s>, want %q <%s>", u.Name, u.Email, tt.wantName, tt.wantEmail)
+			}
+			if u.CreatedAt.IsZero() || !u.UpdatedAt.Equal(u.CreatedAt) {
+				t.Errorf("got timestamps %v, %v", u.CreatedAt, u.UpdatedAt)
+			}
+		})
+	}
+}
+
+var uuidV4 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestNewAssignsUUIDv4(t *testing.T) {
+	seen := make(map[string]bool)
+	for range 100 {
+		u, err := New("Ann", "ann@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !uuidV4.MatchString(u.ID) {
+			t.Fatalf("ID %q is not a version 4 UUID", u.ID)
+		}
+		if seen[u.ID] {
+			t.Fatalf("ID %q assigned twice", u.ID)
+		}
+		seen[u.ID] = true
+	}
+}